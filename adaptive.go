@@ -0,0 +1,154 @@
+package letterbox
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// adaptiveLimiter limits the number of in-flight images to a limit which
+// is adjusted at runtime, hill-climbing towards the highest throughput.
+type adaptiveLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	inflight int
+	done     int64
+}
+
+// newAdaptiveLimiter returns a limiter adjusting between 1 and max,
+// starting half way.
+func newAdaptiveLimiter(max int) *adaptiveLimiter {
+	if max < 1 {
+		max = 1
+	}
+
+	l := &adaptiveLimiter{
+		limit: (max + 1) / 2,
+		max:   max,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// Acquire blocks until an image may be processed or the context is cancelled.
+func (l *adaptiveLimiter) Acquire(ctx context.Context) error {
+	// wake waiters on cancellation
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	})
+	defer stop()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.inflight >= l.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+
+	l.inflight++
+	return nil
+}
+
+// Release an image, recording its completion.
+func (l *adaptiveLimiter) Release() {
+	atomic.AddInt64(&l.done, 1)
+	l.mu.Lock()
+	l.inflight--
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
+// Limit returns the current limit.
+func (l *adaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// setLimit changes the limit, clamped between 1 and max.
+func (l *adaptiveLimiter) setLimit(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	if n > l.max {
+		n = l.max
+	}
+
+	l.mu.Lock()
+	l.limit = n
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
+// control adjusts the limit every interval until the context is cancelled. The
+// limit moves in one direction while throughput improves and reverses when it
+// drops, backing off whenever the heap nears the runtime memory limit.
+func (l *adaptiveLimiter) control(ctx context.Context, interval time.Duration, log *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prev float64
+	var done int64
+	dir := 1
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// throughput
+		n := atomic.LoadInt64(&l.done)
+		throughput := float64(n-done) / interval.Seconds()
+		done = n
+
+		// memory headroom
+		limit := l.Limit()
+		if memoryPressure() {
+			dir = -1
+			l.setLimit(limit - 1)
+			if n := l.Limit(); n != limit {
+				log.Debug("Concurrency reduced under memory pressure", "limit", n)
+			}
+			continue
+		}
+
+		// reverse when throughput drops
+		if throughput < prev*0.95 {
+			dir = -dir
+		}
+
+		prev = throughput
+		l.setLimit(limit + dir)
+		if n := l.Limit(); n != limit {
+			log.Debug("Concurrency adjusted", "limit", n, "throughput", math.Round(throughput*100)/100)
+		}
+	}
+}
+
+// memoryPressure returns true if the heap exceeds 80% of the runtime memory
+// limit, as set by GOMEMLIMIT. There is never pressure without a limit.
+func memoryPressure() bool {
+	limit := debug.SetMemoryLimit(-1)
+	if limit == math.MaxInt64 {
+		return false
+	}
+
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return float64(m.HeapAlloc) > float64(limit)*0.8
+}
@@ -0,0 +1,15 @@
+package letterbox
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// atime returns the access time of the file.
+func atime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atim.Unix())
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package letterbox
+
+import (
+	"os"
+	"time"
+)
+
+// atime returns the modification time as the access time is unavailable.
+func atime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,56 @@
+package letterbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeAtomic writes the file at path using fn, via a temporary file in the
+// same directory which is synced and renamed into place, so that readers and
+// skip policies never observe a partially written file.
+func writeAtomic(path string, fn func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating: %w", err)
+	}
+
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if err := fn(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("setting mode: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("syncing: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("renaming: %w", err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes b to the file at path atomically.
+func writeFileAtomic(path string, b []byte) error {
+	return writeAtomic(path, func(w io.Writer) error {
+		_, err := io.Copy(w, bytes.NewReader(b))
+		return err
+	})
+}
@@ -0,0 +1,48 @@
+package letterbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WithBackups changes the directory existing outputs are preserved in before
+// being overwritten, mirroring their path relative to the output directory,
+// so that a run may be reverted from its report. Outputs written in place of
+// their source preserve the original. Defaults to "" for none.
+func WithBackups(dir string) Option {
+	return func(p *Processor) error {
+		p.backups = dir
+		return nil
+	}
+}
+
+// backup preserves the existing output at path, replacing any previous
+// backup of it, and returns the backup path. Outputs are replaced by
+// renaming, so a hard link preserves the original without copying.
+func (p *Processor) backup(path string) (string, error) {
+	rel, err := filepath.Rel(p.dir, path)
+	if err != nil {
+		return "", err
+	}
+
+	dst := filepath.Join(p.backups, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// hard link when possible, falling back to a copy
+	if err := os.Link(path, dst); err == nil {
+		return dst, nil
+	}
+
+	if err := copyFile(path, dst); err != nil {
+		return "", fmt.Errorf("copying: %w", err)
+	}
+
+	return dst, nil
+}
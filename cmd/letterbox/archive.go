@@ -0,0 +1,359 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveExts are the extensions of supported archives.
+var archiveExts = []string{".zip", ".tar", ".tar.gz", ".tgz"}
+
+// archiveExt returns the archive extension of path, or "" when it is not an archive.
+func archiveExt(path string) string {
+	lower := strings.ToLower(path)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// archives extracts archive inputs into dir, each into a directory of its
+// name without the extension. It returns the images with archives replaced by
+// the images they contain, and the archives keyed by their directory.
+func archives(images []string, dir string) ([]string, map[string]string, error) {
+	var list []string
+	extracted := make(map[string]string)
+
+	for _, path := range images {
+		ext := archiveExt(path)
+		if ext == "" {
+			list = append(list, path)
+			continue
+		}
+
+		name := filepath.Base(path)
+		dst := filepath.Join(dir, name[:len(name)-len(ext)])
+		if other, ok := extracted[dst]; ok {
+			return nil, nil, fmt.Errorf("archives %s and %s have the same name", other, path)
+		}
+		extracted[dst] = path
+
+		if err := extract(path, dst); err != nil {
+			return nil, nil, fmt.Errorf("extracting %s: %w", path, err)
+		}
+
+		contained, err := walkImages(dst)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		logger.Debug("Extracted archive", "path", path, "images", len(contained))
+		list = append(list, contained...)
+	}
+
+	return list, extracted, nil
+}
+
+// archived returns path named by the archive of the directory containing
+// it, such as "photos.zip/a.jpg", or path itself when not within one.
+func archived(path string, dirs map[string]string) string {
+	for dir, archive := range dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.Join(archive, rel)
+		}
+	}
+	return path
+}
+
+// extract the images of the archive at path into dir.
+func extract(path, dir string) error {
+	if archiveExt(path) == ".zip" {
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		for _, f := range r.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+
+			err = extractFile(dir, f.Name, f.Modified, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if archiveExt(path) != ".tar" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := extractFile(dir, h.Name, h.ModTime, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// extractFile writes the archive entry name to dir when it is an image,
+// with its modification time so that skip policies compare against it.
+// Entries which would escape dir are rejected.
+func extractFile(dir, name string, mtime time.Time, r io.Reader) error {
+	if !isImage(name) {
+		return nil
+	}
+
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("entry %q escapes the archive", name)
+	}
+
+	path := filepath.Join(dir, clean)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, mtime, mtime)
+}
+
+// walkImages returns the images within dir and its sub-directories.
+func walkImages(dir string) (images []string, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() && isImage(path) {
+			images = append(images, path)
+		}
+
+		return nil
+	})
+
+	return
+}
+
+// writeArchive writes the files within dir to the archive at path, excluding
+// dotfiles such as state, with entries relative to dir in sorted order.
+func writeArchive(path, dir string) error {
+	var files []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && p != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.IsDir() {
+			files = append(files, p)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(files)
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if archiveExt(path) == ".zip" {
+		err = writeZip(f, dir, files)
+	} else {
+		err = writeTar(f, dir, files, archiveExt(path) != ".tar")
+	}
+
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// writeZip writes the files to w as a zip. Images are stored rather than
+// deflated, as they're compressed already.
+func writeZip(w io.Writer, dir string, files []string) error {
+	zw := zip.NewWriter(w)
+
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		h, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+
+		h.Name, err = entryName(dir, path)
+		if err != nil {
+			return err
+		}
+
+		h.Method = zip.Deflate
+		if isImage(path) {
+			h.Method = zip.Store
+		}
+
+		fw, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+
+		if err := copyInto(fw, path); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// writeTar writes the files to w as a tar, optionally gzipped.
+func writeTar(w io.Writer, dir string, files []string, compress bool) error {
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	tw := tar.NewWriter(w)
+
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		h, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+
+		h.Name, err = entryName(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+
+		if err := copyInto(tw, path); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if gz != nil {
+		return gz.Close()
+	}
+
+	return nil
+}
+
+// entryName returns the slash-separated archive entry name of path within dir.
+func entryName(dir, path string) (string, error) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// copyInto copies the file at path to w.
+func copyInto(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
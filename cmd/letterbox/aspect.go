@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// referenceAspect returns the aspect ratio of the image at path as its
+// dimensions, such as 2400x1600.
+func referenceAspect(path string) (string, error) {
+	c, err := decodeConfig(path)
+	if err != nil {
+		return "", fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	if c.Width == 0 || c.Height == 0 {
+		return "", fmt.Errorf("decoding %s: empty image", path)
+	}
+
+	return fmt.Sprintf("%dx%d", c.Width, c.Height), nil
+}
+
+// commonAspect returns the most common aspect ratio of the images, such as
+// 3:2, reading their headers only. Ratios within 1% of each other are counted
+// as one, so that scans a pixel or two apart agree, and ties are broken by the
+// first image. Images which fail to decode are ignored.
+func commonAspect(images []string) (string, error) {
+	type group struct {
+		ratio float64
+		name  string
+		count int
+	}
+
+	var groups []*group
+
+	for _, path := range images {
+		c, err := decodeConfig(path)
+		if err != nil || c.Width == 0 || c.Height == 0 {
+			logger.Debug("Ignoring image aspect", "path", path, "error", err)
+			continue
+		}
+
+		ratio := float64(c.Width) / float64(c.Height)
+
+		var g *group
+		for _, v := range groups {
+			if math.Abs(ratio-v.ratio)/v.ratio <= 0.01 {
+				g = v
+				break
+			}
+		}
+
+		if g == nil {
+			d := gcd(c.Width, c.Height)
+			g = &group{ratio: ratio, name: fmt.Sprintf("%d:%d", c.Width/d, c.Height/d)}
+			groups = append(groups, g)
+		}
+
+		g.count++
+	}
+
+	if len(groups) == 0 {
+		return "", fmt.Errorf("no images to infer the aspect ratio from")
+	}
+
+	best := groups[0]
+	for _, g := range groups[1:] {
+		if g.count > best.count {
+			best = g
+		}
+	}
+
+	logger.Debug("Inferred aspect", "aspect", best.name, "images", best.count, "ratios", len(groups))
+	return best.name, nil
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
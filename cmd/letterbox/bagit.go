@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// bagAlgorithms are the checksum algorithms of bag manifests.
+var bagAlgorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha512", sha512.New},
+}
+
+// manifestPath escapes path for a bag manifest line.
+var manifestPath = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+
+// writeBag writes the files of dir, excluding dotfiles such as the
+// letterbox state, as a BagIt (RFC 8493) bag at path. A previous bag
+// at path is replaced, any other existing directory is an error.
+func writeBag(path, dir string) error {
+	if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("bag %s must be outside of the output directory", path)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if _, err := os.Stat(filepath.Join(path, "bagit.txt")); err != nil {
+			return fmt.Errorf("%s exists and is not a bag", path)
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+
+	data := filepath.Join(path, "data")
+	if err := os.MkdirAll(data, 0755); err != nil {
+		return err
+	}
+
+	// payload
+	manifests := make([][]string, len(bagAlgorithms))
+	var octets, count int64
+
+	err := filepath.Walk(dir, func(src string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") && src != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, src)
+		if err != nil {
+			return err
+		}
+
+		dst := filepath.Join(data, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+
+		// hard link when possible, falling back to a copy
+		if err := os.Link(src, dst); err != nil {
+			if err := copyFile(src, dst); err != nil {
+				return err
+			}
+		}
+
+		sums, err := checksums(dst)
+		if err != nil {
+			return err
+		}
+
+		name := manifestPath.Replace("data/" + filepath.ToSlash(rel))
+		for i, sum := range sums {
+			manifests[i] = append(manifests[i], sum+"  "+name)
+		}
+
+		octets += info.Size()
+		count++
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("writing payload: %w", err)
+	}
+
+	// tag files
+	tags := []string{"bagit.txt", "bag-info.txt"}
+
+	err = writeTag(path, "bagit.txt", "BagIt-Version: 1.0\nTag-File-Character-Encoding: UTF-8\n")
+	if err != nil {
+		return err
+	}
+
+	info := fmt.Sprintf("Bag-Software-Agent: letterbox\nBagging-Date: %s\nPayload-Oxum: %d.%d\n", time.Now().Format("2006-01-02"), octets, count)
+	err = writeTag(path, "bag-info.txt", info)
+	if err != nil {
+		return err
+	}
+
+	for i, a := range bagAlgorithms {
+		name := "manifest-" + a.name + ".txt"
+		err = writeTag(path, name, strings.Join(manifests[i], "\n")+"\n")
+		if err != nil {
+			return err
+		}
+		tags = append(tags, name)
+	}
+
+	// tag manifests
+	lines := make([][]string, len(bagAlgorithms))
+	for _, name := range tags {
+		sums, err := checksums(filepath.Join(path, name))
+		if err != nil {
+			return err
+		}
+
+		for i, sum := range sums {
+			lines[i] = append(lines[i], sum+"  "+name)
+		}
+	}
+
+	for i, a := range bagAlgorithms {
+		err = writeTag(path, "tagmanifest-"+a.name+".txt", strings.Join(lines[i], "\n")+"\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeTag writes the tag file name of the bag at path.
+func writeTag(path, name, s string) error {
+	return ioutil.WriteFile(filepath.Join(path, name), []byte(s), 0644)
+}
+
+// checksums returns the hex checksums of the file at path, for each of bagAlgorithms.
+func checksums(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hashes []hash.Hash
+	var writers []io.Writer
+	for _, a := range bagAlgorithms {
+		h := a.new()
+		hashes = append(hashes, h)
+		writers = append(writers, h)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return nil, err
+	}
+
+	var sums []string
+	for _, h := range hashes {
+		sums = append(sums, hex.EncodeToString(h.Sum(nil)))
+	}
+
+	return sums, nil
+}
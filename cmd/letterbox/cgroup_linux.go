@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// cpuPeriod is the cpu.max period in microseconds.
+const cpuPeriod = 100000
+
+// limitCgroup moves the process into a cgroup v2 of its own, created within
+// its current cgroup, limited to the given memory in bytes and cpus, where
+// zero is unlimited. The current cgroup must be delegated to the user, and
+// hold no other processes once this one has moved, as cgroups with
+// controllers enabled for their children may not hold processes themselves.
+// It returns the path of the cgroup.
+func limitCgroup(memory int64, cpus float64) (string, error) {
+	parent, err := currentCgroup()
+	if err != nil {
+		return "", err
+	}
+
+	// controllers
+	var controllers []string
+	if memory > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if cpus > 0 {
+		controllers = append(controllers, "cpu")
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return "", fmt.Errorf("reading controllers: %w", err)
+	}
+
+	available := strings.Fields(string(b))
+	for _, c := range controllers {
+		if !slices.Contains(available, c) {
+			return "", fmt.Errorf("%s controller is unavailable in %s", c, parent)
+		}
+	}
+
+	removeStaleCgroups(parent)
+
+	// leaf cgroup of the run
+	dir := filepath.Join(parent, fmt.Sprintf("letterbox-%d", os.Getpid()))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating cgroup: %w", err)
+	}
+
+	pid := strconv.Itoa(os.Getpid())
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(pid), 0644); err != nil {
+		os.Remove(dir)
+		return "", fmt.Errorf("moving into cgroup: %w", err)
+	}
+
+	// enable the controllers for the leaf
+	var enable []string
+	for _, c := range controllers {
+		enable = append(enable, "+"+c)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0644)
+	if err != nil {
+		return dir, fmt.Errorf("enabling controllers in %s, which must hold no other processes: %w", parent, err)
+	}
+
+	// limits
+	if memory > 0 {
+		err := ioutil.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(memory, 10)), 0644)
+		if err != nil {
+			return dir, fmt.Errorf("limiting memory: %w", err)
+		}
+	}
+
+	if cpus > 0 {
+		quota := int(cpus * cpuPeriod)
+		err := ioutil.WriteFile(filepath.Join(dir, "cpu.max"), []byte(fmt.Sprintf("%d %d", quota, cpuPeriod)), 0644)
+		if err != nil {
+			return dir, fmt.Errorf("limiting cpu: %w", err)
+		}
+	}
+
+	return dir, nil
+}
+
+// currentCgroup returns the path of the cgroup v2 of the process.
+func currentCgroup() (string, error) {
+	root, err := cgroupMount()
+	if err != nil {
+		return "", err
+	}
+
+	b, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		// unified hierarchy, such as "0::/system.slice/letterbox.service"
+		if rel, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(root, rel), nil
+		}
+	}
+
+	return "", errors.New("cgroup v2 is required")
+}
+
+// cgroupMount returns the mount point of the cgroup v2 hierarchy, which is
+// /sys/fs/cgroup unless mounted alongside v1 hierarchies.
+func cgroupMount() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// fields such as "30 23 0:26 / /sys/fs/cgroup rw - cgroup2 cgroup2 rw"
+		fields := strings.Fields(s.Text())
+		i := slices.Index(fields, "-")
+		if i > 4 && i+1 < len(fields) && fields[i+1] == "cgroup2" {
+			return fields[4], nil
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+
+	return "", errors.New("cgroup v2 is required, and not mounted")
+}
+
+// removeStaleCgroups removes the cgroups of previous runs within parent, which
+// outlive their process as it may not leave them. Populated cgroups of runs
+// in progress can't be removed.
+func removeStaleCgroups(parent string) {
+	matches, _ := filepath.Glob(filepath.Join(parent, "letterbox-*"))
+	for _, dir := range matches {
+		if os.Remove(dir) == nil {
+			logger.Debug("Removed stale cgroup", "path", dir)
+		}
+	}
+}
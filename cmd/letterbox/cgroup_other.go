@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// limitCgroup returns an error as cgroups are specific to linux.
+func limitCgroup(memory int64, cpus float64) (string, error) {
+	return "", errors.New("cgroups are only supported on linux")
+}
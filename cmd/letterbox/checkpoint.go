@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/tj/letterbox"
+)
+
+// checkpoint journals the images of a run as they complete, so that an
+// interrupted run may be resumed without listing and stat'ing its images
+// again. Queued images are written as "queued <path>" lines when the run
+// starts, and "done <path>" lines appended as each one completes, so that
+// images which were in-flight when the run was killed are retried.
+type checkpoint struct {
+	file    *os.File
+	targets map[string]int
+	pending int
+	results map[string]int
+}
+
+// readCheckpoint returns the images of the checkpoint at path which have not
+// completed, or nil when there is no checkpoint.
+func readCheckpoint(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var queued []string
+	done := make(map[string]bool)
+
+	for _, line := range strings.Split(string(b), "\n") {
+		kind, path, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+
+		switch kind {
+		case "queued":
+			queued = append(queued, path)
+		case "done":
+			done[path] = true
+		}
+	}
+
+	remaining := []string{}
+	for _, path := range queued {
+		if !done[path] {
+			remaining = append(remaining, path)
+		}
+	}
+
+	return remaining, nil
+}
+
+// newCheckpoint returns a checkpoint of the images journaled to path, with the
+// number of targets of each image. When resuming the journal is appended to,
+// otherwise the images are queued.
+func newCheckpoint(path string, images []string, targets map[string]int, resume bool) (*checkpoint, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &checkpoint{
+		file:    f,
+		targets: targets,
+		pending: len(images),
+		results: make(map[string]int),
+	}
+
+	if !resume {
+		var b strings.Builder
+		for _, path := range images {
+			fmt.Fprintf(&b, "queued %s\n", path)
+		}
+
+		if _, err := f.WriteString(b.String()); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Add a result, marking its image done once each of its targets, split pages
+// included, has completed or one has failed, as the remaining targets are not
+// attempted.
+func (c *checkpoint) Add(r letterbox.Result) error {
+	c.results[r.Source]++
+	if r.Error == "" && c.results[r.Source] < c.targets[r.Source] {
+		return nil
+	}
+
+	delete(c.results, r.Source)
+	c.pending--
+	_, err := fmt.Fprintf(c.file, "done %s\n", r.Source)
+	return err
+}
+
+// Close the journal, removing it when every image has completed.
+func (c *checkpoint) Close() error {
+	err := c.file.Close()
+	if c.pending <= 0 {
+		return os.Remove(c.file.Name())
+	}
+	return err
+}
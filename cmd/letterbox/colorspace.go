@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// colorSpace is an RGB color space, by the chromaticities of its primaries
+// and white point, and a gamma approximating its transfer curve.
+type colorSpace struct {
+	name  string
+	slug  string
+	red   [2]float64
+	green [2]float64
+	blue  [2]float64
+	white [2]float64
+	gamma float64
+}
+
+// The color spaces of tagged fixtures.
+var (
+	sRGB = colorSpace{
+		name:  "sRGB",
+		slug:  "srgb",
+		red:   [2]float64{0.64, 0.33},
+		green: [2]float64{0.30, 0.60},
+		blue:  [2]float64{0.15, 0.06},
+		white: [2]float64{0.3127, 0.3290},
+		gamma: 2.2,
+	}
+
+	adobeRGB = colorSpace{
+		name:  "Adobe RGB (1998)",
+		slug:  "adobe-rgb",
+		red:   [2]float64{0.64, 0.33},
+		green: [2]float64{0.21, 0.71},
+		blue:  [2]float64{0.15, 0.06},
+		white: [2]float64{0.3127, 0.3290},
+		gamma: 563.0 / 256,
+	}
+
+	displayP3 = colorSpace{
+		name:  "Display P3",
+		slug:  "display-p3",
+		red:   [2]float64{0.680, 0.320},
+		green: [2]float64{0.265, 0.690},
+		blue:  [2]float64{0.150, 0.060},
+		white: [2]float64{0.3127, 0.3290},
+		gamma: 2.2,
+	}
+)
+
+// d50 is the profile connection space illuminant.
+var d50 = [3]float64{0.9642, 1, 0.8249}
+
+// bradford is the Bradford cone response matrix.
+var bradford = mat3{
+	{0.8951, 0.2664, -0.1614},
+	{-0.7502, 1.7135, 0.0367},
+	{0.0389, -0.0685, 1.0296},
+}
+
+// profile returns an ICC v2 matrix/TRC display profile of the color space.
+func (s colorSpace) profile() []byte {
+	m := s.matrix()
+
+	tags := []iccTag{descTag(s.name), xyzTag("wtpt", d50)}
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tags = append(tags, xyzTag(sig, [3]float64{m[0][i], m[1][i], m[2][i]}))
+	}
+	for _, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		tags = append(tags, curveTag(sig, s.gamma))
+	}
+
+	return iccProfile(tags...)
+}
+
+// matrix returns the linear RGB to D50 XYZ matrix of the color space,
+// chromatically adapted from its white point with Bradford.
+func (s colorSpace) matrix() mat3 {
+	xyz := func(c [2]float64) [3]float64 {
+		return [3]float64{c[0] / c[1], 1, (1 - c[0] - c[1]) / c[1]}
+	}
+
+	// primaries scaled to sum to the white point
+	r, g, b, w := xyz(s.red), xyz(s.green), xyz(s.blue), xyz(s.white)
+	m := mat3{
+		{r[0], g[0], b[0]},
+		{r[1], g[1], b[1]},
+		{r[2], g[2], b[2]},
+	}
+	k := m.inverse().apply(w)
+	for i := range m {
+		for j := range m[i] {
+			m[i][j] *= k[j]
+		}
+	}
+
+	// adapt to D50
+	src, dst := bradford.apply(w), bradford.apply(d50)
+	var scale mat3
+	for i := range scale {
+		scale[i][i] = dst[i] / src[i]
+	}
+
+	return bradford.inverse().mul(scale).mul(bradford).mul(m)
+}
+
+// xyzTag returns an XYZ tag.
+func xyzTag(sig string, v [3]float64) iccTag {
+	var b bytes.Buffer
+	b.WriteString("XYZ ")
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	for _, n := range v {
+		binary.Write(&b, binary.BigEndian, int32(math.Round(n*65536)))
+	}
+	return iccTag{sig: sig, data: b.Bytes()}
+}
+
+// curveTag returns a curve tag of a single gamma.
+func curveTag(sig string, gamma float64) iccTag {
+	var b bytes.Buffer
+	b.WriteString("curv")
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	binary.Write(&b, binary.BigEndian, uint32(1))
+	binary.Write(&b, binary.BigEndian, uint16(math.Round(gamma*256)))
+	return iccTag{sig: sig, data: b.Bytes()}
+}
+
+// colorProfile converts RGB colors to CIE Lab through a matrix/TRC profile.
+type colorProfile struct {
+	matrix mat3
+	gamma  [3]float64
+}
+
+// parseProfile returns the color profile of the ICC matrix/TRC profile b,
+// with curves of a single gamma.
+func parseProfile(b []byte) (*colorProfile, error) {
+	if len(b) < 132 || string(b[36:40]) != "acsp" {
+		return nil, fmt.Errorf("invalid icc profile")
+	}
+
+	// tag table
+	tags := make(map[string][]byte)
+	n := int(binary.BigEndian.Uint32(b[128:]))
+	for i := 0; i < n; i++ {
+		e := 132 + 12*i
+		if e+12 > len(b) {
+			return nil, fmt.Errorf("invalid icc tag table")
+		}
+
+		off := int(binary.BigEndian.Uint32(b[e+4:]))
+		size := int(binary.BigEndian.Uint32(b[e+8:]))
+		if off < 0 || size < 0 || off+size > len(b) {
+			return nil, fmt.Errorf("invalid icc tag %q", b[e:e+4])
+		}
+		tags[string(b[e:e+4])] = b[off : off+size]
+	}
+
+	var p colorProfile
+
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		t := tags[sig]
+		if len(t) < 20 || string(t[:4]) != "XYZ " {
+			return nil, fmt.Errorf("unsupported icc profile, missing %s", sig)
+		}
+
+		for j := range p.matrix {
+			p.matrix[j][i] = float64(int32(binary.BigEndian.Uint32(t[8+4*j:]))) / 65536
+		}
+	}
+
+	for i, sig := range []string{"rTRC", "gTRC", "bTRC"} {
+		t := tags[sig]
+		if len(t) < 12 || string(t[:4]) != "curv" {
+			return nil, fmt.Errorf("unsupported icc profile, missing %s", sig)
+		}
+
+		switch count := binary.BigEndian.Uint32(t[8:]); {
+		case count == 0:
+			p.gamma[i] = 1
+		case count == 1 && len(t) >= 14:
+			p.gamma[i] = float64(binary.BigEndian.Uint16(t[12:])) / 256
+		default:
+			return nil, fmt.Errorf("unsupported icc profile, %s is not a gamma", sig)
+		}
+	}
+
+	return &p, nil
+}
+
+// lab returns the color c in CIE Lab, relative to D50.
+func (p *colorProfile) lab(c color.Color) [3]float64 {
+	r, g, b, _ := c.RGBA()
+
+	var rgb [3]float64
+	for i, v := range [3]uint32{r, g, b} {
+		rgb[i] = math.Pow(float64(v)/0xffff, p.gamma[i])
+	}
+
+	xyz := p.matrix.apply(rgb)
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+
+	fx, fy, fz := f(xyz[0]/d50[0]), f(xyz[1]/d50[1]), f(xyz[2]/d50[2])
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// deltaE returns the CIE76 color difference of two Lab colors.
+func deltaE(a, b [3]float64) float64 {
+	return math.Sqrt((a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2]))
+}
+
+// readICC returns the ICC profile of the jpeg, png or tiff at path, or nil if
+// it has none.
+func readICC(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return pngICC(b)
+	case bytes.HasPrefix(b, []byte("II*\x00")) || bytes.HasPrefix(b, []byte("MM\x00*")):
+		return tiffICC(b)
+	default:
+		return jpegICC(bufio.NewReader(bytes.NewReader(b)))
+	}
+}
+
+// pngICC returns the ICC profile of the png b from its iCCP chunk, or nil if
+// it has none.
+func pngICC(b []byte) ([]byte, error) {
+	for i := 8; i+8 <= len(b); {
+		n := int(binary.BigEndian.Uint32(b[i:]))
+		typ := string(b[i+4 : i+8])
+		if n < 0 || i+12+n > len(b) {
+			return nil, fmt.Errorf("invalid png chunk")
+		}
+		data := b[i+8 : i+8+n]
+
+		switch typ {
+		case "iCCP":
+			// name, compression method and zlib data
+			name := bytes.IndexByte(data, 0)
+			if name < 0 || name+2 > len(data) {
+				return nil, fmt.Errorf("invalid iCCP chunk")
+			}
+
+			z, err := zlib.NewReader(bytes.NewReader(data[name+2:]))
+			if err != nil {
+				return nil, fmt.Errorf("decompressing profile: %w", err)
+			}
+			defer z.Close()
+			return ioutil.ReadAll(z)
+		case "IDAT", "IEND":
+			return nil, nil
+		}
+
+		i += 12 + n
+	}
+
+	return nil, nil
+}
+
+// tiffICC returns the ICC profile of the tiff b from the InterColorProfile
+// tag of its first IFD, or nil if it has none.
+func tiffICC(b []byte) ([]byte, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if b[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	ifd := int(order.Uint32(b[4:]))
+	if ifd+2 > len(b) {
+		return nil, fmt.Errorf("invalid tiff IFD offset")
+	}
+
+	n := int(order.Uint16(b[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(b) {
+			return nil, fmt.Errorf("invalid tiff IFD")
+		}
+
+		if order.Uint16(b[e:]) == 34675 {
+			size, offset := int(order.Uint32(b[e+4:])), int(order.Uint32(b[e+8:]))
+			if offset+size > len(b) {
+				return nil, fmt.Errorf("invalid tiff icc profile")
+			}
+			return b[offset : offset+size], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// jpegICC returns the ICC profile of the jpeg read from r, reassembled from
+// its APP2 chunks, or nil if it has none.
+func jpegICC(r *bufio.Reader) ([]byte, error) {
+
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, nil
+	}
+
+	chunks := make(map[byte][]byte)
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(r, marker[:]); err != nil {
+			return nil, err
+		}
+
+		// start of scan, or not a segment
+		if marker[0] != 0xFF || marker[1] == 0xDA {
+			break
+		}
+
+		n := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if n < 0 {
+			return nil, fmt.Errorf("invalid segment length")
+		}
+
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+
+		const prefix = "ICC_PROFILE\x00"
+		if marker[1] == 0xE2 && len(b) > len(prefix)+2 && string(b[:len(prefix)]) == prefix {
+			chunks[b[len(prefix)]] = b[len(prefix)+2:]
+		}
+	}
+
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+
+	var profile []byte
+	for i := 1; i <= len(chunks); i++ {
+		c, ok := chunks[byte(i)]
+		if !ok {
+			return nil, fmt.Errorf("missing icc chunk %d", i)
+		}
+		profile = append(profile, c...)
+	}
+
+	return profile, nil
+}
+
+// mat3 is a 3x3 matrix.
+type mat3 [3][3]float64
+
+// mul returns m×n.
+func (m mat3) mul(n mat3) (r mat3) {
+	for i := range r {
+		for j := range r[i] {
+			for k := range m {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+// apply returns m×v.
+func (m mat3) apply(v [3]float64) (r [3]float64) {
+	for i := range r {
+		r[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return r
+}
+
+// inverse returns the inverse of m.
+func (m mat3) inverse() (r mat3) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+
+	for i := range r {
+		for j := range r[i] {
+			// cofactor of the transpose
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			r[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+	return r
+}
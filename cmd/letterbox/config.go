@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/tj/letterbox"
+	"gopkg.in/yaml.v3"
+)
+
+// configFiles are the config file names discovered in the working directory.
+var configFiles = []string{
+	"letterbox.yaml",
+	"letterbox.yml",
+	".letterboxrc",
+}
+
+// config is the per-project configuration.
+type config struct {
+	Output      string   `yaml:"output" json:"output"`
+	Aspect      string   `yaml:"aspect" json:"aspect"`
+	Background  string   `yaml:"background" json:"background"`
+	Tokens      string   `yaml:"tokens" json:"tokens"`
+	Quality     int      `yaml:"quality" json:"quality"`
+	Padding     length   `yaml:"padding" json:"padding"`
+	Concurrency int      `yaml:"concurrency" json:"concurrency"`
+	Include     []string `yaml:"include" json:"include"`
+	Exclude     []string `yaml:"exclude" json:"exclude"`
+
+	// Inputs are the input directories, later overriding earlier.
+	Inputs []string `yaml:"inputs" json:"inputs"`
+
+	// Preset is the name of the preset to use.
+	Preset string `yaml:"preset" json:"preset"`
+
+	// Presets are custom presets, overriding built-ins of the same name.
+	Presets map[string]preset `yaml:"presets" json:"-"`
+
+	// Profiles are encoder profiles referenced by presets.
+	Profiles map[string]profile `yaml:"profiles" json:"-"`
+}
+
+// length is a config value which may be a number or a string, such as a
+// padding of 5 or "5% 10%".
+type length string
+
+// UnmarshalJSON implementation.
+func (l *length) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*l = length(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+
+	*l = length(n)
+	return nil
+}
+
+// findConfig returns the config file in dir, or an empty string.
+func findConfig(dir string) string {
+	for _, name := range configFiles {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// readConfig reads the config file at path.
+func readConfig(path string) (*config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c config
+	err = yaml.Unmarshal(b, &c)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// validate the config.
+func (c *config) validate() error {
+	for name, p := range c.Presets {
+		if _, ok := c.Profiles[p.Profile]; p.Profile != "" && !ok {
+			return fmt.Errorf("preset %q references unknown profile %q", name, p.Profile)
+		}
+	}
+
+	if c.Background == "" || reference.MatchString(c.Background) {
+		return nil
+	}
+
+	if _, err := letterbox.ParseColor(c.Background); err != nil {
+		return fmt.Errorf("unsupported background %q", c.Background)
+	}
+
+	return nil
+}
+
+// loadConfig reads the config at path, or the one discovered in the
+// working directory when path is empty. An empty config is returned when there is none.
+func loadConfig(path string) (*config, error) {
+	if path == "" {
+		path = findConfig(".")
+	}
+
+	if path == "" {
+		return &config{}, nil
+	}
+
+	return readConfig(path)
+}
+
+// setFlags sets the flags to the given values, skipping empty
+// values and the flags which were explicitly set.
+func setFlags(values map[string]string, set map[string]bool) error {
+	for name, v := range values {
+		if v == "" || set[name] {
+			continue
+		}
+
+		if err := flag.Set(name, v); err != nil {
+			return fmt.Errorf("setting %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// values returns the config values keyed by flag name.
+func (c *config) values() map[string]string {
+	values := map[string]string{
+		"output": c.Output,
+		"aspect": c.Aspect,
+		"preset": c.Preset,
+	}
+
+	switch c.Background {
+	case "":
+	case "black", "white":
+		values["white"] = strconv.FormatBool(c.Background == "white")
+	default:
+		values["bg"] = c.Background
+	}
+
+	if c.Tokens != "" {
+		values["tokens"] = c.Tokens
+	}
+
+	if c.Quality != 0 {
+		values["quality"] = strconv.Itoa(c.Quality)
+	}
+
+	if c.Padding != "" {
+		values["padding"] = string(c.Padding)
+	}
+
+	if c.Concurrency != 0 {
+		values["concurrency"] = strconv.Itoa(c.Concurrency)
+	}
+
+	return values
+}
+
+// flagsSet returns the names of the flags explicitly set.
+func flagsSet() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// matches returns true if the name matches any of the patterns.
+func matches(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tj/letterbox"
+)
+
+// isDocument returns true if path is a markdown, docx or pptx document.
+func isDocument(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown", ".docx", ".pptx":
+		return true
+	default:
+		return false
+	}
+}
+
+// isOffice returns true if path is a docx or pptx document.
+func isOffice(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".docx", ".pptx":
+		return true
+	default:
+		return false
+	}
+}
+
+// mediaPrefixes are the directories of docx and pptx documents holding
+// embedded images.
+var mediaPrefixes = []string{"word/media/", "ppt/media/"}
+
+// isMedia returns true if the docx or pptx entry name is an embedded image.
+func isMedia(name string) bool {
+	for _, prefix := range mediaPrefixes {
+		if strings.HasPrefix(name, prefix) && isImage(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// documents replaces the documents among images with the images they
+// contain. Markdown documents are replaced by the local images they
+// reference, other than outputs, and the images embedded in docx and pptx
+// documents are extracted into dir, each into a directory of its name. It
+// returns the images, the documents, and the documents keyed by directory.
+func documents(images []string, dir, output string) ([]string, []string, map[string]string, error) {
+	var list, docs []string
+	extracted := make(map[string]string)
+	seen := make(map[string]bool)
+	refs := site{static: "."}
+
+	for _, doc := range images {
+		if !isDocument(doc) {
+			list = append(list, doc)
+			continue
+		}
+
+		docs = append(docs, doc)
+
+		// markdown
+		if !isOffice(doc) {
+			b, err := ioutil.ReadFile(doc)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			for _, m := range imageReference.FindAllStringSubmatch(string(b), -1) {
+				p := refs.resolve(doc, m[1]+m[2], output)
+				if p != "" && !seen[p] {
+					seen[p] = true
+					list = append(list, p)
+				}
+			}
+
+			continue
+		}
+
+		// docx and pptx
+		dst := filepath.Join(dir, filepath.Base(doc))
+		if other, ok := extracted[dst]; ok {
+			return nil, nil, nil, fmt.Errorf("documents %s and %s have the same name", other, doc)
+		}
+		extracted[dst] = doc
+
+		if err := extractMedia(doc, dst); err != nil {
+			return nil, nil, nil, fmt.Errorf("extracting images of %s: %w", doc, err)
+		}
+
+		media, err := walkImages(dst)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		logger.Debug("Extracted document images", "path", doc, "images", len(media))
+		list = append(list, media...)
+	}
+
+	return list, docs, extracted, nil
+}
+
+// extractMedia extracts the images embedded in the docx or pptx at path into
+// dir, with the modification time of the document so that skip policies
+// reprocess them when it changes.
+func extractMedia(path, dir string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if !isMedia(f.Name) {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		err = extractFile(dir, f.Name, info.ModTime(), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewriteDocuments rewrites the image references of markdown documents and
+// the embedded images of docx and pptx documents to the outputs of results,
+// keyed by source, returning the number of images rewritten. References to
+// outputs within the output directory are left as-is.
+func rewriteDocuments(docs []string, output string, results map[string]letterbox.Result) (int, error) {
+	refs := site{static: "."}
+
+	var n int
+	for _, doc := range docs {
+		var m int
+		var err error
+
+		if isOffice(doc) {
+			m, err = rewriteOffice(doc, results)
+		} else {
+			m, err = rewritePage(doc, func(ref string) (string, bool) {
+				p := refs.resolve(doc, ref, output)
+				r, ok := results[p]
+				if p == "" || !ok {
+					return "", false
+				}
+				return relativeRef(doc, r.Output)
+			})
+		}
+
+		n += m
+		if err != nil {
+			return n, fmt.Errorf("rewriting %s: %w", doc, err)
+		}
+	}
+
+	return n, nil
+}
+
+// relativeRef returns the slash-separated path of the file at path relative
+// to the directory of the document doc.
+func relativeRef(doc, path string) (string, bool) {
+	from, err := filepath.Abs(filepath.Dir(doc))
+	if err != nil {
+		return "", false
+	}
+
+	to, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(from, to)
+	if err != nil {
+		return "", false
+	}
+
+	return filepath.ToSlash(rel), true
+}
+
+var (
+	// drawing matches the drawings of docx parts and pictures of pptx slides.
+	drawing = regexp.MustCompile(`(?s)<w:drawing>.*?</w:drawing>|<p:pic>.*?</p:pic>`)
+
+	// embed matches the relationship id of the image of a drawing.
+	embed = regexp.MustCompile(`r:embed="([^"]+)"`)
+
+	// extent matches the displayed dimensions of a drawing in EMUs.
+	extent = regexp.MustCompile(`(<(?:wp:extent|a:ext) cx=")(\d+)(" cy=")(\d+)(")`)
+
+	// relationship matches the relationships of a part.
+	relationship = regexp.MustCompile(`<Relationship\s[^>]*>`)
+
+	// attribute matches the attributes of a relationship.
+	attribute = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// rewriteOffice replaces the images embedded in the docx or pptx doc with
+// their outputs, renaming them when the format changes the extension, and
+// sizing their drawings to the aspect ratio of the outputs, preserving width.
+func rewriteOffice(doc string, results map[string]letterbox.Result) (int, error) {
+	r, err := zip.OpenReader(doc)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	// outputs replacing media, and their names when the extension changes
+	replaced := make(map[string]letterbox.Result)
+	renames := make(map[string]string)
+	for _, f := range r.File {
+		res, ok := results[filepath.Join(doc, filepath.FromSlash(f.Name))]
+		if !ok || !isMedia(f.Name) {
+			continue
+		}
+
+		// dimensions of skipped outputs
+		if res.Final.Width == 0 {
+			c, err := decodeConfig(res.Output)
+			if err != nil {
+				return 0, err
+			}
+			res.Final = letterbox.Size{Width: c.Width, Height: c.Height}
+		}
+
+		replaced[f.Name] = res
+		if name := withOutputExt(f.Name, res.Output); name != f.Name {
+			renames[f.Name] = name
+		}
+	}
+
+	if len(replaced) == 0 {
+		return 0, nil
+	}
+
+	// relationships of each part
+	rels := make(map[string]map[string]string)
+	for _, f := range r.File {
+		if !strings.HasSuffix(f.Name, ".rels") {
+			continue
+		}
+
+		b, err := readEntry(f)
+		if err != nil {
+			return 0, err
+		}
+
+		part := path.Join(path.Dir(path.Dir(f.Name)), strings.TrimSuffix(path.Base(f.Name), ".rels"))
+		rels[part] = make(map[string]string)
+		for _, tag := range relationship.FindAllString(string(b), -1) {
+			attrs := make(map[string]string)
+			for _, m := range attribute.FindAllStringSubmatch(tag, -1) {
+				attrs[m[1]] = m[2]
+			}
+			target := attrs["Target"]
+			if !strings.HasPrefix(target, "/") {
+				target = path.Join(path.Dir(part), target)
+			}
+			rels[part][attrs["Id"]] = strings.TrimPrefix(target, "/")
+		}
+	}
+
+	info, err := os.Stat(doc)
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(doc), "."+filepath.Base(doc)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(out.Name())
+
+	err = writeOffice(out, r.File, replaced, renames, rels)
+	if err == nil {
+		err = out.Chmod(info.Mode())
+	}
+
+	if err != nil {
+		out.Close()
+		return 0, err
+	}
+
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+
+	return len(replaced), os.Rename(out.Name(), doc)
+}
+
+// writeOffice writes the entries of files to w as a zip, with media replaced by
+// their outputs and renamed, and the parts referencing them updated.
+func writeOffice(w io.Writer, files []*zip.File, replaced map[string]letterbox.Result, renames map[string]string, rels map[string]map[string]string) error {
+	zw := zip.NewWriter(w)
+
+	for _, f := range files {
+		// media
+		if res, ok := replaced[f.Name]; ok {
+			name := f.Name
+			if renamed, ok := renames[name]; ok {
+				name = renamed
+			}
+
+			fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: f.Modified})
+			if err != nil {
+				return err
+			}
+
+			if err := copyInto(fw, res.Output); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !strings.HasSuffix(f.Name, ".xml") && !strings.HasSuffix(f.Name, ".rels") {
+			if err := zw.Copy(f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		b, err := readEntry(f)
+		if err != nil {
+			return err
+		}
+
+		s := string(b)
+
+		// references to renamed media
+		for from, to := range renames {
+			s = strings.ReplaceAll(s, "/"+path.Base(from)+`"`, "/"+path.Base(to)+`"`)
+		}
+
+		// content types of renamed media
+		if f.Name == "[Content_Types].xml" {
+			s = withContentTypes(s, renames)
+		}
+
+		// drawings of replaced media
+		if part, ok := rels[f.Name]; ok {
+			s = drawing.ReplaceAllStringFunc(s, func(d string) string {
+				m := embed.FindStringSubmatch(d)
+				if m == nil {
+					return d
+				}
+
+				res, ok := replaced[part[m[1]]]
+				if !ok {
+					return d
+				}
+
+				return resizeDrawing(d, res.Final)
+			})
+		}
+
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: f.Method, Modified: f.Modified})
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(fw, s); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// withOutputExt returns the entry name with the extension of the output.
+func withOutputExt(name, output string) string {
+	ext := filepath.Ext(output)
+	if strings.EqualFold(path.Ext(name), ext) {
+		return name
+	}
+	return strings.TrimSuffix(name, path.Ext(name)) + ext
+}
+
+// withContentTypes returns the content types with defaults for the
+// extensions of renamed media, when missing.
+func withContentTypes(s string, renames map[string]string) string {
+	for _, to := range renames {
+		ext := strings.TrimPrefix(path.Ext(to), ".")
+		if strings.Contains(strings.ToLower(s), `extension="`+strings.ToLower(ext)+`"`) {
+			continue
+		}
+
+		typ := "image/jpeg"
+		switch strings.ToLower(ext) {
+		case "png":
+			typ = "image/png"
+		case "tif":
+			typ = "image/tiff"
+		}
+
+		s = strings.Replace(s, "</Types>", `<Default Extension="`+ext+`" ContentType="`+typ+`"/></Types>`, 1)
+	}
+	return s
+}
+
+// resizeDrawing returns the drawing with its height changed to the aspect
+// ratio of the image size, preserving its width.
+func resizeDrawing(d string, size letterbox.Size) string {
+	return extent.ReplaceAllStringFunc(d, func(e string) string {
+		m := extent.FindStringSubmatch(e)
+		cx, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			return e
+		}
+
+		cy := cx * int64(size.Height) / int64(size.Width)
+		return m[1] + m[2] + m[3] + strconv.FormatInt(cy, 10) + m[5]
+	})
+}
+
+// decodeConfig returns the config of the image at path.
+func decodeConfig(path string) (image.Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer f.Close()
+
+	c, _, err := image.DecodeConfig(f)
+	return c, err
+}
+
+// readEntry returns the contents of the zip entry.
+func readEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(rc)
+}
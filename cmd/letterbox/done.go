@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tj/letterbox"
+)
+
+// sourceDone is what is done with sources once processed successfully.
+type sourceDone struct {
+	action string
+	dir    string
+}
+
+// newSourceDone returns the disposal of sources, keep, delete or move, where
+// a directory implies move.
+func newSourceDone(action, dir string) (d sourceDone, err error) {
+	if dir != "" && action == "keep" {
+		action = "move"
+	}
+
+	switch action {
+	case "keep", "delete":
+	case "move":
+		if dir == "" {
+			return d, fmt.Errorf("-source-done move requires -source-done-dir")
+		}
+	default:
+		return d, fmt.Errorf("invalid -source-done %q, must be keep, delete or move", action)
+	}
+
+	return sourceDone{action: action, dir: dir}, nil
+}
+
+// keep returns true if sources are left as-is.
+func (d sourceDone) keep() bool {
+	return d.action == "keep"
+}
+
+// dispose deletes or moves aside the inputs whose results all succeeded,
+// skipped outputs included, so that hot folders don't reprocess or accumulate
+// them. Images extracted from archives, videos and documents are disposed of
+// by their input, and inputs processed in place or with remaining images left
+// unscheduled by an early stop are kept.
+func (d sourceDone) dispose(results []letterbox.Result, remaining []string, extracted map[string]string) (disposed, failed int) {
+	var inputs []string
+	done := make(map[string]bool)
+
+	for _, r := range results {
+		input := inputOf(r.Source, extracted)
+		if _, ok := done[input]; !ok {
+			inputs = append(inputs, input)
+			done[input] = true
+		}
+
+		if r.Error != "" || r.Rejected || samePath(r.Source, r.Output) {
+			done[input] = false
+		}
+	}
+
+	// remaining images are named by their extracted paths
+	for _, path := range remaining {
+		done[inputOf(archived(path, extracted), extracted)] = false
+	}
+
+	for _, path := range inputs {
+		if !done[path] {
+			continue
+		}
+
+		var err error
+		if d.action == "delete" {
+			err = os.Remove(path)
+		} else {
+			err = d.move(path)
+		}
+
+		if err != nil {
+			logger.Error("Error disposing of source", "path", path, "action", d.action, "error", err)
+			failed++
+			continue
+		}
+
+		logger.Debug("Disposed of source", "path", path, "action", d.action)
+		disposed++
+	}
+
+	return
+}
+
+// move the source at path into the directory, at its relative path when
+// local, refusing to overwrite previous sources.
+func (d sourceDone) move(path string) error {
+	rel := filepath.Clean(path)
+	if !filepath.IsLocal(rel) {
+		rel = filepath.Base(path)
+	}
+
+	dst := filepath.Join(d.dir, rel)
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	return moveFile(path, dst)
+}
+
+// moveFile renames src to dst, copying and removing it across filesystems
+// with its mode and modification time.
+func moveFile(src, dst string) error {
+	if os.Rename(src, dst) == nil {
+		return nil
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	if err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// inputOf returns the input the source was extracted from, or the source.
+func inputOf(source string, extracted map[string]string) string {
+	for _, input := range extracted {
+		if source == input || strings.HasPrefix(source, input+string(filepath.Separator)) {
+			return input
+		}
+	}
+	return source
+}
+
+// samePath returns true if a and b are the same path.
+func samePath(a, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && a == b
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tj/letterbox"
+)
+
+func TestSourceDoneRemaining(t *testing.T) {
+	dir := t.TempDir()
+	extractDir := filepath.Join(dir, "extract")
+
+	// an archive of three images and a plain image
+	zip := filepath.Join(dir, "photos.zip")
+	photo := filepath.Join(dir, "photo.jpg")
+	for _, path := range []string{zip, photo} {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	extracted := map[string]string{filepath.Join(extractDir, "photos"): zip}
+	results := []letterbox.Result{
+		{Source: filepath.Join(zip, "a.jpg"), Output: filepath.Join(dir, "out", "a.jpg")},
+		{Source: photo, Output: filepath.Join(dir, "out", "photo.jpg")},
+	}
+
+	d, err := newSourceDone("delete", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stopped early with two images of the archive unscheduled
+	remaining := []string{
+		filepath.Join(extractDir, "photos", "b.jpg"),
+		filepath.Join(extractDir, "photos", "c.jpg"),
+	}
+
+	disposed, failed := d.dispose(results, remaining, extracted)
+	if disposed != 1 || failed != 0 {
+		t.Fatalf("disposed %d and failed %d, want 1 and 0", disposed, failed)
+	}
+
+	if _, err := os.Stat(zip); err != nil {
+		t.Fatalf("partly processed archive was disposed of: %v", err)
+	}
+
+	if _, err := os.Stat(photo); !os.IsNotExist(err) {
+		t.Fatalf("processed image was kept: %v", err)
+	}
+
+	// completed
+	disposed, failed = d.dispose(results[:1], nil, extracted)
+	if disposed != 1 || failed != 0 {
+		t.Fatalf("disposed %d and failed %d, want 1 and 0", disposed, failed)
+	}
+
+	if _, err := os.Stat(zip); !os.IsNotExist(err) {
+		t.Fatalf("processed archive was kept: %v", err)
+	}
+}
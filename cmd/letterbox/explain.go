@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/tj/letterbox"
+)
+
+// writeExplain writes the pipeline as a tree, or a graphviz digraph when
+// format is "dot".
+func writeExplain(w io.Writer, s *letterbox.Stage, format string) error {
+	switch format {
+	case "tree":
+		writeTree(w, *s, "", "")
+		return nil
+	case "dot":
+		fmt.Fprintln(w, "digraph letterbox {")
+		fmt.Fprintln(w, "  rankdir=LR;")
+		fmt.Fprintln(w, "  node [shape=box, fontname=monospace];")
+		writeDot(w, *s, new(int))
+		fmt.Fprintln(w, "}")
+		return nil
+	default:
+		return fmt.Errorf("unsupported explain format %q", format)
+	}
+}
+
+// writeTree writes the stage and its sub-stages as an indented tree.
+func writeTree(w io.Writer, s letterbox.Stage, prefix, child string) {
+	line := s.String()
+	if s.Pixels > 0 {
+		line += "  ~" + formatPixels(s.Pixels)
+	}
+	fmt.Fprintf(w, "%s%s\n", prefix, line)
+
+	for i, sub := range s.Stages {
+		if i == len(s.Stages)-1 {
+			writeTree(w, sub, child+"└── ", child+"    ")
+		} else {
+			writeTree(w, sub, child+"├── ", child+"│   ")
+		}
+	}
+}
+
+// writeDot writes the stage as a node, with edges from sub-stages to their
+// successors in order, returning the node id of the stage. Targets branch
+// from the stage preceding them, as each image is processed for every one.
+func writeDot(w io.Writer, s letterbox.Stage, n *int) int {
+	id := *n
+	*n++
+
+	label := s.Name
+	for _, p := range s.Params {
+		label += "\n" + p
+	}
+	if s.Pixels > 0 {
+		label += "\n~" + formatPixels(s.Pixels)
+	}
+	fmt.Fprintf(w, "  n%d [label=%s];\n", id, strconv.Quote(label))
+
+	prev := id
+	for _, sub := range s.Stages {
+		sid := writeDot(w, sub, n)
+		fmt.Fprintf(w, "  n%d -> n%d;\n", prev, sid)
+		if !strings.HasPrefix(sub.Name, "target ") {
+			prev = sid
+		}
+	}
+
+	return id
+}
+
+// formatPixels returns n pixels in megapixels, or kilopixels when small.
+func formatPixels(n int) string {
+	if n < 1e6 {
+		return fmt.Sprintf("%.1fKP", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%.1fMP", float64(n)/1e6)
+}
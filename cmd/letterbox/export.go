@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tj/letterbox"
+)
+
+// exportHeader is the header of the metadata CSV.
+var exportHeader = []string{
+	"path", "width", "height", "aspect", "orientation",
+	"make", "model", "taken", "iso", "exposure", "f_number", "focal_length",
+	"output_width", "output_height", "layout",
+	"pad_top", "pad_right", "pad_bottom", "pad_left", "padding",
+	"error",
+}
+
+// exportMetadata writes a CSV row per image with its dimensions, EXIF basics,
+// and the padding letterboxing would add, without decoding or writing images.
+func exportMetadata(args []string) error {
+	cmd := flag.NewFlagSet("export-metadata", flag.ExitOnError)
+	output := cmd.String("o", "-", "Output CSV path, or - for stdout")
+	aspect := cmd.String("aspect", "16:9", "Output aspect ratio the padding is computed for")
+	size := cmd.String("size", "", "Exact output dimensions the padding is computed for, such as 1920x1080")
+	concurrency := cmd.Int("concurrency", 8, "Concurrency of reading images")
+	cmd.Parse(args)
+
+	images := cmd.Args()
+	if len(images) == 0 {
+		var err error
+		images, err = listImages(".", nil, nil)
+		if err != nil {
+			return fmt.Errorf("listing images: %w", err)
+		}
+	}
+
+	width, height, err := parseSize(*size)
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]letterbox.Result)
+
+	// layout of a dry run, which only reads headers
+	p, err := letterbox.New(os.TempDir(),
+		letterbox.WithAspect(*aspect),
+		letterbox.WithSize(width, height),
+		letterbox.WithDryRun(true),
+		letterbox.WithForce(true),
+		letterbox.WithPreservePaths(true),
+		letterbox.WithConcurrency(*concurrency),
+		letterbox.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		letterbox.WithResultHandler(func(r letterbox.Result) {
+			mu.Lock()
+			results[r.Source] = r
+			mu.Unlock()
+		}))
+
+	if err != nil {
+		return err
+	}
+
+	// failures are reported by row
+	if err := p.Process(context.Background(), images); err != nil {
+		logger.Warn("Error inspecting images", "error", err)
+	}
+
+	if *output == "-" {
+		return writeMetadata(os.Stdout, images, results)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err := writeMetadata(f, images, results); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// writeMetadata writes the CSV rows of images to w, from their results keyed
+// by source and their EXIF metadata.
+func writeMetadata(w io.Writer, images []string, results map[string]letterbox.Result) error {
+	cw := csv.NewWriter(w)
+	cw.Write(exportHeader)
+
+	for _, path := range images {
+		row := metadataRow(path, results[path])
+
+		if e, err := letterbox.ReadExif(path); err != nil {
+			logger.Warn("Error reading exif", "path", path, "error", err)
+		} else {
+			row = withExif(row, e)
+		}
+
+		cw.Write(row)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// metadataRow returns the row of the image at path from its dry run result,
+// with empty EXIF columns.
+func metadataRow(path string, r letterbox.Result) []string {
+	row := make([]string, len(exportHeader))
+	row[0] = path
+	row[len(row)-1] = r.Error
+
+	if r.Original.Width == 0 {
+		if r.Error == "" {
+			row[len(row)-1] = "not inspected"
+		}
+		return row
+	}
+
+	o, f, b := r.Original, r.Final, r.Bars
+	padded := f.Width*f.Height - (f.Width-b.Left-b.Right)*(f.Height-b.Top-b.Bottom)
+
+	row[1] = strconv.Itoa(o.Width)
+	row[2] = strconv.Itoa(o.Height)
+	row[3] = formatFloat(float64(o.Width) / float64(o.Height))
+	row[12] = strconv.Itoa(f.Width)
+	row[13] = strconv.Itoa(f.Height)
+	row[14] = r.Layout
+	row[15] = strconv.Itoa(b.Top)
+	row[16] = strconv.Itoa(b.Right)
+	row[17] = strconv.Itoa(b.Bottom)
+	row[18] = strconv.Itoa(b.Left)
+	row[19] = formatFloat(float64(padded) / float64(f.Width*f.Height))
+	return row
+}
+
+// withExif returns the row with the EXIF columns of e, empty when missing.
+func withExif(row []string, e letterbox.Exif) []string {
+	nonzero := func(v float64) string {
+		if v == 0 {
+			return ""
+		}
+		return formatFloat(v)
+	}
+
+	if e.Orientation > 0 {
+		row[4] = strconv.Itoa(e.Orientation)
+	}
+	row[5] = e.Make
+	row[6] = e.Model
+	if !e.Taken.IsZero() {
+		row[7] = e.Taken.Format(time.DateTime)
+	}
+	if e.ISO > 0 {
+		row[8] = strconv.Itoa(e.ISO)
+	}
+	row[9] = nonzero(e.ExposureTime)
+	row[10] = nonzero(e.FNumber)
+	row[11] = nonzero(e.FocalLength)
+	return row
+}
+
+// formatFloat returns v rounded to 4 decimals, for spreadsheets.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1e4)/1e4, 'f', -1, 64)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// fixture is a synthetic image.
+type fixture struct {
+	name     string
+	img      image.Image
+	segments [][]byte
+}
+
+// genFixtures writes synthetic images for validating processing and presets.
+func genFixtures(args []string) error {
+	cmd := flag.NewFlagSet("gen-fixtures", flag.ExitOnError)
+	dir := cmd.String("output", "fixtures", "Fixture output directory")
+	cmd.Parse(args)
+
+	err := os.MkdirAll(*dir, 0755)
+	if err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+
+	for _, f := range fixtures() {
+		path := filepath.Join(*dir, f.name)
+		if err := writeFixture(path, f); err != nil {
+			return fmt.Errorf("writing %s: %w", f.name, err)
+		}
+		logger.Info("Generated", "path", path)
+	}
+
+	return nil
+}
+
+// fixtures returns the synthetic images.
+func fixtures() []fixture {
+	list := []fixture{
+		{name: "gradient-landscape.jpg", img: gradient(1600, 900)},
+		{name: "gradient-portrait.jpg", img: gradient(900, 1600)},
+		{name: "gradient-square.jpg", img: gradient(1000, 1000)},
+		{name: "checkerboard.png", img: checkerboard(1024, 768, 32)},
+		{name: "checkerboard-odd.jpg", img: checkerboard(1001, 667, 13)},
+		{name: "extreme-panorama.jpg", img: gradient(6000, 400)},
+		{name: "extreme-tall.jpg", img: gradient(300, 4000)},
+		{name: "odd-dimensions.jpg", img: gradient(999, 555)},
+		{name: "tiny.jpg", img: gradient(3, 5)},
+		{name: "icc-tagged.jpg", img: gradient(1200, 800), segments: [][]byte{iccSegment(iccProfile(descTag("letterbox fixture")))}},
+		taggedFixture(sRGB),
+		taggedFixture(adobeRGB),
+		taggedFixture(displayP3),
+	}
+
+	// one per EXIF orientation, with a marker in the top-left corner of the stored pixels
+	for o := 1; o <= 8; o++ {
+		list = append(list, fixture{
+			name:     fmt.Sprintf("exif-orientation-%d.jpg", o),
+			img:      marked(gradient(1200, 800)),
+			segments: [][]byte{orientationSegment(o)},
+		})
+	}
+
+	return list
+}
+
+// taggedFixture returns a gradient tagged with the profile of the color space.
+func taggedFixture(s colorSpace) fixture {
+	return fixture{
+		name:     "icc-" + s.slug + ".jpg",
+		img:      gradient(1200, 800),
+		segments: [][]byte{iccSegment(s.profile())},
+	}
+}
+
+// writeFixture writes the fixture as a jpeg or png depending on its extension,
+// inserting any jpeg segments directly after SOI.
+func writeFixture(path string, f fixture) error {
+	var buf bytes.Buffer
+
+	if filepath.Ext(path) == ".png" {
+		if err := png.Encode(&buf, f.img); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, buf.Bytes(), 0644)
+	}
+
+	if err := jpeg.Encode(&buf, f.img, &jpeg.Options{Quality: 90}); err != nil {
+		return err
+	}
+
+	b := buf.Bytes()
+	var out bytes.Buffer
+	out.Write(b[:2])
+	for _, s := range f.segments {
+		out.Write(s)
+	}
+	out.Write(b[2:])
+
+	return ioutil.WriteFile(path, out.Bytes(), 0644)
+}
+
+// gradient returns a diagonal color gradient.
+func gradient(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{
+				R: uint8(255 * x / max(w-1, 1)),
+				G: uint8(255 * y / max(h-1, 1)),
+				B: uint8(255 - 255*(x+y)/max(w+h-2, 1)),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// checkerboard returns a black and white checkerboard with square cells.
+func checkerboard(w, h, cell int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if (x/cell+y/cell)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+// marked returns img with a red block in its top-left corner.
+func marked(img image.Image) image.Image {
+	m := img.(*image.RGBA)
+	b := m.Bounds()
+	size := min(b.Dx(), b.Dy()) / 5
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			m.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	return m
+}
+
+// segment returns a jpeg segment with the given marker and payload.
+func segment(marker byte, payload []byte) []byte {
+	b := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+// orientationSegment returns an EXIF APP1 segment with the given orientation.
+func orientationSegment(o int) []byte {
+	var b bytes.Buffer
+	b.WriteString("Exif\x00\x00")
+
+	// big-endian TIFF header with the IFD at offset 8
+	b.WriteString("MM\x00\x2A")
+	binary.Write(&b, binary.BigEndian, uint32(8))
+
+	// one entry: Orientation, SHORT, count 1
+	binary.Write(&b, binary.BigEndian, uint16(1))
+	binary.Write(&b, binary.BigEndian, uint16(0x0112))
+	binary.Write(&b, binary.BigEndian, uint16(3))
+	binary.Write(&b, binary.BigEndian, uint32(1))
+	binary.Write(&b, binary.BigEndian, uint16(o))
+	binary.Write(&b, binary.BigEndian, uint16(0))
+
+	// no next IFD
+	binary.Write(&b, binary.BigEndian, uint32(0))
+
+	return segment(0xE1, b.Bytes())
+}
+
+// iccTag is a tag of an ICC profile.
+type iccTag struct {
+	sig  string
+	data []byte
+}
+
+// iccProfile returns an ICC v2 RGB display profile with the given tags.
+func iccProfile(tags ...iccTag) []byte {
+	const header = 128
+	table := 4 + 12*len(tags)
+
+	// tag data, 4-byte aligned
+	var data bytes.Buffer
+	offsets := make([]int, len(tags))
+	for i, t := range tags {
+		offsets[i] = header + table + data.Len()
+		data.Write(t.data)
+		data.Write(make([]byte, -data.Len()&3))
+	}
+
+	size := header + table + data.Len()
+
+	var p bytes.Buffer
+	binary.Write(&p, binary.BigEndian, uint32(size))
+	p.WriteString("none")
+	p.Write([]byte{2, 0x10, 0, 0})
+	p.WriteString("mntrRGB XYZ ")
+	binary.Write(&p, binary.BigEndian, [6]uint16{2024, 1, 1, 0, 0, 0})
+	p.WriteString("acsp")
+	p.Write(make([]byte, 4+4+4+4+8+4))
+	// D50 illuminant
+	binary.Write(&p, binary.BigEndian, [3]uint32{0xF6D6, 0x10000, 0xD32D})
+	p.Write(make([]byte, header-p.Len()))
+
+	// tag table
+	binary.Write(&p, binary.BigEndian, uint32(len(tags)))
+	for i, t := range tags {
+		p.WriteString(t.sig)
+		binary.Write(&p, binary.BigEndian, uint32(offsets[i]))
+		binary.Write(&p, binary.BigEndian, uint32(len(t.data)))
+	}
+	p.Write(data.Bytes())
+
+	return p.Bytes()
+}
+
+// descTag returns a profile description tag.
+func descTag(desc string) iccTag {
+	// signature, reserved, ascii count, ascii, unicode and scriptcode fields
+	var b bytes.Buffer
+	b.WriteString("desc")
+	binary.Write(&b, binary.BigEndian, uint32(0))
+	binary.Write(&b, binary.BigEndian, uint32(len(desc)+1))
+	b.WriteString(desc + "\x00")
+	b.Write(make([]byte, 4+4+2+1+67))
+	return iccTag{sig: "desc", data: b.Bytes()}
+}
+
+// iccSegment returns an APP2 segment containing the ICC profile in one chunk.
+func iccSegment(profile []byte) []byte {
+	payload := append([]byte("ICC_PROFILE\x00\x01\x01"), profile...)
+	return segment(0xE2, payload)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tj/letterbox"
+)
+
+// asset is a hash map entry, in the same shape as Vite manifest entries.
+type asset struct {
+	File string `json:"file"`
+	Src  string `json:"src"`
+}
+
+// writeHashMap writes a copy of each output with a content-hashed filename,
+// such as "photo.3f2a1b9c.jpg", and the map of logical output names relative
+// to dir to them. Entries of previous runs are kept, and their stale hashed
+// copies removed.
+func writeHashMap(path, dir string, results []letterbox.Result) error {
+	assets := make(map[string]asset)
+
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(b, &assets); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, r := range results {
+		if r.Error != "" || r.Rejected || r.Output == "" {
+			continue
+		}
+
+		name, err := filepath.Rel(dir, r.Output)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+
+		file, err := hashedCopy(r.Output)
+		if err != nil {
+			return fmt.Errorf("hashing %s: %w", r.Output, err)
+		}
+
+		file, err = filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		file = filepath.ToSlash(file)
+
+		if prev, ok := assets[name]; ok && prev.File != file {
+			os.Remove(filepath.Join(dir, filepath.FromSlash(prev.File)))
+		}
+
+		assets[name] = asset{File: file, Src: filepath.ToSlash(r.Source)}
+	}
+
+	b, err = json.MarshalIndent(assets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// hashedCopy writes a copy of the file at path named with its content hash,
+// returning the path of the copy.
+func hashedCopy(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	ext := filepath.Ext(path)
+	dst := strings.TrimSuffix(path, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	}
+
+	// hard link when possible, falling back to a copy
+	if err := os.Link(path, dst); err == nil {
+		return dst, nil
+	}
+
+	return dst, copyFile(path, dst)
+}
+
+// copyFile copies the file src to dst.
+func copyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// inputsFlag is a repeatable flag of input directories.
+type inputsFlag []string
+
+// String implementation.
+func (f *inputsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set implementation.
+func (f *inputsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// mergeInputs returns the images within the directories dirs, recursively,
+// with those of later directories overriding those of earlier directories at
+// the same relative path, such as per-locale overrides of a base set. Images
+// are ordered by relative path.
+func mergeInputs(dirs, include, exclude []string) ([]string, error) {
+	merged := make(map[string]string)
+
+	for _, dir := range dirs {
+		images, err := walkImages(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		var overrides int
+		for _, path := range images {
+			name := filepath.Base(path)
+			if len(include) > 0 && !matches(name, include) || matches(name, exclude) {
+				continue
+			}
+
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := merged[rel]; ok {
+				overrides++
+			}
+			merged[rel] = path
+		}
+
+		logger.Debug("Merged input", "dir", dir, "images", len(images), "overrides", overrides)
+	}
+
+	rels := make([]string, 0, len(merged))
+	for rel := range merged {
+		rels = append(rels, rel)
+	}
+	slices.Sort(rels)
+
+	images := make([]string, len(rels))
+	for i, rel := range rels {
+		images[i] = merged[rel]
+	}
+
+	return images, nil
+}
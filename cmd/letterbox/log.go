@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// logger used by the cli.
+var logger = slog.Default()
+
+// newLogger returns a logger with the given format and level.
+func newLogger(format string, level *slog.LevelVar) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{
+		Level: level,
+	}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format %q", format)
+	}
+}
+
+// fail logs the error and returns the exit status.
+func fail(msg string, err error) int {
+	logger.Error(msg, "error", err)
+	return 1
+}
@@ -2,81 +2,1284 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"image"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"maps"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/tj/letterbox"
 )
 
+// commands are the subcommands, images are processed otherwise.
+var commands = map[string]func(args []string) error{
+	"export-metadata": exportMetadata,
+	"gen-fixtures":    genFixtures,
+	"metrics":         metrics,
+	"orientations":    orientations,
+	"revert":          revert,
+	"selftest":        selftest,
+	"stream":          stream,
+}
+
 func main() {
-	dir := flag.String("output", "processed", "Image output directory")
+	os.Exit(run())
+}
+
+// run runs the cli and returns its exit status, so that deferred cleanup of
+// temporary directories runs before exiting.
+func run() int {
+	// subcommands
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				return fail("error running "+os.Args[1], err)
+			}
+			return 0
+		}
+	}
+
+	var inputs inputsFlag
+	flag.Var(&inputs, "input", "Input directory, repeatable, where images of later directories override those at the same relative path in earlier ones")
+	dir := flag.String("output", "processed", "Image output directory, or a .zip, .tar or .tar.gz archive the outputs are written to")
+	preservePaths := flag.Bool("preserve-paths", false, "Mirror the relative directory structure of images under the output directory, instead of flattening to their names")
+	nameTemplate := flag.String("name-template", "", "Output filename template such as \"{{.Base}}_{{.Aspect}}_{{.Width}}x{{.Height}}{{.Ext}}\", with Base, Ext, Dir, Aspect, Width, Height, Format and Date")
+	siteName := flag.String("site", "", "Static site generator mode, hugo or jekyll, processing the images referenced by pages into the static directory")
+	rewrite := flag.Bool("rewrite-references", false, "Rewrite the image references of pages to the outputs in -site mode, and those of markdown, docx and pptx inputs")
+	bagPath := flag.String("bag", "", "Write the output directory as a BagIt bag to the given directory, with sha256 and sha512 manifests")
+	hashMapPath := flag.String("emit-hash-map", "", "Write content-hashed copies of outputs and a Vite-compatible manifest mapping output names to them")
 	white := flag.Bool("white", false, "Output a white letterbox")
-	aspect := flag.String("aspect", "16:9", "Output aspect ratio")
+	cornerRadius := flag.Int("corner-radius", 0, "Radius of rounded corners the image is masked with in pixels")
+	borderWidth := flag.Int("border", 0, "Width of a stroke border drawn around the image in pixels")
+	borderColor := flag.String("border-color", "white", "Border color such as #ffffff, or a token reference")
+	shadowBlur := flag.Int("shadow", 0, "Blur radius of a soft drop shadow drawn beneath the image in pixels")
+	shadowOffset := flag.String("shadow-offset", "", "Offset of the drop shadow in pixels, such as 0,10")
+	shadowColor := flag.String("shadow-color", "#00000080", "Drop shadow color such as #00000080, or a token reference")
+	watermarkPath := flag.String("watermark", "", "Watermark image composited over each output")
+	watermarkPosition := flag.String("watermark-position", "bottom-right", "Watermark position: center, top, bottom, left, right, or corners such as bottom-right")
+	watermarkOpacity := flag.Float64("watermark-opacity", 1, "Watermark opacity from 0 to 1")
+	bg := flag.String("bg", "", "Background color such as #1a1a1a, transparent with -format png or tiff, or a token reference such as var(--surface-dark), overriding -white")
+	tokensPath := flag.String("tokens", "", "Design tokens JSON or CSS custom properties file used to resolve color references")
+	aspect := flag.String("aspect", "16:9", "Output aspect ratio such as 16:9, 1920x1080 or 1.777, auto for the most common ratio of the images, or comma-separated ratios written to a sub-directory each")
+	aspectFrom := flag.String("aspect-from", "", "Reference image whose aspect ratio is used in place of -aspect")
+	aspectPortrait := flag.String("aspect-portrait", "", "Output aspect ratio of portrait sources, such as 4:5, in place of -aspect")
+	aspectLandscape := flag.String("aspect-landscape", "", "Output aspect ratio of landscape and square sources, such as 16:9, in place of -aspect")
 	quality := flag.Int("quality", 90, "Output jpeg quality")
-	padding := flag.Int("padding", 0, "Output image padding in percentage")
+	tolerance := flag.Float64("tolerance", 0, "Copy sources within the relative difference from the aspect ratio through untouched, such as 0.02, rather than re-encoding them with a thin bar")
+	hardLink := flag.Bool("hard-link", false, "Hard-link sources passed through by -tolerance rather than copying them")
+	padding := flag.String("padding", "", "Output image padding in percentage, or a CSS-like margin such as 5%, 40px or \"5% 10%\" inset from the canvas edges")
+	padTo := flag.String("pad-to", "", "Add bars of the given percentage of the height instead of padding to the aspect ratio, such as 20%")
+	split := flag.Bool("split", false, "Slice sources taller than the aspect ratio, such as scrolling screenshots, into sequential pages, letterboxing the last partial page")
+	splitOverlap := flag.String("split-overlap", "", "Percentage of each page's height repeated at the top of the next page with -split, such as 10%")
 	concurrency := flag.Int("concurrency", runtime.NumCPU(), "Concurrency of image processing")
+	ioConcurrency := flag.Int("io-concurrency", 0, "Concurrency of reading sources and writing outputs, pipelined with processing limited by -concurrency, 0 to read and write within each processing slot")
+	adaptive := flag.Bool("adaptive", false, "Adapt concurrency at runtime based on throughput and memory headroom, up to -concurrency")
+	schedule := flag.String("schedule", "input", "Order images are scheduled in: input, or largest files first to improve tail latency")
 	force := flag.Bool("force", false, "Force image reprocess when it exists")
+	backupDir := flag.String("backup-dir", "", "Directory existing outputs are preserved in before being overwritten, such as .letterbox-backup, so that \"letterbox revert\" can restore them")
+	skipName := flag.String("skip", "mtime", "Skip policy: mtime, hash, manifest, always or never")
+	skipFile := flag.String("skip-file", "", "Cache file for the hash skip policy, or a previous report for the manifest skip policy")
+	timings := flag.Bool("stats", false, "Write the decode, compose and encode timings of each image, their percentiles and the throughput to stderr")
+	reportPath := flag.String("report", "", "Output a JSON report to the given path, or stdout when \"-\"")
+	reviewPath := flag.String("review", "", "Output an mp4 for reviewing the processed images (requires ffmpeg)")
+	reviewDuration := flag.Duration("review-duration", 500*time.Millisecond, "Duration of each image in the review mp4")
+	reproducible := flag.Bool("reproducible", false, "Produce bit-exact outputs across runs and machines, verifying them against the fingerprints of -registry")
+	registryPath := flag.String("registry", "", "Output fingerprint registry for -reproducible, defaults to .letterbox-fingerprints.json in the output directory")
+	backendName := flag.String("backend", "go", "Backend decoding and scaling sources: go, or vips for faster and leaner processing of large sources when installed")
+	thumbnailSize := flag.Int("thumbnail", 0, "Embed an EXIF thumbnail of the output no larger than the given size in pixels, such as 160")
+	metadataName := flag.String("metadata-backend", "go", "Metadata backend used to copy metadata to outputs: go, exiftool or none")
+	sidecars := flag.Bool("sidecars", false, "Read and write XMP sidecars, skipping rejects and applying crops")
+	warningsAsErrors := flag.Bool("warnings-as-errors", false, "Fail images with warnings, such as metadata which could not be preserved")
+	frameAt := flag.String("frame-at", "first", "Frame of video inputs letterboxed as a poster: first, middle, or a position such as 00:00:05 (requires ffmpeg)")
+	rawDecoder := flag.String("raw", "preview", "Decoding of camera RAW inputs such as CR2, NEF, ARW or DNG: preview to extract their embedded jpeg preview, or dcraw to develop them (requires dcraw or LibRaw's dcraw_emu)")
+	page := flag.Int("page", 1, "Page of pdf inputs letterboxed, from 1 (requires pdftoppm)")
+	dpi := flag.Int("dpi", 150, "Resolution pdf pages are rendered at")
+	verifyOutput := flag.Bool("verify-output", false, "Decode each output once written, failing images whose output is undecodable or has unexpected dimensions")
+	timeout := flag.Duration("timeout", 0, "Fail images which take longer than the given duration to process, such as 30s")
+	cgroupMemory := flag.String("cgroup-memory", "", "Limit the memory of the process with a cgroup v2 of its own on linux, such as 4GB, beyond which it is reclaimed or killed")
+	cgroupCPUs := flag.Float64("cgroup-cpus", 0, "Limit the cpu time of the process with a cgroup v2 of its own on linux, in cpus such as 1.5")
+	maxDuration := flag.Duration("max-duration", 0, "Stop scheduling images after the given duration, such as 2h")
+	maxImages := flag.Int("max-images", 0, "Stop scheduling images after the given number of images")
+	resumeFile := flag.String("resume-file", "", "File the remaining images are written to when stopping early, and read from when no images are given")
+	stateFile := flag.String("state-file", "", "File tracking failures across runs, defaults to .letterbox-state.json in the output directory")
+	resume := flag.Bool("resume", false, "Resume an interrupted run from its checkpoint in the output directory, retrying the images in-flight when it stopped")
+	retryFailed := flag.Bool("retry-failed", false, "Process only the images which failed in the previous run")
+	dryRun := flag.Bool("dry-run", false, "Output what would be processed without writing anything")
+	previewNames := flag.Bool("preview-names", false, "Output the output of every image without processing, flagging collisions, naming template errors and overlong paths, and failing when there are any")
+	explain := flag.String("explain", "", "Output the resolved pipeline stages, their parameters and estimated pixels for the first image as a tree, or dot for graphviz, without processing")
+	quiet := flag.Bool("quiet", false, "Output warnings and errors only")
+	verbose := flag.Bool("verbose", false, "Output debug logs")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	configPath := flag.String("config", "", "Config file path, defaults to letterbox.yaml or .letterboxrc when present")
+	presetName := flag.String("preset", "", "Output preset: "+strings.Join(presetNames(nil), ", "))
+	size := flag.String("size", "", "Exact output dimensions such as 1920x1080, scaling the source down to fit")
+	forceEven := flag.Bool("force-even", false, "Pad outputs to even dimensions, as required by H.264 yuv420p")
+	offset := flag.String("offset", "", "Offset of the placed image in pixels or percent of the canvas, such as 0,-10%")
+	round := flag.String("round", "floor", "Rounding of fractional canvas dimensions: floor, ceil, or even for video encoders")
+	fit := flag.String("fit", "pad", "Fit to the aspect ratio or size: pad with bars, cover by cropping, contain within the size without bars, or stretch")
+	gravity := flag.String("gravity", "center", "Placement of the image: center, top, bottom, left, right, corners such as bottom-left, or smart to keep subjects in frame using an edge-density heuristic")
+	parallelEncode := flag.Int("parallel-encode", 0, "Encode jpeg outputs of at least the given number of pixels in parallel bands separated by restart markers, such as 50000000, 0 to disable")
+	preserveTimes := flag.Bool("preserve-times", false, "Copy the access and modification times and mode of sources onto outputs, skipping with the hash policy by default as outputs are no newer than sources")
+	tolerateCorrupt := flag.Bool("tolerate-corrupt", false, "Salvage truncated and corrupt jpegs, filling the missing region with the bar color and flagging them as corrupt, rather than failing")
+	scaledDecode := flag.Bool("scaled-decode", false, "Decode baseline JPEG sources at 1/2, 1/4 or 1/8 scale when outputs are at least that much smaller, for faster decoding and lower memory usage")
+	sourceDoneAction := flag.String("source-done", "keep", "Source disposal once processed successfully, skipped included: keep, delete, or move to -source-done-dir")
+	sourceDoneDir := flag.String("source-done-dir", "", "Directory sources are moved to once processed successfully, such as done, at their relative path")
+	newerThan := flag.String("newer-than", "", "Only process images modified at or after the date, such as 2024-01-01")
+	olderThan := flag.String("older-than", "", "Only process images modified before the date, such as 2024-02-01")
+	minSize := flag.String("min-size", "", "Only process images of at least the file size, such as 100KB")
+	maxSize := flag.String("max-size", "", "Only process images of at most the file size, such as 20MB")
+	maxPixels := flag.Int("max-pixels", 0, "Fail sources with more pixels than the given number, checked before decoding")
+	maxMemory := flag.String("max-memory", "", "Limit the memory of decoded images in-flight across workers, such as 2GB")
+	maxWidth := flag.Int("max-width", 0, "Maximum source width, larger sources are scaled down before letterboxing")
+	maxHeight := flag.Int("max-height", 0, "Maximum source height, larger sources are scaled down before letterboxing")
+	resampler := flag.String("resampler", "catmull-rom", "Resampler used for scaling: lanczos, catmull-rom, bilinear or nearest")
+	upscale := flag.Bool("upscale", false, "Scale sources smaller than -size up to fit")
+	pngCompression := flag.String("png-compression", "default", "Output png compression: default, none, fast or best")
+	format := flag.String("format", "jpeg", "Output format: jpeg, png or tiff")
+	depth := flag.Int("depth", 8, "Bits per channel of compositing and output, 16 preserves 16-bit sources and requires png or tiff")
+	stdin := flag.Bool("stdin", false, "Read a JSON request from stdin and write a JSON report to stdout")
 	flag.Parse()
 
+	// logging
+	var level slog.LevelVar
+	switch {
+	case *verbose:
+		level.Set(slog.LevelDebug)
+	case *quiet:
+		level.Set(slog.LevelWarn)
+	}
+
+	l, err := newLogger(*logFormat, &level)
+	if err != nil {
+		return fail("error creating logger", err)
+	}
+	logger = l
+	slog.SetDefault(logger)
+
+	// flags explicitly set take precedence over the preset and config
+	explicit := flagsSet()
+
+	// request from stdin, taking precedence over flags
+	var requested []string
+	if *stdin {
+		req, err := readRequest(os.Stdin)
+		if err != nil {
+			return fail("error reading request", err)
+		}
+
+		values := req.values()
+		err = setFlags(values, nil)
+		if err != nil {
+			return fail("error applying request", err)
+		}
+
+		for name, v := range values {
+			if v != "" {
+				explicit[name] = true
+			}
+		}
+
+		requested = req.Images
+		*reportPath = "-"
+	}
+
+	// config
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return fail("error loading config", err)
+	}
+
+	err = setFlags(cfg.values(), explicit)
+	if err != nil {
+		return fail("error applying config", err)
+	}
+
+	// preset, taking precedence over the config
+	if *presetName != "" {
+		p, err := findPreset(*presetName, cfg.Presets)
+		if err != nil {
+			return fail("error finding preset", err)
+		}
+
+		// encoder profile, the preset settings taking precedence
+		if p.Profile != "" {
+			err = setFlags(cfg.Profiles[p.Profile].values(), explicit)
+			if err != nil {
+				return fail("error applying profile", err)
+			}
+		}
+
+		err = setFlags(p.values(), explicit)
+		if err != nil {
+			return fail("error applying preset", err)
+		}
+	}
+
+	// cgroup
+	if *cgroupMemory != "" || *cgroupCPUs != 0 {
+		memory, err := parseBytes(*cgroupMemory)
+		if err != nil {
+			return fail("error parsing cgroup memory", err)
+		}
+
+		if *cgroupCPUs < 0 {
+			return fail("error parsing cgroup cpus", fmt.Errorf("cpus must be positive, got %g", *cgroupCPUs))
+		}
+
+		dir, err := limitCgroup(memory, *cgroupCPUs)
+		if err != nil {
+			return fail("error limiting resources", err)
+		}
+
+		// keep the heap and scheduler within the limits
+		if memory > 0 {
+			debug.SetMemoryLimit(memory)
+		}
+
+		if *cgroupCPUs > 0 {
+			runtime.GOMAXPROCS(int(math.Ceil(*cgroupCPUs)))
+		}
+
+		logger.Info("Limited resources", "cgroup", dir, "memory", *cgroupMemory, "cpus", *cgroupCPUs)
+	}
+
+	// size
+	width, height, err := parseSize(*size)
+	if err != nil {
+		return fail("error parsing size", err)
+	}
+
+	// design tokens
+	t := make(tokens)
+	if *tokensPath != "" {
+		t, err = readTokens(*tokensPath)
+		if err != nil {
+			return fail("error reading tokens", err)
+		}
+	}
+
+	// background
+	background := letterbox.WithWhiteBackground(*white)
+	if *bg != "" {
+		c, err := t.color(*bg)
+		if err != nil {
+			return fail("error parsing background", err)
+		}
+
+		if _, _, _, a := c.RGBA(); a < 0xffff && isJPEG(*format) {
+			return fail("error parsing background", fmt.Errorf("transparent backgrounds require -format png or tiff, as jpeg has no alpha channel"))
+		}
+
+		background = letterbox.WithBackground(c)
+	}
+
+	if *depth == 16 && isJPEG(*format) {
+		return fail("error parsing depth", fmt.Errorf("16-bit depth requires -format png or tiff, as jpeg is limited to 8 bits"))
+	}
+
+	var options []letterbox.Option
+
+	// border
+	if *borderWidth > 0 {
+		c, err := t.color(*borderColor)
+		if err != nil {
+			return fail("error parsing border color", err)
+		}
+
+		options = append(options, letterbox.WithBorder(*borderWidth, c))
+	}
+
+	// shadow
+	if *shadowBlur > 0 || *shadowOffset != "" {
+		c, err := t.color(*shadowColor)
+		if err != nil {
+			return fail("error parsing shadow color", err)
+		}
+
+		offset, err := parsePoint(*shadowOffset)
+		if err != nil {
+			return fail("error parsing shadow offset", err)
+		}
+
+		options = append(options, letterbox.WithShadow(*shadowBlur, offset, c))
+	}
+
+	// watermark
+	if *watermarkPath != "" {
+		img, err := readImage(*watermarkPath)
+		if err != nil {
+			return fail("error reading watermark", err)
+		}
+
+		options = append(options, letterbox.WithWatermark(img, *watermarkPosition, *watermarkOpacity))
+	}
+
+	// name template
+	if *nameTemplate != "" {
+		options = append(options, letterbox.WithNameTemplate(*nameTemplate))
+	}
+
+	// padding
+	paddingPercent, margin, err := parsePadding(*padding)
+	if err != nil {
+		return fail("error parsing padding", err)
+	}
+
+	// pad-to
+	var padToPercent float64
+	if *padTo != "" {
+		padToPercent, err = strconv.ParseFloat(strings.TrimSuffix(*padTo, "%"), 64)
+		if err != nil {
+			return fail("error parsing pad-to", fmt.Errorf("invalid percentage %q", *padTo))
+		}
+	}
+
+	// split overlap
+	var overlapPercent float64
+	if *splitOverlap != "" {
+		overlapPercent, err = strconv.ParseFloat(strings.TrimSuffix(*splitOverlap, "%"), 64)
+		if err != nil {
+			return fail("error parsing split overlap", fmt.Errorf("invalid percentage %q", *splitOverlap))
+		}
+	}
+
+	// offset
+	offsetX, offsetY, err := parseOffset(*offset)
+	if err != nil {
+		return fail("error parsing offset", err)
+	}
+
+	// source disposal
+	done, err := newSourceDone(*sourceDoneAction, *sourceDoneDir)
+	if err != nil {
+		return fail("error parsing source disposal", err)
+	}
+
+	if !done.keep() && *siteName != "" {
+		return fail("error parsing source disposal", fmt.Errorf("site images cannot be deleted or moved"))
+	}
+
+	// static site generator
+	var ssg site
+	if *siteName != "" {
+		ssg, err = findSite(*siteName)
+		if err != nil {
+			return fail("error finding site", err)
+		}
+
+		if !explicit["output"] {
+			*dir = ssg.output
+		}
+	}
+
+	// archive output, written from a temporary directory once processed
+	var archivePath string
+	if archiveExt(*dir) != "" {
+		archivePath = *dir
+		*dir, err = ioutil.TempDir("", "letterbox-output")
+		if err != nil {
+			return fail("error creating output directory", err)
+		}
+		defer os.RemoveAll(*dir)
+	}
+
 	// create destination directory
-	err := os.MkdirAll(*dir, 0755)
+	if !*dryRun && *explain == "" && !*previewNames {
+		err := os.MkdirAll(*dir, 0755)
+		if err != nil {
+			return fail("error creating output directory", err)
+		}
+	}
+
+	// state
+	if *stateFile == "" {
+		*stateFile = filepath.Join(*dir, ".letterbox-state.json")
+	}
+
+	st, err := loadState(*stateFile)
 	if err != nil {
-		log.Fatalf("error creating output directory: %s\n", err)
+		return fail("error loading state", err)
 	}
 
-	// images explicitly passed, or inferred
+	// images explicitly passed, failed previously, or inferred
 	images := flag.Args()
+	if *retryFailed {
+		images = st.Failed()
+		if len(images) == 0 {
+			logger.Info("No failed images to retry")
+			return 0
+		}
+	}
+
+	if len(requested) > 0 {
+		images = requested
+	}
+
+	checkpointFile := filepath.Join(*dir, ".letterbox-checkpoint")
+	if *resume {
+		images, err = readCheckpoint(checkpointFile)
+		if err != nil {
+			return fail("error reading checkpoint", err)
+		}
+
+		if images == nil {
+			logger.Info("No checkpoint to resume")
+			return 0
+		}
+
+		if len(images) == 0 {
+			logger.Info("No images remaining to resume")
+			os.Remove(checkpointFile)
+			return 0
+		}
+
+		logger.Info("Resuming", "remaining", len(images))
+	}
+
+	if len(images) == 0 && *siteName != "" {
+		images, err = ssg.images(*dir)
+		if err != nil {
+			return fail("error finding site images", err)
+		}
+
+		if len(images) == 0 {
+			logger.Info("No referenced images to process")
+			return 0
+		}
+	}
+
+	if len(images) == 0 && *resumeFile != "" {
+		images, err = readLines(*resumeFile)
+		if err != nil && !os.IsNotExist(err) {
+			return fail("error reading resume file", err)
+		}
+	}
+
+	if len(inputs) == 0 {
+		inputs = cfg.Inputs
+	}
+
+	if len(images) == 0 && len(inputs) > 0 {
+		images, err = mergeInputs(inputs, cfg.Include, cfg.Exclude)
+		if err != nil {
+			return fail("error merging inputs", err)
+		}
+	}
+
 	if len(images) == 0 {
-		images, err = listImages(".")
+		images, err = listImages(".", cfg.Include, cfg.Exclude)
 		if err != nil {
-			log.Fatalf("error listing images: %s", err)
+			return fail("error listing images", err)
 		}
 	}
 
+	// date and size window
+	win, err := newWindow(*newerThan, *olderThan, *minSize, *maxSize)
+	if err != nil {
+		return fail("error parsing window", err)
+	}
+
+	if !win.empty() {
+		n := len(images)
+		images, err = win.filter(images)
+		if err != nil {
+			return fail("error filtering images", err)
+		}
+
+		logger.Info("Filtered images", "window", len(images), "excluded", n-len(images))
+		if len(images) == 0 {
+			return 0
+		}
+	}
+
+	// archive, video, pdf, raw and document inputs, extracted into a temporary directory
+	var extractDir string
+	var extracted map[string]string
+	var docs []string
+	if slices.ContainsFunc(images, func(path string) bool {
+		return archiveExt(path) != "" || isVideo(path) || isPDF(path) || isRaw(path) || isDocument(path)
+	}) {
+		if *page < 1 {
+			return fail("error rendering pdf pages", fmt.Errorf("page must be 1 or greater, got %d", *page))
+		}
+
+		if *dpi < 1 {
+			return fail("error rendering pdf pages", fmt.Errorf("dpi must be 1 or greater, got %d", *dpi))
+		}
+
+		extractDir, err = ioutil.TempDir("", "letterbox-input")
+		if err != nil {
+			return fail("error extracting inputs", err)
+		}
+		defer os.RemoveAll(extractDir)
+
+		images, extracted, err = archives(images, extractDir)
+		if err != nil {
+			return fail("error extracting archives", err)
+		}
+
+		var frames map[string]string
+		images, frames, err = posters(images, extractDir, *frameAt)
+		if err != nil {
+			return fail("error extracting video frames", err)
+		}
+		maps.Copy(extracted, frames)
+
+		var pages map[string]string
+		images, pages, err = pdfPages(images, extractDir, *page, *dpi)
+		if err != nil {
+			return fail("error rendering pdf pages", err)
+		}
+		maps.Copy(extracted, pages)
+
+		var decoded map[string]string
+		images, decoded, err = raws(images, extractDir, *rawDecoder, *quality)
+		if err != nil {
+			return fail("error decoding raw images", err)
+		}
+		maps.Copy(extracted, decoded)
+
+		var media map[string]string
+		images, docs, media, err = documents(images, extractDir, *dir)
+		if err != nil {
+			return fail("error finding document images", err)
+		}
+		maps.Copy(extracted, media)
+
+		if *rewrite && archivePath != "" && slices.ContainsFunc(docs, func(doc string) bool { return !isOffice(doc) }) {
+			return fail("error finding document images", fmt.Errorf("markdown references cannot be rewritten to an archive output"))
+		}
+	}
+
+	// aspect
+	if *aspectFrom != "" {
+		if explicit["aspect"] {
+			return fail("error parsing aspect", fmt.Errorf("-aspect-from and -aspect are mutually exclusive"))
+		}
+
+		*aspect, err = referenceAspect(*aspectFrom)
+		if err != nil {
+			return fail("error reading reference aspect", err)
+		}
+
+		logger.Info("Using reference aspect", "path", *aspectFrom, "aspect", *aspect)
+	} else if *aspect == "auto" {
+		*aspect, err = commonAspect(images)
+		if err != nil {
+			return fail("error inferring aspect", err)
+		}
+
+		logger.Info("Using common aspect", "aspect", *aspect)
+	}
+
+	// metadata
+	if *reproducible && *metadataName == "exiftool" {
+		return fail("error creating metadata backend", fmt.Errorf("exiftool may write varying timestamps, use go or none with -reproducible"))
+	}
+
+	metadata, err := metadataBackend(*metadataName)
+	if err != nil {
+		return fail("error creating metadata backend", err)
+	}
+
+	// backend
+	loader, err := imageLoader(*backendName)
+	if err != nil {
+		return fail("error creating backend", err)
+	}
+
+	// memory
+	memoryLimit, err := parseBytes(*maxMemory)
+	if err != nil {
+		return fail("error parsing max memory", err)
+	}
+
+	// skip policy, by hash as preserved times defeat mtime comparisons
+	if *preserveTimes && *skipName == "mtime" {
+		if explicit["skip"] {
+			return fail("error creating skip policy", fmt.Errorf("-preserve-times requires a -skip policy other than mtime"))
+		}
+		*skipName = "hash"
+	}
+
+	skip, err := skipPolicy(*skipName, *skipFile, *dir)
+	if err != nil {
+		return fail("error creating skip policy", err)
+	}
+
 	// process
 	start := time.Now()
-	log.Printf("Processing %d images\n", len(images))
+	logger.Info("Processing images", "count", len(images))
+
+	var rep report
+	var bar *progress
 
-	processor, err := letterbox.New(*dir,
-		letterbox.WithWhiteBackground(*white),
+	var cp *checkpoint
+
+	options = append(options,
+		background,
 		letterbox.WithConcurrency(*concurrency),
+		letterbox.WithIOConcurrency(*ioConcurrency),
+		letterbox.WithAdaptiveConcurrency(*adaptive),
+		letterbox.WithSchedule(*schedule),
 		letterbox.WithQuality(*quality),
 		letterbox.WithForce(*force),
-		letterbox.WithAspect(*aspect),
-		letterbox.WithPadding(*padding),
+		letterbox.WithSkipPolicy(skip),
+		letterbox.WithAspects(strings.Split(*aspect, ",")...),
+		letterbox.WithOrientedAspects(*aspectPortrait, *aspectLandscape),
+		letterbox.WithTolerance(*tolerance),
+		letterbox.WithHardLinks(*hardLink),
+		letterbox.WithPadding(paddingPercent),
+		letterbox.WithMargin(margin),
+		letterbox.WithPadTo(padToPercent),
+		letterbox.WithSplit(*split),
+		letterbox.WithSplitOverlap(overlapPercent),
+		letterbox.WithSize(width, height),
+		letterbox.WithUpscale(*upscale),
+		letterbox.WithMaxSize(*maxWidth, *maxHeight),
+		letterbox.WithFit(*fit),
+		letterbox.WithGravity(*gravity),
+		letterbox.WithRounding(*round),
+		letterbox.WithForceEven(*forceEven),
+		letterbox.WithOffset(offsetX, offsetY),
+		letterbox.WithCornerRadius(*cornerRadius),
+		letterbox.WithResampler(*resampler),
+		letterbox.WithScaledDecode(*scaledDecode),
+		letterbox.WithParallelEncode(*parallelEncode),
+		letterbox.WithTolerateCorrupt(*tolerateCorrupt),
+		letterbox.WithPreserveTimes(*preserveTimes),
+		letterbox.WithFormat(*format),
+		letterbox.WithDepth(*depth),
+		letterbox.WithPNGCompression(*pngCompression),
+		letterbox.WithDryRun(*dryRun),
+		letterbox.WithMetadataBackend(metadata),
+		letterbox.WithThumbnail(*thumbnailSize),
+		letterbox.WithBackups(*backupDir),
+		letterbox.WithLoader(loader),
+		letterbox.WithSidecars(*sidecars),
+		letterbox.WithPreservePaths(*preservePaths || *siteName != "" || len(docs) > 0 || len(inputs) > 0),
+		letterbox.WithSourceRoot(append(inputs, extractDir)...),
+		letterbox.WithWarningsAsErrors(*warningsAsErrors),
+		letterbox.WithVerifyOutput(*verifyOutput),
+		letterbox.WithMaxDuration(*maxDuration),
+		letterbox.WithTimeout(*timeout),
+		letterbox.WithMaxPixels(*maxPixels),
+		letterbox.WithMaxMemory(memoryLimit),
+		letterbox.WithMaxImages(*maxImages),
+		letterbox.WithLogger(logger),
+		letterbox.WithResultHandler(func(r letterbox.Result) {
+			rep.Images = append(rep.Images, r)
+			if cp != nil {
+				if err := cp.Add(r); err != nil {
+					logger.Error("Error writing checkpoint", "error", err)
+				}
+			}
+			if prev, ok := st.Failures[r.Source]; ok && *retryFailed {
+				if r.Error == "" {
+					logger.Info("Retry succeeded", "path", r.Source, "previous_error", prev)
+				} else {
+					logger.Warn("Retry failed", "path", r.Source, "error", r.Error, "previous_error", prev)
+				}
+			}
+			if bar != nil {
+				bar.Add(r)
+			}
+		}),
 	)
 
+	processor, err := letterbox.New(*dir, options...)
 	if err != nil {
-		log.Fatalf("error creating proessor: %s", err)
+		return fail("error creating proessor", err)
 	}
 
-	ctx := context.Background()
+	// explain
+	if *explain != "" {
+		stage, err := processor.Explain(images)
+		if err != nil {
+			return fail("error explaining pipeline", err)
+		}
+
+		if err := writeExplain(os.Stdout, stage, *explain); err != nil {
+			return fail("error explaining pipeline", err)
+		}
+
+		return 0
+	}
+
+	// name preview
+	if *previewNames {
+		mappings := processor.Names(images)
+		for i, m := range mappings {
+			mappings[i].Source = archived(m.Source, extracted)
+			if archivePath != "" && m.Output != "" {
+				mappings[i].Output = archived(m.Output, map[string]string{*dir: archivePath})
+			}
+		}
+
+		n, err := writeNames(os.Stdout, mappings)
+		if err != nil {
+			return fail("error previewing names", err)
+		}
+
+		if n > 0 {
+			return fail("error previewing names", fmt.Errorf("%d of %d outputs have problems", n, len(mappings)))
+		}
+
+		return 0
+	}
+
+	// targets of each image, which depend on their orientation and pages
+	targets := make(map[string]int)
+	total := 0
+	for _, path := range images {
+		targets[path] = processor.Targets(path)
+		total += targets[path]
+	}
+
+	// checkpoint
+	if !*dryRun {
+		cp, err = newCheckpoint(checkpointFile, images, targets, *resume)
+		if err != nil {
+			return fail("error creating checkpoint", err)
+		}
+	}
+
+	// progress bar, falling back to plain logging
+	prev := level.Level()
+	if isTerminal(os.Stdout) && !*dryRun && !*verbose && !*stdin && *logFormat == "text" {
+		bar = newProgress(os.Stdout, total)
+		level.Set(slog.LevelError)
+	}
+
+	// signals, removing the temporary directories when exiting immediately
+	var temporary []string
+	if archivePath != "" {
+		temporary = append(temporary, *dir)
+	}
+	if extractDir != "" {
+		temporary = append(temporary, extractDir)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go interrupt(cancel, *dir, temporary, logger)
+
 	err = processor.Process(ctx, images)
+
+	if bar != nil {
+		bar.Stop()
+		level.Set(prev)
+	}
+
+	// sources named by their archives and videos
+	if extracted != nil {
+		for i, r := range rep.Images {
+			rep.Images[i].Source = archived(r.Source, extracted)
+		}
+	}
+
+	// checkpoint
+	if cp != nil {
+		if err := cp.Close(); err != nil {
+			logger.Error("Error closing checkpoint", "error", err)
+		}
+	}
+
+	// budget or interruption
+	var budget *letterbox.BudgetError
+	var canceled *letterbox.CanceledError
+	var remaining []string
+
+	if errors.As(err, &budget) {
+		logger.Warn("Stopped early", "remaining", len(budget.Remaining))
+		remaining = budget.Remaining
+		err = nil
+	} else if errors.As(err, &canceled) {
+		remaining = canceled.Remaining
+		err = nil
+	}
+
+	if remaining != nil {
+		if *resumeFile != "" {
+			if err := writeLines(*resumeFile, remaining); err != nil {
+				return fail("error writing resume file", err)
+			}
+		}
+	} else if *resumeFile != "" && err == nil {
+		os.Remove(*resumeFile)
+	}
+
+	// state
+	if !*dryRun {
+		st.Update(rep.Images)
+		if err := st.Save(*stateFile); err != nil {
+			return fail("error saving state", err)
+		}
+	}
+
+	// hashes
+	if h, ok := skip.(*letterbox.HashSkip); ok && !*dryRun {
+		if err := h.Save(); err != nil {
+			return fail("error saving hashes", err)
+		}
+	}
+
+	// hash map
+	if *hashMapPath != "" && !*dryRun {
+		if err := writeHashMap(*hashMapPath, *dir, rep.Images); err != nil {
+			return fail("error writing hash map", err)
+		}
+	}
+
+	// bag
+	if *bagPath != "" && !*dryRun && canceled == nil {
+		if err := writeBag(*bagPath, *dir); err != nil {
+			return fail("error writing bag", err)
+		}
+		logger.Info("Wrote bag", "path", *bagPath)
+	}
+
+	// fingerprints
+	if *reproducible && !*dryRun {
+		path := *registryPath
+		if path == "" {
+			path = filepath.Join(*dir, ".letterbox-fingerprints.json")
+		}
+
+		reg, err := loadRegistry(path)
+		if err != nil {
+			return fail("error loading registry", err)
+		}
+
+		if reg.Go != runtime.Version() {
+			logger.Warn("Fingerprints were recorded with a different version of Go, whose encoders may differ", "recorded", reg.Go, "current", runtime.Version())
+		}
+
+		mismatches, err := reg.Verify(*dir, rep.Images)
+		if err != nil {
+			return fail("error verifying fingerprints", err)
+		}
+
+		for _, m := range mismatches {
+			logger.Error("Output differs from its fingerprint", "output", m.Output, "recorded", m.Recorded, "actual", m.Actual)
+		}
+
+		if err := reg.Save(path); err != nil {
+			return fail("error saving registry", err)
+		}
+
+		if len(mismatches) > 0 {
+			return fail("error verifying fingerprints", fmt.Errorf("%d outputs differ", len(mismatches)))
+		}
+	}
+
+	// site references
+	if *siteName != "" && *rewrite && !*dryRun {
+		outputs := make(map[string]string)
+		for _, r := range rep.Images {
+			if _, ok := outputs[r.Source]; !ok && r.Error == "" && !r.Rejected {
+				outputs[r.Source] = r.Output
+			}
+		}
+
+		n, err := ssg.rewrite(*dir, outputs)
+		if err != nil {
+			return fail("error rewriting references", err)
+		}
+		logger.Info("Rewrote references", "count", n)
+	}
+
+	// document references
+	if len(docs) > 0 && *rewrite && !*dryRun {
+		results := make(map[string]letterbox.Result)
+		for _, r := range rep.Images {
+			if _, ok := results[r.Source]; !ok && r.Error == "" && !r.Rejected {
+				results[r.Source] = r
+			}
+		}
+
+		n, err := rewriteDocuments(docs, *dir, results)
+		if err != nil {
+			return fail("error rewriting document references", err)
+		}
+		logger.Info("Rewrote document references", "count", n)
+	}
+
+	// archive output, with outputs named by their entries
+	written := outputs(rep.Images)
+	if archivePath != "" && !*dryRun && canceled == nil {
+		if err := writeArchive(archivePath, *dir); err != nil {
+			return fail("error writing archive", err)
+		}
+		logger.Info("Wrote archive", "path", archivePath)
+
+		for i, r := range rep.Images {
+			if r.Output != "" {
+				rep.Images[i].Output = archived(r.Output, map[string]string{*dir: archivePath})
+			}
+		}
+	}
+
+	// stats
+	mem := readMemoryStats()
+	rep.Stats = &stats{
+		Duration: time.Since(start),
+		Memory:   mem,
+	}
+
+	// timings
+	if *timings {
+		if err := writeTimings(os.Stderr, rep.Images, rep.Stats.Duration); err != nil {
+			return fail("error writing stats", err)
+		}
+	}
+
+	// report
+	if *reportPath != "" {
+		if err := writeReport(*reportPath, rep); err != nil {
+			return fail("error writing report", err)
+		}
+	}
+
+	// unsupported
+	logUnsupported(rep.Images)
+
+	// sources done
+	if !done.keep() && !*dryRun {
+		disposed, failed := done.dispose(rep.Images, remaining, extracted)
+		logger.Info("Disposed of sources", "action", done.action, "count", disposed, "failed", failed)
+	}
+
+	// interrupted
+	if canceled != nil {
+		processed, skipped, failed := summarize(rep.Images)
+		logger.Warn("Interrupted",
+			"processed", processed,
+			"skipped", skipped,
+			"failed", failed,
+			"remaining", len(canceled.Remaining))
+		return 130
+	}
+
 	if err != nil {
-		log.Fatalf("error processing: %s", err)
+		return fail("error processing", err)
+	}
+
+	// review
+	if *reviewPath != "" && !*dryRun {
+		logger.Info("Writing review", "path", *reviewPath)
+		if err := writeReview(*reviewPath, written, *reviewDuration); err != nil {
+			return fail("error writing review", err)
+		}
 	}
 
-	log.Printf("Processed in %s\n", time.Since(start).Round(time.Second))
+	if n := warned(rep.Images); n > 0 {
+		logger.Warn("Processed images with warnings", "count", n)
+	}
+
+	logger.Info("Processed images",
+		"duration", time.Since(start).Round(time.Second),
+		"peak_rss", formatBytes(mem.PeakRSS),
+		"total_alloc", formatBytes(mem.TotalAlloc),
+		"num_gc", mem.NumGC,
+		"gc_pause", mem.GCPause.Round(time.Millisecond))
+
+	return 0
 }
 
-// listImages returns the images in the given directory.
-func listImages(dir string) (images []string, err error) {
+// parseSize returns the width and height of a size such as "1920x1080",
+// or zeros when empty.
+func parseSize(s string) (width, height int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+
+	parts := strings.Split(s, "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid size %q, must be WIDTHxHEIGHT", s)
+	}
+
+	width, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid width: %w", err)
+	}
+
+	height, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid height: %w", err)
+	}
+
+	return
+}
+
+// readImage reads and decodes the image at path.
+func readImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	return img, nil
+}
+
+// parsePadding returns the padding percentage of a bare number such as "6",
+// or otherwise the margin such as "5%", "40px" or "10px 20px".
+func parsePadding(s string) (percent int, margin letterbox.Margin, err error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, margin, nil
+	}
+
+	margin, err = letterbox.ParseMargin(s)
+	return
+}
+
+// parsePoint returns the point of pixel coordinates such as "0,10", or zero when empty.
+func parsePoint(s string) (p image.Point, err error) {
+	if s == "" {
+		return
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return p, fmt.Errorf("invalid point %q, must be X,Y", s)
+	}
+
+	p.X, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return p, fmt.Errorf("invalid x: %w", err)
+	}
+
+	p.Y, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return p, fmt.Errorf("invalid y: %w", err)
+	}
+
+	return
+}
+
+// parseOffset returns the x and y of an offset such as "0,120" or "0,10%",
+// or zeros when empty.
+func parseOffset(s string) (x, y letterbox.Length, err error) {
+	if s == "" {
+		return
+	}
+
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return x, y, fmt.Errorf("invalid offset %q, must be X,Y", s)
+	}
+
+	x, err = letterbox.ParseLength(parts[0])
+	if err != nil {
+		return
+	}
+
+	y, err = letterbox.ParseLength(parts[1])
+	return
+}
+
+// skipPolicy returns the skip policy by name. The hash policy defaults
+// to storing its state in the output directory.
+func skipPolicy(name, path, dir string) (letterbox.SkipPolicy, error) {
+	switch name {
+	case "mtime":
+		return letterbox.SkipUnmodified, nil
+	case "hash":
+		if path == "" {
+			path = filepath.Join(dir, ".letterbox-cache.json")
+		}
+		h, err := letterbox.NewHashSkip(path)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	case "manifest":
+		if path == "" {
+			return nil, fmt.Errorf("-skip-file is required for the manifest policy")
+		}
+		return letterbox.NewManifestSkip(path)
+	case "always":
+		return letterbox.SkipAlways, nil
+	case "never":
+		return letterbox.SkipNever, nil
+	default:
+		return nil, fmt.Errorf("unsupported skip policy %q", name)
+	}
+}
+
+// imageLoader returns the loader of the backend by name.
+func imageLoader(name string) (letterbox.Loader, error) {
+	switch name {
+	case "go":
+		return nil, nil
+	case "vips":
+		v, err := letterbox.NewVips()
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unsupported backend %q", name)
+	}
+}
+
+// metadataBackend returns the metadata backend by name.
+func metadataBackend(name string) (letterbox.MetadataBackend, error) {
+	switch name {
+	case "go":
+		return letterbox.GoMetadata{}, nil
+	case "exiftool":
+		e, err := letterbox.NewExifTool()
+		if err != nil {
+			return nil, err
+		}
+		return e, nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported metadata backend %q", name)
+	}
+}
+
+// warned returns the number of results with warnings.
+func warned(results []letterbox.Result) (n int) {
+	for _, r := range results {
+		if len(r.Warnings) > 0 {
+			n++
+		}
+	}
+	return
+}
+
+// logUnsupported logs the sources of unsupported formats and variants, such
+// as 12-bit jpeg or JPEG 2000, grouped with the converter to run first.
+func logUnsupported(results []letterbox.Result) {
+	var variants []letterbox.UnsupportedError
+	sources := make(map[letterbox.UnsupportedError][]string)
+
+	for _, r := range results {
+		if r.Unsupported == nil {
+			continue
+		}
+
+		u := *r.Unsupported
+		if _, ok := sources[u]; !ok {
+			variants = append(variants, u)
+		}
+
+		// once for all targets
+		if !slices.Contains(sources[u], r.Source) {
+			sources[u] = append(sources[u], r.Source)
+		}
+	}
+
+	for _, u := range variants {
+		logger.Warn("Unsupported images",
+			"format", u.Format,
+			"variant", u.Variant,
+			"converter", u.Converter,
+			"count", len(sources[u]),
+			"paths", strings.Join(sources[u], " "))
+	}
+}
+
+// summarize returns the number of results processed, skipped and failed.
+func summarize(results []letterbox.Result) (processed, skipped, failed int) {
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			failed++
+		case r.Skipped:
+			skipped++
+		default:
+			processed++
+		}
+	}
+	return
+}
+
+// outputs returns the sorted output paths of successful results.
+func outputs(results []letterbox.Result) (paths []string) {
+	for _, r := range results {
+		if r.Error == "" {
+			paths = append(paths, r.Output)
+		}
+	}
+	sort.Strings(paths)
+	return
+}
+
+// readLines returns the non-empty lines of the file at path.
+func readLines(path string) (lines []string, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return
+}
+
+// writeLines writes the lines to the file at path.
+func writeLines(path string, lines []string) error {
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// listImages returns the images in the given directory. When include patterns
+// are given they replace the default of jpeg images, exclude patterns are
+// applied last.
+func listImages(dir string, include, exclude []string) (images []string, err error) {
 	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, f := range files {
-		ext := strings.ToLower(filepath.Ext(f.Name()))
-		if ext == ".jpg" || ext == ".jpeg" {
-			images = append(images, filepath.Join(dir, f.Name()))
+		if f.IsDir() {
+			continue
 		}
+
+		name := f.Name()
+
+		if len(include) > 0 {
+			if !matches(name, include) {
+				continue
+			}
+		} else {
+			ext := strings.ToLower(filepath.Ext(name))
+			if ext != ".jpg" && ext != ".jpeg" {
+				continue
+			}
+		}
+
+		if matches(name, exclude) {
+			continue
+		}
+
+		images = append(images, filepath.Join(dir, name))
 	}
 
 	return
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memoryStats is the memory usage of a run.
+type memoryStats struct {
+	PeakRSS    uint64        `json:"peak_rss"`
+	TotalAlloc uint64        `json:"total_alloc"`
+	Mallocs    uint64        `json:"mallocs"`
+	NumGC      uint32        `json:"num_gc"`
+	GCPause    time.Duration `json:"gc_pause"`
+}
+
+// readMemoryStats returns the memory usage of the process so far.
+func readMemoryStats() memoryStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return memoryStats{
+		PeakRSS:    peakRSS(),
+		TotalAlloc: m.TotalAlloc,
+		Mallocs:    m.Mallocs,
+		NumGC:      m.NumGC,
+		GCPause:    time.Duration(m.PauseTotalNs),
+	}
+}
+
+// formatBytes returns a human-friendly size such as "12.5MB".
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
+// parseBytes returns the bytes of a size such as "512MB" or "2GB", or 0 when empty.
+func parseBytes(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		n      float64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	unit := 1.0
+	v := strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			v = strings.TrimSuffix(v, u.suffix)
+			unit = u.n
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(n * unit), nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// metrics compares the images of matching filenames in two directories,
+// printing their PSNR and SSIM and a summary.
+func metrics(args []string) error {
+	cmd := flag.NewFlagSet("metrics", flag.ExitOnError)
+	a := cmd.String("a", "", "Directory of reference images")
+	b := cmd.String("b", "", "Directory of images compared with the reference")
+	cmd.Parse(args)
+
+	if *a == "" || *b == "" {
+		return fmt.Errorf("-a and -b are required")
+	}
+
+	files, err := ioutil.ReadDir(*a)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPSNR\tSSIM")
+
+	var n, finite int
+	var sumPSNR, sumSSIM float64
+	minPSNR, minSSIM := math.Inf(1), math.Inf(1)
+
+	for _, f := range files {
+		if f.IsDir() || !isImage(f.Name()) {
+			continue
+		}
+
+		other := filepath.Join(*b, f.Name())
+		if _, err := os.Stat(other); err != nil {
+			logger.Warn("Missing comparison image", "path", other)
+			continue
+		}
+
+		x, err := readImage(filepath.Join(*a, f.Name()))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", f.Name(), err)
+		}
+
+		y, err := readImage(other)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", other, err)
+		}
+
+		if x.Bounds().Size() != y.Bounds().Size() {
+			logger.Warn("Dimensions differ", "name", f.Name(), "a", x.Bounds().Size(), "b", y.Bounds().Size())
+			continue
+		}
+
+		p := psnr(x, y)
+		s := ssim(x, y)
+		fmt.Fprintf(w, "%s\t%s\t%.4f\n", f.Name(), formatPSNR(p), s)
+
+		n++
+		sumSSIM += s
+		minSSIM = math.Min(minSSIM, s)
+		minPSNR = math.Min(minPSNR, p)
+		if !math.IsInf(p, 1) {
+			sumPSNR += p
+			finite++
+		}
+	}
+
+	if n == 0 {
+		w.Flush()
+		return fmt.Errorf("no matching images")
+	}
+
+	// identical images are excluded from the mean PSNR
+	meanPSNR := math.Inf(1)
+	if finite > 0 {
+		meanPSNR = sumPSNR / float64(finite)
+	}
+
+	fmt.Fprintf(w, "\nMEAN\t%s\t%.4f\n", formatPSNR(meanPSNR), sumSSIM/float64(n))
+	fmt.Fprintf(w, "MIN\t%s\t%.4f\n", formatPSNR(minPSNR), minSSIM)
+	return w.Flush()
+}
+
+// formatPSNR returns the PSNR in decibels, or "inf" for identical images.
+func formatPSNR(v float64) string {
+	if math.IsInf(v, 1) {
+		return "inf"
+	}
+	return fmt.Sprintf("%.2fdB", v)
+}
+
+// psnr returns the peak signal-to-noise ratio of the RGB channels of two
+// images of the same dimensions, which is infinite when they're identical.
+func psnr(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+
+	var sum float64
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			for _, d := range []float64{
+				float64(r1>>8) - float64(r2>>8),
+				float64(g1>>8) - float64(g2>>8),
+				float64(b1>>8) - float64(b2>>8),
+			} {
+				sum += d * d
+			}
+		}
+	}
+
+	// empty images are identical
+	if ab.Empty() {
+		return math.Inf(1)
+	}
+
+	mse := sum / float64(ab.Dx()*ab.Dy()*3)
+	if mse == 0 {
+		return math.Inf(1)
+	}
+
+	return 10 * math.Log10(255*255/mse)
+}
+
+// ssim returns the mean structural similarity of the luma of two images of
+// the same dimensions, over 8x8 windows with a stride of 4.
+func ssim(a, b image.Image) float64 {
+	const (
+		size   = 8
+		stride = 4
+		c1     = (0.01 * 255) * (0.01 * 255)
+		c2     = (0.03 * 255) * (0.03 * 255)
+	)
+
+	la, lb := luma(a), luma(b)
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+
+	// empty images are identical
+	if w == 0 || h == 0 {
+		return 1
+	}
+
+	// images smaller than a window are compared as a whole
+	ws, hs := min(size, w), min(size, h)
+
+	var sum float64
+	var n int
+	for y := 0; y+hs <= h; y += stride {
+		for x := 0; x+ws <= w; x += stride {
+			var ma, mb, va, vb, cov float64
+			for j := y; j < y+hs; j++ {
+				for i := x; i < x+ws; i++ {
+					ma += la[j*w+i]
+					mb += lb[j*w+i]
+				}
+			}
+
+			count := float64(ws * hs)
+			ma /= count
+			mb /= count
+
+			for j := y; j < y+hs; j++ {
+				for i := x; i < x+ws; i++ {
+					da := la[j*w+i] - ma
+					db := lb[j*w+i] - mb
+					va += da * da
+					vb += db * db
+					cov += da * db
+				}
+			}
+
+			// a single pixel has no variance
+			if count > 1 {
+				va /= count - 1
+				vb /= count - 1
+				cov /= count - 1
+			}
+
+			sum += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			n++
+		}
+	}
+
+	return sum / float64(n)
+}
+
+// luma returns the Rec. 601 luma of each pixel of the image.
+func luma(img image.Image) []float64 {
+	b := img.Bounds()
+	v := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			v = append(v, (0.299*float64(r)+0.587*float64(g)+0.114*float64(b))/257)
+		}
+	}
+	return v
+}
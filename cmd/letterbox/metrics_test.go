@@ -0,0 +1,51 @@
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestMetricsSmall(t *testing.T) {
+	gray := func(w, h int, v uint8) image.Image {
+		img := image.NewGray(image.Rect(0, 0, w, h))
+		for i := range img.Pix {
+			img.Pix[i] = v
+		}
+		return img
+	}
+
+	cases := []struct {
+		name      string
+		a, b      image.Image
+		identical bool
+	}{
+		{"empty", gray(0, 0, 0), gray(0, 0, 0), true},
+		{"1x1", gray(1, 1, 100), gray(1, 1, 100), true},
+		{"1x1 differing", gray(1, 1, 100), gray(1, 1, 150), false},
+		{"1x2 differing", gray(1, 2, 100), gray(1, 2, 150), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := ssim(c.a, c.b)
+			if math.IsNaN(s) || math.IsInf(s, 0) || s < -1 || s > 1 {
+				t.Fatalf("ssim = %v", s)
+			}
+
+			if c.identical != (s == 1) {
+				t.Errorf("ssim = %v, identical %v", s, c.identical)
+			}
+
+			p := psnr(c.a, c.b)
+			if math.IsNaN(p) {
+				t.Fatalf("psnr = %v", p)
+			}
+
+			if c.identical != math.IsInf(p, 1) {
+				t.Errorf("psnr = %v, identical %v", p, c.identical)
+			}
+		})
+	}
+
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tj/letterbox"
+)
+
+// writeNames writes the mappings as a table, with their problems, returning
+// the number of mappings with problems.
+func writeNames(w io.Writer, mappings []letterbox.Mapping) (int, error) {
+	var n int
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tOUTPUT\tPROBLEMS")
+	for _, m := range mappings {
+		problems := "-"
+		if len(m.Problems) > 0 {
+			problems = strings.Join(m.Problems, "; ")
+			n++
+		}
+
+		output := m.Output
+		if output == "" {
+			output = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", m.Source, output, problems)
+	}
+
+	return n, tw.Flush()
+}
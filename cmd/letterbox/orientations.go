@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/tj/letterbox"
+)
+
+// orientationNames are the transforms which display each EXIF orientation upright.
+var orientationNames = map[int]string{
+	1: "normal",
+	2: "flip horizontal",
+	3: "rotate 180",
+	4: "flip vertical",
+	5: "transpose",
+	6: "rotate 90 cw",
+	7: "transverse",
+	8: "rotate 90 ccw",
+}
+
+// orientations reports how many images carry each EXIF orientation,
+// optionally rotating them upright and resetting their orientation.
+func orientations(args []string) error {
+	cmd := flag.NewFlagSet("orientations", flag.ExitOnError)
+	fix := cmd.Bool("fix", false, "Rotate and flip the pixels of images which are not upright and reset their orientation, without letterboxing")
+	quality := cmd.Int("quality", 90, "Jpeg quality of fixed images")
+	cmd.Parse(args)
+
+	images := cmd.Args()
+	if len(images) == 0 {
+		var err error
+		images, err = listImages(".", nil, nil)
+		if err != nil {
+			return fmt.Errorf("listing images: %w", err)
+		}
+	}
+
+	counts := make(map[int]int)
+	var fixed, failed int
+
+	for _, path := range images {
+		o, err := letterbox.Orientation(path)
+		if err != nil {
+			logger.Error("Error reading orientation", "path", path, "error", err)
+			failed++
+			continue
+		}
+
+		counts[o]++
+
+		if !*fix || o == 1 {
+			continue
+		}
+
+		if err := letterbox.FixOrientation(path, *quality); err != nil {
+			logger.Error("Error fixing orientation", "path", path, "error", err)
+			failed++
+			continue
+		}
+
+		logger.Info("Fixed", "path", path, "orientation", o)
+		fixed++
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ORIENTATION\tNAME\tCOUNT")
+	for o := 1; o <= 8; o++ {
+		if counts[o] > 0 {
+			fmt.Fprintf(w, "%d\t%s\t%d\n", o, orientationNames[o], counts[o])
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if *fix {
+		logger.Info("Fixed orientations", "fixed", fixed)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed", failed, len(images))
+	}
+
+	return nil
+}
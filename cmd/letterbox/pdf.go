@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// isPDF returns true if path has a pdf extension.
+func isPDF(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".pdf")
+}
+
+// pdfPages renders the page of each pdf among images into dir as a png at
+// the given dpi, mirroring its path relative to the working directory. It
+// returns the images with pdfs replaced by their page, and the pdfs keyed by
+// page. This requires pdftoppm, from poppler.
+func pdfPages(images []string, dir string, page, dpi int) ([]string, map[string]string, error) {
+	var list []string
+	pdfs := make(map[string]string)
+
+	for _, path := range images {
+		if !isPDF(path) {
+			list = append(list, path)
+			continue
+		}
+
+		dst := renderPath(dir, path, ".png")
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, nil, err
+		}
+
+		if err := renderPage(path, dst, page, dpi); err != nil {
+			return nil, nil, fmt.Errorf("rendering page %d of %s: %w", page, path, err)
+		}
+
+		// modification time of the pdf, for skip policies
+		if err := copyModTime(path, dst); err != nil {
+			return nil, nil, err
+		}
+
+		logger.Debug("Rendered page", "path", path, "page", page, "dpi", dpi)
+		pdfs[dst] = path
+		list = append(list, dst)
+	}
+
+	return list, pdfs, nil
+}
+
+// renderPage writes the page of the pdf at path to dst as a png at the given
+// dpi. Pages are numbered from 1.
+func renderPage(path, dst string, page, dpi int) error {
+	bin, err := exec.LookPath("pdftoppm")
+	if err != nil {
+		return fmt.Errorf("pdftoppm is required: %w", err)
+	}
+
+	// pdftoppm appends the extension
+	prefix := strings.TrimSuffix(dst, ".png")
+
+	out, err := exec.Command(bin,
+		"-png",
+		"-singlefile",
+		"-r", strconv.Itoa(dpi),
+		"-f", strconv.Itoa(page),
+		"-l", strconv.Itoa(page),
+		path, prefix).CombinedOutput()
+
+	if err != nil {
+		return fmt.Errorf("running pdftoppm: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+
+	// pages past the end write nothing
+	if _, err := os.Stat(dst); err != nil {
+		return fmt.Errorf("no page %d", page)
+	}
+
+	return nil
+}
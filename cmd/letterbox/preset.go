@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// preset is a named bundle of output settings.
+type preset struct {
+	Aspect  string `yaml:"aspect" json:"aspect"`
+	Size    string `yaml:"size" json:"size"`
+	Format  string `yaml:"format" json:"format"`
+	Quality int    `yaml:"quality" json:"quality"`
+
+	// Profile is the name of the encoder profile of the config to use.
+	Profile string `yaml:"profile" json:"profile"`
+}
+
+// presets are the built-in presets for social platforms.
+var presets = map[string]preset{
+	"instagram-feed": {
+		Aspect:  "4:5",
+		Size:    "1080x1350",
+		Format:  "jpeg",
+		Quality: 90,
+	},
+	"instagram-square": {
+		Aspect:  "1:1",
+		Size:    "1080x1080",
+		Format:  "jpeg",
+		Quality: 90,
+	},
+	"instagram-story": {
+		Aspect:  "9:16",
+		Size:    "1080x1920",
+		Format:  "jpeg",
+		Quality: 90,
+	},
+	"youtube-thumbnail": {
+		Aspect:  "16:9",
+		Size:    "1280x720",
+		Format:  "jpeg",
+		Quality: 90,
+	},
+	"twitter-card": {
+		Aspect:  "1.91:1",
+		Size:    "1200x628",
+		Format:  "jpeg",
+		Quality: 85,
+	},
+	"facebook-link": {
+		Aspect:  "1.91:1",
+		Size:    "1200x630",
+		Format:  "jpeg",
+		Quality: 85,
+	},
+}
+
+// findPreset returns the preset by name, custom presets take precedence.
+func findPreset(name string, custom map[string]preset) (preset, error) {
+	if p, ok := custom[name]; ok {
+		return p, nil
+	}
+
+	if p, ok := presets[name]; ok {
+		return p, nil
+	}
+
+	return preset{}, fmt.Errorf("unknown preset %q, must be one of: %s", name, strings.Join(presetNames(custom), ", "))
+}
+
+// presetNames returns the sorted names of the built-in and custom presets.
+func presetNames(custom map[string]preset) (names []string) {
+	for name := range presets {
+		names = append(names, name)
+	}
+
+	for name := range custom {
+		if _, ok := presets[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return
+}
+
+// values returns the preset values keyed by flag name.
+func (p preset) values() map[string]string {
+	values := map[string]string{
+		"aspect": p.Aspect,
+		"size":   p.Size,
+		"format": p.Format,
+	}
+
+	if p.Quality != 0 {
+		values["quality"] = strconv.Itoa(p.Quality)
+	}
+
+	return values
+}
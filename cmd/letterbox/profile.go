@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profile is a named bundle of encoder settings per output format.
+type profile struct {
+	JPEG struct {
+		Quality int `yaml:"quality"`
+	} `yaml:"jpeg"`
+
+	PNG struct {
+		Compression string `yaml:"compression"`
+	} `yaml:"png"`
+}
+
+// encoderSettings are the supported settings of each format, and the reason
+// for those which are not.
+var encoderSettings = map[string]map[string]string{
+	"jpeg": {
+		"quality":     "",
+		"subsampling": "the jpeg encoder always uses 4:2:0 chroma subsampling",
+		"progressive": "the jpeg encoder only writes baseline jpegs",
+	},
+	"png": {
+		"compression": "",
+		"filter":      "the png encoder chooses filters itself",
+	},
+}
+
+// UnmarshalYAML implementation, rejecting settings the encoders do not provide.
+func (p *profile) UnmarshalYAML(n *yaml.Node) error {
+	var m map[string]map[string]yaml.Node
+	if err := n.Decode(&m); err != nil {
+		return err
+	}
+
+	for format, settings := range m {
+		supported, ok := encoderSettings[format]
+		if !ok {
+			return fmt.Errorf("unsupported profile format %q, must be jpeg or png", format)
+		}
+
+		for name := range settings {
+			reason, ok := supported[name]
+			if !ok {
+				return fmt.Errorf("unknown %s setting %q", format, name)
+			}
+
+			if reason != "" {
+				return fmt.Errorf("unsupported %s setting %q, %s", format, name, reason)
+			}
+		}
+	}
+
+	type plain profile
+	return n.Decode((*plain)(p))
+}
+
+// values returns the profile values keyed by flag name.
+func (p profile) values() map[string]string {
+	values := map[string]string{
+		"png-compression": p.PNG.Compression,
+	}
+
+	if p.JPEG.Quality != 0 {
+		values["quality"] = strconv.Itoa(p.JPEG.Quality)
+	}
+
+	return values
+}
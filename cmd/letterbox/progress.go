@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tj/letterbox"
+)
+
+// progress is a terminal progress bar.
+type progress struct {
+	w        io.Writer
+	total    int
+	done     int
+	bytes    int64
+	start    time.Time
+	rendered time.Time
+}
+
+// newProgress returns a progress bar for total images.
+func newProgress(w io.Writer, total int) *progress {
+	return &progress{
+		w:     w,
+		total: total,
+		start: time.Now(),
+	}
+}
+
+// Add a result to the progress, growing the total when more results arrive
+// than expected.
+func (p *progress) Add(r letterbox.Result) {
+	p.done++
+	p.total = max(p.total, p.done)
+	p.bytes += r.Bytes
+
+	// throttle rendering
+	if time.Since(p.rendered) < 100*time.Millisecond && p.done < p.total {
+		return
+	}
+
+	p.render()
+}
+
+// Stop the progress bar.
+func (p *progress) Stop() {
+	p.render()
+	fmt.Fprintf(p.w, "\n")
+}
+
+// render the progress bar.
+func (p *progress) render() {
+	const width = 30
+
+	p.rendered = time.Now()
+	elapsed := time.Since(p.start)
+
+	// bar
+	pct := 1.0
+	if p.total > 0 {
+		pct = min(1, float64(p.done)/float64(p.total))
+	}
+	n := int(pct * width)
+	bar := strings.Repeat("=", n) + strings.Repeat(" ", width-n)
+
+	// throughput
+	secs := elapsed.Seconds()
+	var ips, mbps float64
+	if secs > 0 {
+		ips = float64(p.done) / secs
+		mbps = float64(p.bytes) / 1e6 / secs
+	}
+
+	// eta
+	eta := "-"
+	if ips > 0 {
+		remaining := time.Duration(float64(p.total-p.done) / ips * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	}
+
+	fmt.Fprintf(p.w, "\r\033[K[%s] %d/%d %3.0f%% %.1f img/s %.1f MB/s ETA %s",
+		bar, p.done, p.total, pct*100, ips, mbps, eta)
+}
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
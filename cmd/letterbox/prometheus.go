@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the frame latency histogram in seconds.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// streamMetrics are the metrics of stream mode, served in the Prometheus
+// text exposition format.
+type streamMetrics struct {
+	mu        sync.Mutex
+	processed int
+	failed    int
+	buckets   []int
+	sum       float64
+	last      time.Time
+	clients   func() int
+}
+
+// newStreamMetrics returns metrics reporting the clients of b.
+func newStreamMetrics(b *broadcast) *streamMetrics {
+	return &streamMetrics{
+		buckets: make([]int, len(latencyBuckets)),
+		clients: b.Clients,
+	}
+}
+
+// Observe a processed frame and its latency.
+func (m *streamMetrics) Observe(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.processed++
+	m.sum += d.Seconds()
+	m.last = time.Now()
+	for i, le := range latencyBuckets {
+		if d.Seconds() <= le {
+			m.buckets[i]++
+		}
+	}
+}
+
+// Fail records a frame which failed to process.
+func (m *streamMetrics) Fail() {
+	m.mu.Lock()
+	m.failed++
+	m.mu.Unlock()
+}
+
+// ServeHTTP implementation.
+func (m *streamMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP letterbox_frames_processed_total Frames letterboxed.")
+	fmt.Fprintln(w, "# TYPE letterbox_frames_processed_total counter")
+	fmt.Fprintf(w, "letterbox_frames_processed_total %d\n", m.processed)
+
+	fmt.Fprintln(w, "# HELP letterbox_frames_failed_total Frames which failed to decode.")
+	fmt.Fprintln(w, "# TYPE letterbox_frames_failed_total counter")
+	fmt.Fprintf(w, "letterbox_frames_failed_total %d\n", m.failed)
+
+	fmt.Fprintln(w, "# HELP letterbox_frame_duration_seconds Latency of decoding, letterboxing and encoding frames.")
+	fmt.Fprintln(w, "# TYPE letterbox_frame_duration_seconds histogram")
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(w, "letterbox_frame_duration_seconds_bucket{le=\"%g\"} %d\n", le, m.buckets[i])
+	}
+	fmt.Fprintf(w, "letterbox_frame_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.processed)
+	fmt.Fprintf(w, "letterbox_frame_duration_seconds_sum %g\n", m.sum)
+	fmt.Fprintf(w, "letterbox_frame_duration_seconds_count %d\n", m.processed)
+
+	// stuck streams are detected by the age of the last frame, as frames
+	// are processed as they arrive rather than queued
+	var last float64
+	if !m.last.IsZero() {
+		last = float64(m.last.UnixNano()) / 1e9
+	}
+	fmt.Fprintln(w, "# HELP letterbox_last_frame_timestamp_seconds Unix time of the last processed frame.")
+	fmt.Fprintln(w, "# TYPE letterbox_last_frame_timestamp_seconds gauge")
+	fmt.Fprintf(w, "letterbox_last_frame_timestamp_seconds %g\n", last)
+
+	fmt.Fprintln(w, "# HELP letterbox_clients Connected stream clients.")
+	fmt.Fprintln(w, "# TYPE letterbox_clients gauge")
+	fmt.Fprintf(w, "letterbox_clients %d\n", m.clients())
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/tj/letterbox"
+	"golang.org/x/image/tiff"
+)
+
+// isRaw returns true if path has a camera RAW extension.
+func isRaw(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".cr2", ".nef", ".arw", ".dng":
+		return true
+	default:
+		return false
+	}
+}
+
+// raws decodes each camera RAW among images into dir, mirroring its path
+// relative to the working directory, by extracting its embedded jpeg preview,
+// or developing it with dcraw. It returns the images with RAWs replaced by
+// their decoded images, and the RAWs keyed by decoded image.
+func raws(images []string, dir, decoder string, quality int) ([]string, map[string]string, error) {
+	var list []string
+	decoded := make(map[string]string)
+
+	for _, path := range images {
+		if !isRaw(path) {
+			list = append(list, path)
+			continue
+		}
+
+		ext := ".jpg"
+		if decoder == "dcraw" {
+			ext = ".png"
+		}
+
+		dst := renderPath(dir, path, ext)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, nil, err
+		}
+
+		var err error
+		switch decoder {
+		case "preview":
+			err = letterbox.ExtractPreview(path, dst, quality)
+		case "dcraw":
+			err = develop(path, dst)
+		default:
+			err = fmt.Errorf("unsupported raw decoder %q, must be preview or dcraw", decoder)
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("decoding %s: %w", path, err)
+		}
+
+		// modification time of the raw, for skip policies
+		if err := copyModTime(path, dst); err != nil {
+			return nil, nil, err
+		}
+
+		logger.Debug("Decoded raw", "path", path, "decoder", decoder)
+		decoded[dst] = path
+		list = append(list, dst)
+	}
+
+	return list, decoded, nil
+}
+
+// develop writes the camera RAW at path to dst as a png, developed with the
+// camera white balance by dcraw, or LibRaw's dcraw_emu.
+func develop(path, dst string) error {
+	var args []string
+	bin, err := exec.LookPath("dcraw")
+	if err == nil {
+		args = []string{"-c", "-w", "-T", path}
+	} else if bin, err = exec.LookPath("dcraw_emu"); err == nil {
+		args = []string{"-w", "-T", "-Z", "-", path}
+	} else {
+		return fmt.Errorf("dcraw or dcraw_emu is required: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(bin, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("running %s: %s: %w", filepath.Base(bin), strings.TrimSpace(stderr.String()), err)
+	}
+
+	// decode
+	img, err := tiff.Decode(bytes.NewReader(out))
+	if err != nil {
+		return fmt.Errorf("decoding %s output: %w", filepath.Base(bin), err)
+	}
+
+	// encode
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	// intermediate, so favour speed
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
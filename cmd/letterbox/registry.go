@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/tj/letterbox"
+)
+
+// registry records the fingerprints of outputs, so that runs on different
+// machines can be verified identical.
+type registry struct {
+	// Go is the version of Go the fingerprints were recorded with, as its
+	// encoders may change between releases.
+	Go string `json:"go"`
+
+	// Outputs are the sha256 of outputs keyed by path relative to the output directory.
+	Outputs map[string]string `json:"outputs"`
+}
+
+// mismatch is an output which differs from its recorded fingerprint.
+type mismatch struct {
+	Output   string
+	Recorded string
+	Actual   string
+}
+
+// loadRegistry loads the registry at path, or an empty one when missing.
+func loadRegistry(path string) (*registry, error) {
+	r := &registry{Go: runtime.Version(), Outputs: make(map[string]string)}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	if r.Outputs == nil {
+		r.Outputs = make(map[string]string)
+	}
+
+	return r, nil
+}
+
+// Verify records the fingerprints of new outputs, and returns those which
+// differ from their recorded fingerprints, keeping the recorded ones.
+func (r *registry) Verify(dir string, results []letterbox.Result) ([]mismatch, error) {
+	var mismatches []mismatch
+
+	for _, res := range results {
+		if res.Error != "" || res.Rejected || res.Output == "" {
+			continue
+		}
+
+		name, err := filepath.Rel(dir, res.Output)
+		if err != nil {
+			return nil, err
+		}
+		name = filepath.ToSlash(name)
+
+		sum, err := fingerprint(res.Output)
+		if err != nil {
+			return nil, fmt.Errorf("fingerprinting %s: %w", res.Output, err)
+		}
+
+		prev, ok := r.Outputs[name]
+		if ok && prev != sum {
+			mismatches = append(mismatches, mismatch{Output: res.Output, Recorded: prev, Actual: sum})
+			continue
+		}
+
+		r.Outputs[name] = sum
+	}
+
+	return mismatches, nil
+}
+
+// Save the registry to path.
+func (r *registry) Save(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// fingerprint returns the sha256 of the file at path.
+func fingerprint(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
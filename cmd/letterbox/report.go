@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/tj/letterbox"
+)
+
+// report is the JSON report of a run.
+type report struct {
+	Images []letterbox.Result `json:"images"`
+	Stats  *stats             `json:"stats,omitempty"`
+}
+
+// stats are the statistics of a run.
+type stats struct {
+	Duration time.Duration `json:"duration"`
+	Memory   memoryStats   `json:"memory"`
+}
+
+// writeReport writes the report as JSON to the given path, or stdout when "-".
+func writeReport(path string, r report) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if path == "-" {
+		_, err = os.Stdout.Write(append(b, '\n'))
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
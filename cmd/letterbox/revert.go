@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// revert undoes a previous run from its report, restoring the outputs it
+// overwrote from their backups and removing those it created.
+func revert(args []string) error {
+	cmd := flag.NewFlagSet("revert", flag.ExitOnError)
+	manifest := cmd.String("manifest", "", "JSON report of the run to revert, written with -report")
+	dryRun := cmd.Bool("dry-run", false, "Output what would be restored and removed without changing anything")
+	cmd.Parse(args)
+
+	if *manifest == "" {
+		return fmt.Errorf("-manifest is required")
+	}
+
+	b, err := ioutil.ReadFile(*manifest)
+	if err != nil {
+		return err
+	}
+
+	var r report
+	if err := json.Unmarshal(b, &r); err != nil {
+		return fmt.Errorf("parsing %s: %w", *manifest, err)
+	}
+
+	var restored, removed, failed int
+
+	for _, res := range r.Images {
+		if res.Skipped || res.Output == "" {
+			continue
+		}
+
+		// restore overwritten outputs
+		if res.Backup != "" {
+			if *dryRun {
+				logger.Info("Would restore", "output", res.Output, "backup", res.Backup)
+				restored++
+				continue
+			}
+
+			if err := os.Rename(res.Backup, res.Output); err != nil {
+				logger.Error("Error restoring", "output", res.Output, "error", err)
+				failed++
+				continue
+			}
+
+			logger.Info("Restored", "output", res.Output, "backup", res.Backup)
+			restored++
+			continue
+		}
+
+		// overwritten outputs which failed before being backed up are intact
+		if res.Overwritten {
+			if res.Error == "" {
+				logger.Warn("Not backed up, run with -backup-dir to revert overwritten outputs", "output", res.Output)
+				failed++
+			}
+			continue
+		}
+
+		// remove created outputs
+		if _, err := os.Stat(res.Output); err != nil {
+			continue
+		}
+
+		if *dryRun {
+			logger.Info("Would remove", "output", res.Output)
+			removed++
+			continue
+		}
+
+		if err := os.Remove(res.Output); err != nil {
+			logger.Error("Error removing", "output", res.Output, "error", err)
+			failed++
+			continue
+		}
+
+		logger.Info("Removed", "output", res.Output)
+		removed++
+	}
+
+	logger.Info("Reverted", "restored", restored, "removed", removed, "failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d outputs could not be reverted", failed)
+	}
+
+	return nil
+}
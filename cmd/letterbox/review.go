@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// writeReview writes an mp4 to path which shows each image for the given
+// duration, labelled with its filename. This requires ffmpeg.
+func writeReview(path string, images []string, d time.Duration) error {
+	bin, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg is required: %w", err)
+	}
+
+	if len(images) == 0 {
+		return fmt.Errorf("no images to review")
+	}
+
+	// concat list
+	f, err := ioutil.TempFile("", "letterbox-review-*.txt")
+	if err != nil {
+		return fmt.Errorf("creating list: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(reviewList(images, d))
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("writing list: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing list: %w", err)
+	}
+
+	// scale each image to fit 1080p and label it
+	filter := strings.Join([]string{
+		"scale=1920:1080:force_original_aspect_ratio=decrease",
+		"pad=1920:1080:(ow-iw)/2:(oh-ih)/2",
+		`drawtext=text='%{metadata\:name}':fontsize=36:fontcolor=white:box=1:boxcolor=black@0.6:boxborderw=12:x=24:y=h-th-24`,
+		"fps=30",
+		"format=yuv420p",
+	}, ",")
+
+	cmd := exec.Command(bin,
+		"-y",
+		"-loglevel", "error",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", f.Name(),
+		"-vf", filter,
+		"-c:v", "libx264",
+		"-movflags", "+faststart",
+		path)
+
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running ffmpeg: %w", err)
+	}
+
+	return nil
+}
+
+// reviewList returns an ffconcat list of images.
+func reviewList(images []string, d time.Duration) string {
+	var b strings.Builder
+	b.WriteString("ffconcat version 1.0\n")
+	for _, path := range images {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		fmt.Fprintf(&b, "file %s\n", quoteConcat(abs))
+		fmt.Fprintf(&b, "file_packet_metadata %s\n", quoteConcat("name="+filepath.Base(path)))
+		fmt.Fprintf(&b, "duration %f\n", d.Seconds())
+	}
+
+	// the last entry's duration is only honored when repeated
+	last, err := filepath.Abs(images[len(images)-1])
+	if err != nil {
+		last = images[len(images)-1]
+	}
+	fmt.Fprintf(&b, "file %s\n", quoteConcat(last))
+	return b.String()
+}
+
+// quoteConcat quotes s for use in an ffconcat directive.
+func quoteConcat(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
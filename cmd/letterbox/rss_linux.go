@@ -0,0 +1,13 @@
+package main
+
+import "syscall"
+
+// peakRSS returns the peak resident set size in bytes.
+func peakRSS() uint64 {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	// kilobytes on linux
+	return uint64(ru.Maxrss) * 1024
+}
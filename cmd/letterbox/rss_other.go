@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package main
+
+// peakRSS returns zero as the peak resident set size is unavailable.
+func peakRSS() uint64 {
+	return 0
+}
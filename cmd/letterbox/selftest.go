@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/tj/letterbox"
+)
+
+// selftest runs correctness suites against the processing pipeline.
+func selftest(args []string) error {
+	cmd := flag.NewFlagSet("selftest", flag.ExitOnError)
+	colors := cmd.Bool("color", false, "Run the color accuracy suite, letterboxing sRGB, Adobe RGB and Display P3 tagged fixtures and comparing their colors as displayed within delta-E tolerances")
+	format := cmd.String("format", "jpeg", "Output format of the suite: jpeg, png or tiff")
+	quality := cmd.Int("quality", 90, "Output jpeg quality of the suite")
+	maxMean := cmd.Float64("max-delta-e", 1, "Maximum mean CIE76 delta-E of each fixture")
+	maxP99 := cmd.Float64("max-p99-delta-e", 5, "Maximum 99th percentile CIE76 delta-E of each fixture, tolerating jpeg chroma bleeding at the bars")
+	cmd.Parse(args)
+
+	if !*colors {
+		return fmt.Errorf("no suite given, such as -color")
+	}
+
+	return colorSuite(*format, *quality, *maxMean, *maxP99)
+}
+
+// colorSuite letterboxes color-tagged fixtures and compares the colors of
+// each output as displayed, through its profile or sRGB when untagged, with
+// those of its source through the fixture profile, so that dropped or altered
+// profiles and color shifts are caught.
+func colorSuite(format string, quality int, maxMean, maxP99 float64) error {
+	dir, err := ioutil.TempDir("", "letterbox-selftest")
+	if err != nil {
+		return fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// fixtures
+	var paths []string
+	for _, s := range []colorSpace{sRGB, adobeRGB, displayP3} {
+		f := taggedFixture(s)
+		path := filepath.Join(dir, f.name)
+		if err := writeFixture(path, f); err != nil {
+			return fmt.Errorf("writing %s: %w", f.name, err)
+		}
+		paths = append(paths, path)
+	}
+
+	// letterbox
+	results := make(map[string]letterbox.Result)
+	p, err := letterbox.New(filepath.Join(dir, "output"),
+		letterbox.WithAspect("16:9"),
+		letterbox.WithFormat(format),
+		letterbox.WithQuality(quality),
+		letterbox.WithMetadataBackend(letterbox.GoMetadata{}),
+		letterbox.WithConcurrency(1),
+		letterbox.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		letterbox.WithResultHandler(func(r letterbox.Result) {
+			results[r.Source] = r
+		}))
+
+	if err != nil {
+		return err
+	}
+
+	if err := p.Process(context.Background(), paths); err != nil {
+		return fmt.Errorf("processing: %w", err)
+	}
+
+	// compare
+	var failed int
+	for _, path := range paths {
+		name := filepath.Base(path)
+
+		mean, p99, err := compareColors(path, results[path])
+		if err != nil {
+			logger.Error("Error comparing colors", "fixture", name, "error", err)
+			failed++
+			continue
+		}
+
+		mean, p99 = math.Round(mean*100)/100, math.Round(p99*100)/100
+		if mean > maxMean || p99 > maxP99 {
+			logger.Error("Failed", "fixture", name, "mean", mean, "p99", p99)
+			failed++
+			continue
+		}
+
+		logger.Info("Passed", "fixture", name, "mean", mean, "p99", p99)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d fixtures failed", failed, len(paths))
+	}
+
+	return nil
+}
+
+// compareColors returns the mean and 99th percentile delta-E between the
+// source at path and the image within the bars of its output.
+func compareColors(path string, res letterbox.Result) (mean, p99 float64, err error) {
+	if res.Error != "" {
+		return 0, 0, fmt.Errorf("processing: %s", res.Error)
+	}
+
+	src, srcProfile, err := decodeTagged(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading source: %w", err)
+	}
+
+	dst, dstProfile, err := decodeTagged(res.Output)
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading output: %w", err)
+	}
+
+	// image region, drawn unscaled
+	sb := src.Bounds()
+	db := dst.Bounds()
+	r := image.Rect(res.Bars.Left, res.Bars.Top, db.Dx()-res.Bars.Right, db.Dy()-res.Bars.Bottom).Add(db.Min)
+	if r.Size() != sb.Size() {
+		return 0, 0, fmt.Errorf("output image region %v does not match source %v", r.Size(), sb.Size())
+	}
+
+	var sum float64
+	deltas := make([]float64, 0, sb.Dx()*sb.Dy())
+	for y := 0; y < sb.Dy(); y++ {
+		for x := 0; x < sb.Dx(); x++ {
+			a := srcProfile.lab(src.At(sb.Min.X+x, sb.Min.Y+y))
+			b := dstProfile.lab(dst.At(r.Min.X+x, r.Min.Y+y))
+			d := deltaE(a, b)
+			deltas = append(deltas, d)
+			sum += d
+		}
+	}
+
+	slices.Sort(deltas)
+	return sum / float64(len(deltas)), deltas[len(deltas)*99/100], nil
+}
+
+// decodeTagged returns the image at path and its color profile, sRGB when
+// untagged as it is displayed.
+func decodeTagged(path string) (image.Image, *colorProfile, error) {
+	b, err := readICC(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading icc profile: %w", err)
+	}
+
+	if b == nil {
+		b = sRGB.profile()
+	}
+
+	profile, err := parseProfile(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	return img, profile, nil
+}
@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestColorSuite(t *testing.T) {
+	for _, format := range []string{"jpeg", "png", "tiff"} {
+		if err := colorSuite(format, 90, 1, 5); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// interrupt cancels on SIGINT or SIGTERM so that no more images are scheduled
+// while those in-flight complete. A second signal removes the temporary files
+// of in-flight writes from dir and the temporary directories, such as those
+// of extracted inputs and archive outputs, and exits immediately.
+func interrupt(cancel func(), dir string, temporary []string, log *slog.Logger) {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	s := <-c
+	log.Warn("Stopping after the images in-flight, interrupt again to exit now", "signal", s.String())
+	cancel()
+
+	<-c
+	removeTemporary(dir)
+	for _, path := range temporary {
+		os.RemoveAll(path)
+	}
+	log.Warn("Exiting")
+	os.Exit(130)
+}
+
+// removeTemporary removes the temporary files of atomic writes in dir.
+func removeTemporary(dir string) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasPrefix(info.Name(), ".") && strings.Contains(info.Name(), ".tmp-") {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// site is a static site generator's conventions.
+type site struct {
+	// content is the directory of pages scanned for image references.
+	content string
+
+	// static is the directory served at the site root.
+	static string
+
+	// output is the default output directory, within static.
+	output string
+}
+
+// sites are the supported static site generators.
+var sites = map[string]site{
+	"hugo":   {content: "content", static: "static", output: "static/letterbox"},
+	"jekyll": {content: ".", static: ".", output: "assets/letterbox"},
+}
+
+// pageExts are the extensions of pages scanned for image references.
+var pageExts = map[string]bool{
+	".md":       true,
+	".markdown": true,
+	".html":     true,
+}
+
+// imageReference matches markdown images, and src attributes of html and
+// shortcodes such as Hugo's figure.
+var imageReference = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)|\bsrc\s*=\s*["']([^"']+)["']`)
+
+// findSite returns the site conventions by generator name.
+func findSite(name string) (site, error) {
+	s, ok := sites[name]
+	if !ok {
+		return s, fmt.Errorf("unsupported site generator %q, must be hugo or jekyll", name)
+	}
+	return s, nil
+}
+
+// pages returns the pages within the content directory, ignoring generated
+// and hidden directories, and the output directory.
+func (s site) pages(output string) ([]string, error) {
+	var pages []string
+
+	err := filepath.Walk(s.content, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			name := info.Name()
+			if path != s.content && (strings.HasPrefix(name, ".") || name == "_site" || name == "node_modules" || name == "public" || filepath.Clean(path) == filepath.Clean(output)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if pageExts[strings.ToLower(filepath.Ext(path))] {
+			pages = append(pages, path)
+		}
+
+		return nil
+	})
+
+	return pages, err
+}
+
+// resolve returns the local path of an image reference within a page, or an
+// empty string when it is remote, not an image, missing, or already an output.
+func (s site) resolve(page, ref, output string) string {
+	if strings.Contains(ref, "://") || strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "data:") {
+		return ""
+	}
+
+	ref = strings.SplitN(strings.SplitN(ref, "?", 2)[0], "#", 2)[0]
+	if !isImage(ref) {
+		return ""
+	}
+
+	var p string
+	if strings.HasPrefix(ref, "/") {
+		p = filepath.Join(s.static, filepath.FromSlash(ref))
+	} else {
+		p = filepath.Join(filepath.Dir(page), filepath.FromSlash(ref))
+	}
+
+	if rel, err := filepath.Rel(output, p); err == nil && !strings.HasPrefix(rel, "..") {
+		return ""
+	}
+
+	if _, err := os.Stat(p); err != nil {
+		return ""
+	}
+
+	return p
+}
+
+// images returns the images referenced by pages.
+func (s site) images(output string) ([]string, error) {
+	pages, err := s.pages(output)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	seen := make(map[string]bool)
+
+	for _, page := range pages {
+		b, err := ioutil.ReadFile(page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range imageReference.FindAllStringSubmatch(string(b), -1) {
+			ref := m[1] + m[2]
+			p := s.resolve(page, ref, output)
+			if p != "" && !seen[p] {
+				seen[p] = true
+				images = append(images, p)
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// url returns the site url of the file at path within the static directory,
+// or false when it is not served.
+func (s site) url(p string) (string, bool) {
+	rel, err := filepath.Rel(s.static, p)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return path.Join("/", filepath.ToSlash(rel)), true
+}
+
+// rewrite rewrites the image references of pages to the urls of their outputs,
+// keyed by source path, returning the number of references rewritten.
+func (s site) rewrite(output string, outputs map[string]string) (int, error) {
+	pages, err := s.pages(output)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, page := range pages {
+		m, err := rewritePage(page, func(ref string) (string, bool) {
+			p := s.resolve(page, ref, output)
+			dst, ok := outputs[p]
+			if p == "" || !ok {
+				return "", false
+			}
+			return s.url(dst)
+		})
+
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// rewritePage rewrites the image references of the page for which replace
+// returns a replacement, returning the number of references rewritten.
+func rewritePage(page string, replace func(ref string) (string, bool)) (int, error) {
+	b, err := ioutil.ReadFile(page)
+	if err != nil {
+		return 0, err
+	}
+
+	var out strings.Builder
+	var last, n int
+
+	for _, m := range imageReference.FindAllSubmatchIndex(b, -1) {
+		// the reference group which matched
+		start, end := m[2], m[3]
+		if start < 0 {
+			start, end = m[4], m[5]
+		}
+
+		u, ok := replace(string(b[start:end]))
+		if !ok {
+			continue
+		}
+
+		out.Write(b[last:start])
+		out.WriteString(u)
+		last = end
+		n++
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	out.Write(b[last:])
+	return n, ioutil.WriteFile(page, []byte(out.String()), 0644)
+}
+
+// isImage returns true if the path has a supported image extension.
+func isImage(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".tif", ".tiff":
+		return true
+	default:
+		return false
+	}
+}
+
+// isJPEG returns true if the output format is jpeg.
+func isJPEG(format string) bool {
+	return format == "jpeg" || format == "jpg"
+}
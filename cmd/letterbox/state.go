@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/tj/letterbox"
+)
+
+// state is persisted across runs in the output directory.
+type state struct {
+	// Failures maps the images which failed to their error.
+	Failures map[string]string `json:"failures"`
+}
+
+// loadState reads the state at path, returning an empty state when it does not exist.
+func loadState(path string) (*state, error) {
+	s := &state{
+		Failures: make(map[string]string),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	if s.Failures == nil {
+		s.Failures = make(map[string]string)
+	}
+
+	return s, nil
+}
+
+// Failed returns the sorted images which failed.
+func (s *state) Failed() (images []string) {
+	for path := range s.Failures {
+		images = append(images, path)
+	}
+	sort.Strings(images)
+	return
+}
+
+// Update the state with the results of a run.
+func (s *state) Update(results []letterbox.Result) {
+	failed := make(map[string]string)
+	for _, r := range results {
+		if r.Error != "" {
+			failed[r.Source] = r.Error
+		}
+	}
+
+	for _, r := range results {
+		if err, ok := failed[r.Source]; ok {
+			s.Failures[r.Source] = err
+		} else {
+			delete(s.Failures, r.Source)
+		}
+	}
+}
+
+// Save the state to path.
+func (s *state) Save(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// request is a JSON processing request read from stdin, allowing export
+// plugins such as Lightroom's post-processing actions to invoke letterbox
+// with per-export settings. The response is the JSON report written to stdout.
+type request struct {
+	config
+	Images []string `json:"images"`
+}
+
+// readRequest reads a request from r.
+func readRequest(r io.Reader) (*request, error) {
+	var req request
+	err := json.NewDecoder(r).Decode(&req)
+	if err != nil {
+		return nil, fmt.Errorf("parsing: %w", err)
+	}
+
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(req.Images) == 0 {
+		return nil, fmt.Errorf("no images requested")
+	}
+
+	return &req, nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tj/letterbox"
+)
+
+// boundary is the multipart boundary of served MJPEG streams.
+const boundary = "letterbox"
+
+// stream letterboxes each frame of an MJPEG or RTSP camera stream, and
+// serves the result as MJPEG over HTTP.
+func stream(args []string) error {
+	cmd := flag.NewFlagSet("stream", flag.ExitOnError)
+	input := cmd.String("input", "", "Input MJPEG over HTTP or RTSP (requires ffmpeg) stream URL")
+	listen := cmd.String("listen", ":8080", "Address the letterboxed MJPEG stream is served on")
+	aspect := cmd.String("aspect", "16:9", "Output aspect ratio")
+	size := cmd.String("size", "", "Exact output dimensions such as 1280x720")
+	white := cmd.Bool("white", false, "Output a white letterbox")
+	quality := cmd.Int("quality", 80, "Output jpeg quality")
+	cmd.Parse(args)
+
+	if *input == "" {
+		return fmt.Errorf("-input is required")
+	}
+
+	width, height, err := parseSize(*size)
+	if err != nil {
+		return err
+	}
+
+	fp, err := letterbox.NewFrameProcessor(
+		letterbox.WithAspect(*aspect),
+		letterbox.WithSize(width, height),
+		letterbox.WithUpscale(true),
+		letterbox.WithWhiteBackground(*white),
+		letterbox.WithResampler("bilinear"))
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	frames, err := readFrames(ctx, *input)
+	if err != nil {
+		return fmt.Errorf("reading stream: %w", err)
+	}
+
+	var b broadcast
+	m := newStreamMetrics(&b)
+	errs := make(chan error, 1)
+
+	// letterbox frames
+	go func() {
+		var buf bytes.Buffer
+		for {
+			frame, err := frames()
+			if err != nil {
+				errs <- fmt.Errorf("reading frame: %w", err)
+				return
+			}
+
+			start := time.Now()
+			img, err := jpeg.Decode(bytes.NewReader(frame))
+			if err != nil {
+				logger.Warn("Skipping undecodable frame", "error", err)
+				m.Fail()
+				continue
+			}
+
+			out, err := fp.Process(img)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			buf.Reset()
+			if err := jpeg.Encode(&buf, out, &jpeg.Options{Quality: *quality}); err != nil {
+				errs <- fmt.Errorf("encoding frame: %w", err)
+				return
+			}
+
+			m.Observe(time.Since(start))
+			b.Publish(append([]byte(nil), buf.Bytes()...))
+		}
+	}()
+
+	// serve
+	mux := http.NewServeMux()
+	mux.Handle("/", &b)
+	mux.Handle("/metrics", m)
+
+	go func() {
+		logger.Info("Serving stream", "input", *input, "address", *listen)
+		errs <- http.ListenAndServe(*listen, mux)
+	}()
+
+	return <-errs
+}
+
+// readFrames returns a function reading the next jpeg frame of the stream at
+// url, which is MJPEG over HTTP, or RTSP which is transcoded with ffmpeg.
+func readFrames(ctx context.Context, url string) (func() ([]byte, error), error) {
+	if strings.HasPrefix(url, "rtsp://") || strings.HasPrefix(url, "rtsps://") {
+		bin, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			return nil, fmt.Errorf("ffmpeg is required for rtsp: %w", err)
+		}
+
+		cmd := exec.CommandContext(ctx, bin,
+			"-loglevel", "error",
+			"-rtsp_transport", "tcp",
+			"-i", url,
+			"-f", "mjpeg",
+			"-q:v", "2",
+			"pipe:1")
+
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("starting ffmpeg: %w", err)
+		}
+
+		r := bufio.NewReader(out)
+		return func() ([]byte, error) {
+			return readJPEG(r)
+		}, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s response", res.Status)
+	}
+
+	_, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		// not multipart, read concatenated jpegs
+		r := bufio.NewReader(res.Body)
+		return func() ([]byte, error) {
+			return readJPEG(r)
+		}, nil
+	}
+
+	mr := multipart.NewReader(res.Body, strings.TrimPrefix(params["boundary"], "--"))
+	return func() ([]byte, error) {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(part)
+	}, nil
+}
+
+// readJPEG reads the next jpeg from a stream of concatenated jpegs, from
+// its SOI marker to its EOI marker.
+func readJPEG(r *bufio.Reader) ([]byte, error) {
+	// SOI
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if b != 0xFF {
+			continue
+		}
+
+		b, err = r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if b == 0xD8 {
+			break
+		}
+		r.UnreadByte()
+	}
+
+	// EOI, which can't occur within entropy-coded data as 0xFF is stuffed
+	frame := []byte{0xFF, 0xD8}
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		frame = append(frame, b)
+		if n := len(frame); n > 3 && frame[n-2] == 0xFF && b == 0xD9 {
+			return frame, nil
+		}
+	}
+}
+
+// broadcast serves the latest frame to MJPEG clients.
+type broadcast struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	frame   []byte
+	seq     int
+	clients int
+}
+
+// Publish the frame to clients.
+func (b *broadcast) Publish(frame []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	b.frame = frame
+	b.seq++
+	b.cond.Broadcast()
+}
+
+// Clients returns the number of connected clients.
+func (b *broadcast) Clients() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.clients
+}
+
+// init the condition, with mu held.
+func (b *broadcast) init() {
+	if b.cond == nil {
+		b.cond = sync.NewCond(&b.mu)
+	}
+}
+
+// ServeHTTP implementation, writing frames as they're published until the
+// client disconnects.
+func (b *broadcast) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// wake the client on disconnect
+	ctx := r.Context()
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		b.init()
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	}()
+
+	b.mu.Lock()
+	b.clients++
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.clients--
+		b.mu.Unlock()
+	}()
+
+	mw := multipart.NewWriter(w)
+	mw.SetBoundary(boundary)
+
+	var seen int
+	for {
+		b.mu.Lock()
+		b.init()
+		for b.seq == seen && ctx.Err() == nil {
+			b.cond.Wait()
+		}
+		frame, seq := b.frame, b.seq
+		b.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+		seen = seq
+
+		part, err := mw.CreatePart(map[string][]string{
+			"Content-Type":   {"image/jpeg"},
+			"Content-Length": {fmt.Sprint(len(frame))},
+		})
+		if err != nil {
+			return
+		}
+
+		if _, err := part.Write(frame); err != nil {
+			return
+		}
+
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
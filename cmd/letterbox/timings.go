@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tj/letterbox"
+)
+
+// writeTimings writes the stage timings of each processed image, their
+// percentiles, and the throughput of the run over elapsed.
+func writeTimings(out io.Writer, results []letterbox.Result, elapsed time.Duration) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDECODE\tCOMPOSE\tENCODE\tTOTAL")
+
+	var decode, compose, encode, total []time.Duration
+	var bytes int64
+	sources := make(map[string]bool)
+
+	for _, r := range results {
+		t := r.Timings
+		if t == nil || r.Error != "" {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Output,
+			formatDuration(t.Decode),
+			formatDuration(t.Compose),
+			formatDuration(t.Encode),
+			formatDuration(r.Duration))
+
+		compose = append(compose, t.Compose)
+		encode = append(encode, t.Encode)
+		total = append(total, r.Duration)
+
+		// sources are decoded and read once for all targets
+		if !sources[r.Source] {
+			sources[r.Source] = true
+			decode = append(decode, t.Decode)
+			if info, err := os.Stat(r.Source); err == nil {
+				bytes += info.Size()
+			}
+		}
+	}
+
+	if len(total) == 0 {
+		return w.Flush()
+	}
+
+	fmt.Fprintln(w)
+	for _, q := range []float64{0.5, 0.95} {
+		fmt.Fprintf(w, "P%.0f\t%s\t%s\t%s\t%s\n", q*100,
+			formatDuration(percentile(decode, q)),
+			formatDuration(percentile(compose, q)),
+			formatDuration(percentile(encode, q)),
+			formatDuration(percentile(total, q)))
+	}
+
+	secs := elapsed.Seconds()
+	fmt.Fprintf(w, "\nTHROUGHPUT\t%.1f images/s\t%.1f MB/s\n", float64(len(sources))/secs, float64(bytes)/(1<<20)/secs)
+	return w.Flush()
+}
+
+// percentile returns the q percentile of durations d using the nearest rank.
+func percentile(d []time.Duration, q float64) time.Duration {
+	s := append([]time.Duration{}, d...)
+	sort.Slice(s, func(i, j int) bool {
+		return s[i] < s[j]
+	})
+
+	i := int(q*float64(len(s))+0.5) - 1
+	return s[max(0, min(i, len(s)-1))]
+}
+
+// formatDuration returns d rounded for display.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(100 * time.Microsecond).String()
+	default:
+		return d.Round(time.Microsecond).String()
+	}
+}
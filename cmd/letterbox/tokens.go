@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tj/letterbox"
+)
+
+// tokens are design tokens keyed by name, such as "surface-dark".
+type tokens map[string]string
+
+// declaration matches a CSS custom property declaration.
+var declaration = regexp.MustCompile(`(--[\w-]+)\s*:\s*([^;}]+)`)
+
+// reference matches a CSS var() or design token alias reference.
+var reference = regexp.MustCompile(`^(?:var\(\s*--([\w-]+)\s*\)|\{([\w.-]+)\})$`)
+
+// readTokens reads a design tokens JSON file, or CSS file of custom properties.
+// Nested JSON groups are joined with "-", and leaves may be plain values or
+// objects with a "$value" or "value" field.
+func readTokens(path string) (tokens, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := make(tokens)
+
+	if filepath.Ext(path) == ".css" {
+		for _, m := range declaration.FindAllStringSubmatch(string(b), -1) {
+			t[strings.TrimPrefix(m[1], "--")] = strings.TrimSpace(m[2])
+		}
+		return t, nil
+	}
+
+	var v map[string]interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	t.flatten("", v)
+	return t, nil
+}
+
+// flatten adds the nested tokens of v with the given name prefix.
+func (t tokens) flatten(prefix string, v map[string]interface{}) {
+	for k, v := range v {
+		name := k
+		if prefix != "" {
+			name = prefix + "-" + k
+		}
+
+		switch v := v.(type) {
+		case string:
+			t[name] = v
+		case map[string]interface{}:
+			if s, ok := v["$value"].(string); ok {
+				t[name] = s
+			} else if s, ok := v["value"].(string); ok {
+				t[name] = s
+			} else {
+				t.flatten(name, v)
+			}
+		}
+	}
+}
+
+// resolve returns the value of s, following token references such as
+// "var(--surface-dark)" or "{color.surface.dark}".
+func (t tokens) resolve(s string) (string, error) {
+	for i := 0; i < 10; i++ {
+		m := reference.FindStringSubmatch(strings.TrimSpace(s))
+		if m == nil {
+			return s, nil
+		}
+
+		name := m[1]
+		if name == "" {
+			name = strings.Replace(m[2], ".", "-", -1)
+		}
+
+		v, ok := t[name]
+		if !ok {
+			return "", fmt.Errorf("unknown token %q", name)
+		}
+		s = v
+	}
+
+	return "", fmt.Errorf("too many token references resolving %q", s)
+}
+
+// color returns the color s, resolving token references.
+func (t tokens) color(s string) (color.Color, error) {
+	v, err := t.resolve(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return letterbox.ParseColor(v)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// isVideo returns true if path has a video extension.
+func isVideo(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".m4v", ".mov", ".mkv", ".webm", ".avi":
+		return true
+	default:
+		return false
+	}
+}
+
+// posters extracts a frame of each video among images into dir as a png,
+// mirroring its path relative to the working directory. It returns the images
+// with videos replaced by their frames, and the videos keyed by frame. This
+// requires ffmpeg, and ffprobe for the middle frame.
+func posters(images []string, dir, at string) ([]string, map[string]string, error) {
+	var list []string
+	videos := make(map[string]string)
+
+	for _, path := range images {
+		if !isVideo(path) {
+			list = append(list, path)
+			continue
+		}
+
+		dst := renderPath(dir, path, ".png")
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return nil, nil, err
+		}
+
+		if err := extractFrame(path, dst, at); err != nil {
+			return nil, nil, fmt.Errorf("extracting frame of %s: %w", path, err)
+		}
+
+		// modification time of the video, for skip policies
+		if err := copyModTime(path, dst); err != nil {
+			return nil, nil, err
+		}
+
+		logger.Debug("Extracted frame", "path", path, "at", at)
+		videos[dst] = path
+		list = append(list, dst)
+	}
+
+	return list, videos, nil
+}
+
+// renderPath returns the path within dir of the image with extension ext
+// rendered from the input at path, mirroring its path relative to the working
+// directory when within it.
+func renderPath(dir, path, ext string) string {
+	rel := filepath.Base(path)
+	if abs, err := filepath.Abs(path); err == nil {
+		if wd, err := os.Getwd(); err == nil {
+			if r, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(r, "..") {
+				rel = r
+			}
+		}
+	}
+
+	return filepath.Join(dir, strings.TrimSuffix(rel, filepath.Ext(rel))+ext)
+}
+
+// copyModTime sets the modification time of dst to that of src.
+func copyModTime(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// extractFrame writes the frame of the video at path to dst, at "first",
+// "middle", or a position such as 00:00:05 or 5.5 seconds.
+func extractFrame(path, dst, at string) error {
+	bin, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg is required: %w", err)
+	}
+
+	args := []string{"-y", "-loglevel", "error"}
+
+	switch at {
+	case "first", "":
+	case "middle":
+		d, err := videoDuration(path)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-ss", strconv.FormatFloat(d/2, 'f', 3, 64))
+	default:
+		args = append(args, "-ss", at)
+	}
+
+	args = append(args, "-i", path, "-frames:v", "1", dst)
+
+	out, err := exec.Command(bin, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running ffmpeg: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+
+	// positions past the end write nothing
+	if _, err := os.Stat(dst); err != nil {
+		return fmt.Errorf("no frame at %s", at)
+	}
+
+	return nil
+}
+
+// videoDuration returns the duration of the video at path in seconds.
+func videoDuration(path string) (float64, error) {
+	bin, err := exec.LookPath("ffprobe")
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe is required: %w", err)
+	}
+
+	out, err := exec.Command(bin,
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path).Output()
+
+	if err != nil {
+		return 0, fmt.Errorf("running ffprobe: %w", err)
+	}
+
+	d, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing duration %q", strings.TrimSpace(string(out)))
+	}
+
+	return d, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// window filters images by modification time and file size, for incremental
+// runs over an archive. Zero values leave a bound open.
+type window struct {
+	newer   time.Time
+	older   time.Time
+	minSize int64
+	maxSize int64
+}
+
+// newWindow returns a window from the flag values, dates such as 2024-01-01
+// or RFC 3339 times, and sizes such as 2MB.
+func newWindow(newer, older, minSize, maxSize string) (w window, err error) {
+	w.newer, err = parseDate(newer)
+	if err != nil {
+		return w, fmt.Errorf("-newer-than: %w", err)
+	}
+
+	w.older, err = parseDate(older)
+	if err != nil {
+		return w, fmt.Errorf("-older-than: %w", err)
+	}
+
+	w.minSize, err = parseBytes(minSize)
+	if err != nil {
+		return w, fmt.Errorf("-min-size: %w", err)
+	}
+
+	w.maxSize, err = parseBytes(maxSize)
+	if err != nil {
+		return w, fmt.Errorf("-max-size: %w", err)
+	}
+
+	return w, nil
+}
+
+// empty returns true if the window has no bounds.
+func (w window) empty() bool {
+	return w == window{}
+}
+
+// filter returns the images within the window, modified at or after newer
+// and before older, so that consecutive windows don't overlap, and of a size
+// from min to max inclusive.
+func (w window) filter(images []string) ([]string, error) {
+	var list []string
+
+	for _, path := range images {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		t, size := info.ModTime(), info.Size()
+		switch {
+		case !w.newer.IsZero() && t.Before(w.newer):
+		case !w.older.IsZero() && !t.Before(w.older):
+		case w.minSize > 0 && size < w.minSize:
+		case w.maxSize > 0 && size > w.maxSize:
+		default:
+			list = append(list, path)
+		}
+	}
+
+	return list, nil
+}
+
+// parseDate returns the local date such as 2024-01-01, or RFC 3339 time.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, must be such as 2024-01-01 or 2024-01-01T15:04:05Z", s)
+	}
+
+	return t, nil
+}
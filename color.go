@@ -0,0 +1,75 @@
+package letterbox
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// colors are the named colors supported.
+var colors = map[string]color.Color{
+	"black":       color.Black,
+	"white":       color.White,
+	"transparent": color.Transparent,
+}
+
+// ParseColor returns a parsed color such as "white", "#fff", "#1a1a1a",
+// "#1a1a1a80" or "rgb(26, 26, 26)".
+func ParseColor(s string) (color.Color, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	if c, ok := colors[s]; ok {
+		return c, nil
+	}
+
+	// hex
+	if strings.HasPrefix(s, "#") {
+		h := s[1:]
+		if len(h) == 3 || len(h) == 4 {
+			var b strings.Builder
+			for _, r := range h {
+				b.WriteRune(r)
+				b.WriteRune(r)
+			}
+			h = b.String()
+		}
+
+		if len(h) == 6 {
+			h += "ff"
+		}
+
+		n, err := strconv.ParseUint(h, 16, 32)
+		if err != nil || len(h) != 8 {
+			return nil, fmt.Errorf("invalid color %q", s)
+		}
+
+		return color.NRGBA{
+			R: uint8(n >> 24),
+			G: uint8(n >> 16),
+			B: uint8(n >> 8),
+			A: uint8(n),
+		}, nil
+	}
+
+	// rgb
+	if strings.HasPrefix(s, "rgb(") && strings.HasSuffix(s, ")") {
+		parts := strings.Split(s[4:len(s)-1], ",")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid color %q", s)
+		}
+
+		var v [3]uint8
+		for i, p := range parts {
+			n, err := strconv.ParseUint(strings.TrimSpace(p), 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid color %q", s)
+			}
+			v[i] = uint8(n)
+		}
+
+		return color.NRGBA{v[0], v[1], v[2], 255}, nil
+	}
+
+	return nil, fmt.Errorf("invalid color %q", s)
+}
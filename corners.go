@@ -0,0 +1,49 @@
+package letterbox
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// WithCornerRadius changes the radius in pixels of the rounded corners the
+// source is masked with, alpha blended onto the background. Zero disables it.
+func WithCornerRadius(n int) Option {
+	return func(p *Processor) error {
+		if n < 0 {
+			return fmt.Errorf("invalid corner radius %d", n)
+		}
+		p.radius = n
+		return nil
+	}
+}
+
+// roundedMask returns an anti-aliased mask of rect r with rounded corners.
+func roundedMask(r image.Rectangle, radius int) *image.Alpha {
+	m := image.NewAlpha(r)
+	draw.Draw(m, r, image.Opaque, image.ZP, draw.Src)
+
+	radius = min(radius, r.Dx()/2, r.Dy()/2)
+	if radius <= 0 {
+		return m
+	}
+
+	rf := float64(radius)
+	for y := 0; y < radius; y++ {
+		for x := 0; x < radius; x++ {
+			// distance of the pixel center from the corner circle center
+			dx := rf - float64(x) - 0.5
+			dy := rf - float64(y) - 0.5
+			a := math.Max(0, math.Min(1, rf-math.Hypot(dx, dy)+0.5))
+			v := uint8(math.Round(a * 255))
+
+			m.Pix[m.PixOffset(r.Min.X+x, r.Min.Y+y)] = v
+			m.Pix[m.PixOffset(r.Max.X-1-x, r.Min.Y+y)] = v
+			m.Pix[m.PixOffset(r.Min.X+x, r.Max.Y-1-y)] = v
+			m.Pix[m.PixOffset(r.Max.X-1-x, r.Max.Y-1-y)] = v
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,148 @@
+package letterbox
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// effect is drawn onto the canvas after the background fill and before the
+// source, which is drawn to rect r with the given corner radius.
+type effect interface {
+	draw(dst draw.Image, r image.Rectangle, radius int)
+}
+
+// border is a stroke around the source.
+type border struct {
+	width int
+	color color.Color
+}
+
+// draw implementation.
+func (b border) draw(dst draw.Image, r image.Rectangle, radius int) {
+	r = r.Inset(-b.width)
+
+	if radius > 0 {
+		draw.DrawMask(dst, r, &image.Uniform{b.color}, image.ZP, roundedMask(r, radius+b.width), r.Min, draw.Over)
+		return
+	}
+
+	draw.Draw(dst, r, &image.Uniform{b.color}, image.ZP, draw.Over)
+}
+
+// shadow is a soft drop shadow beneath the source.
+type shadow struct {
+	blur   int
+	offset image.Point
+	color  color.Color
+}
+
+// draw implementation.
+func (s shadow) draw(dst draw.Image, r image.Rectangle, radius int) {
+	r = r.Add(s.offset)
+
+	// opaque mask of the source rect, with room for the blur
+	mr := r.Inset(-2 * s.blur)
+	mask := image.NewAlpha(mr)
+	draw.Draw(mask, r, roundedMask(r, radius), r.Min, draw.Src)
+
+	// three box blurs approximate a gaussian blur
+	for i := 0; i < 3; i++ {
+		boxBlur(mask, s.blur/2)
+	}
+
+	draw.DrawMask(dst, mr, &image.Uniform{s.color}, image.ZP, mask, mr.Min, draw.Over)
+}
+
+// boxBlur blurs the mask horizontally then vertically with the given radius.
+func boxBlur(m *image.Alpha, radius int) {
+	if radius < 1 {
+		return
+	}
+
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	buf := make([]uint8, max(w, h))
+
+	// blur a line of n values, each step apart, starting at offset i
+	line := func(i, n, step int) {
+		sum := 0
+		for k := 0; k < n; k++ {
+			buf[k] = m.Pix[i+k*step]
+		}
+
+		for k := -radius; k < radius; k++ {
+			if k >= 0 && k < n {
+				sum += int(buf[k])
+			}
+		}
+
+		for k := 0; k < n; k++ {
+			if j := k + radius; j < n {
+				sum += int(buf[j])
+			}
+			m.Pix[i+k*step] = uint8(sum / (2*radius + 1))
+			if j := k - radius; j >= 0 {
+				sum -= int(buf[j])
+			}
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		line(y*m.Stride, w, 1)
+	}
+
+	for x := 0; x < w; x++ {
+		line(x, h, m.Stride)
+	}
+}
+
+// WithBorder changes the stroke border drawn around the source, of the given
+// width in pixels. Zero disables it.
+func WithBorder(width int, c color.Color) Option {
+	return func(p *Processor) error {
+		if width < 0 {
+			return fmt.Errorf("invalid border width %d", width)
+		}
+
+		p.border = nil
+		if width > 0 {
+			p.border = &border{width: width, color: c}
+		}
+		return nil
+	}
+}
+
+// WithShadow changes the soft drop shadow drawn beneath the source, blurred
+// by the given radius in pixels and offset, using a color such as
+// "#00000080" for a translucent shadow. A zero radius and offset disables it.
+func WithShadow(blur int, offset image.Point, c color.Color) Option {
+	return func(p *Processor) error {
+		if blur < 0 {
+			return fmt.Errorf("invalid shadow blur %d", blur)
+		}
+
+		p.shadow = nil
+		if blur > 0 || offset != image.ZP {
+			p.shadow = &shadow{blur: blur, offset: offset, color: c}
+		}
+		return nil
+	}
+}
+
+// effects returns the effects drawn between the background and the source,
+// the shadow beneath the border.
+func (p *Processor) effects() []effect {
+	var effects []effect
+
+	if p.shadow != nil {
+		effects = append(effects, *p.shadow)
+	}
+
+	if p.border != nil {
+		effects = append(effects, *p.border)
+	}
+
+	return effects
+}
@@ -0,0 +1,154 @@
+package letterbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Exif is the basic EXIF metadata of an image, with zero values for missing tags.
+type Exif struct {
+	Make         string
+	Model        string
+	Taken        time.Time
+	Orientation  int
+	ISO          int
+	ExposureTime float64
+	FNumber      float64
+	FocalLength  float64
+}
+
+// EXIF tags of the basic metadata.
+const (
+	tagMake         = 0x010f
+	tagModel        = 0x0110
+	tagOrientation  = 0x0112
+	tagDateTime     = 0x0132
+	tagExifIFD      = 0x8769
+	tagExposureTime = 0x829a
+	tagFNumber      = 0x829d
+	tagISO          = 0x8827
+	tagTaken        = 0x9003
+	tagFocalLength  = 0x920a
+)
+
+// ReadExif returns the basic EXIF metadata of the jpeg at path, which is
+// empty for images without any, including non-jpeg images. The time taken
+// falls back to the time of last change, both without a time zone.
+func ReadExif(path string) (Exif, error) {
+	var e Exif
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return e, err
+	}
+
+	if !isJPEG(b) {
+		return e, nil
+	}
+
+	start, end, err := exifSegment(b)
+	if err != nil || start == 0 {
+		return e, err
+	}
+
+	tiff := b[start+4+len(exifHeader) : end]
+	if len(tiff) < 8 {
+		return e, errors.New("invalid exif data")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return e, errors.New("invalid exif byte order")
+	}
+
+	ifd0 := exifEntries(tiff, order, int(order.Uint32(tiff[4:])))
+	e.Make = exifString(tiff, order, ifd0[tagMake])
+	e.Model = exifString(tiff, order, ifd0[tagModel])
+	e.Orientation, _ = first(tiffValues(tiff, order, ifd0[tagOrientation]))
+	taken := exifString(tiff, order, ifd0[tagDateTime])
+
+	// exif sub-IFD
+	if off, ok := first(tiffValues(tiff, order, ifd0[tagExifIFD])); ok {
+		sub := exifEntries(tiff, order, off)
+		e.ISO, _ = first(tiffValues(tiff, order, sub[tagISO]))
+		e.ExposureTime = exifRational(tiff, order, sub[tagExposureTime])
+		e.FNumber = exifRational(tiff, order, sub[tagFNumber])
+		e.FocalLength = exifRational(tiff, order, sub[tagFocalLength])
+		if s := exifString(tiff, order, sub[tagTaken]); s != "" {
+			taken = s
+		}
+	}
+
+	if t, err := time.Parse("2006:01:02 15:04:05", taken); err == nil {
+		e.Taken = t
+	}
+
+	return e, nil
+}
+
+// exifEntries returns the offsets of the entries of the IFD at ifd by tag.
+// Missing tags have an offset of zero, which has no valid entry.
+func exifEntries(tiff []byte, order binary.ByteOrder, ifd int) map[uint16]int {
+	entries := make(map[uint16]int)
+	if ifd <= 0 || ifd+2 > len(tiff) {
+		return entries
+	}
+
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[e:])] = e
+	}
+
+	return entries
+}
+
+// exifString returns the ASCII value of the entry at e, or "" when missing.
+func exifString(tiff []byte, order binary.ByteOrder, e int) string {
+	if e == 0 || order.Uint16(tiff[e+2:]) != 2 {
+		return ""
+	}
+
+	// values of 4 bytes or less are inline
+	count := int(order.Uint32(tiff[e+4:]))
+	at := e + 8
+	if count > 4 {
+		at = int(order.Uint32(tiff[e+8:]))
+	}
+
+	if count <= 0 || at < 0 || at+count > len(tiff) {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimRight(string(tiff[at:at+count]), "\x00"))
+}
+
+// exifRational returns the RATIONAL value of the entry at e, or 0 when missing.
+func exifRational(tiff []byte, order binary.ByteOrder, e int) float64 {
+	if e == 0 || order.Uint16(tiff[e+2:]) != 5 {
+		return 0
+	}
+
+	at := int(order.Uint32(tiff[e+8:]))
+	if at < 0 || at+8 > len(tiff) {
+		return 0
+	}
+
+	num, den := order.Uint32(tiff[at:]), order.Uint32(tiff[at+4:])
+	if den == 0 {
+		return 0
+	}
+
+	return float64(num) / float64(den)
+}
@@ -0,0 +1,252 @@
+package letterbox
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// Stage is a step of the pipeline processing each image, as resolved from
+// the options, for explaining what will run and in what order.
+type Stage struct {
+	// Name of the stage, such as "decode".
+	Name string `json:"name"`
+
+	// Params are the resolved parameters of the stage, such as "quality=90".
+	Params []string `json:"params,omitempty"`
+
+	// Pixels is the estimated number of pixels the stage processes for the
+	// first image, from its header, or zero when unknown or negligible.
+	Pixels int `json:"pixels,omitempty"`
+
+	// Stages are the sub-stages, in order.
+	Stages []Stage `json:"stages,omitempty"`
+}
+
+// Explain returns the pipeline which would process the images, with the
+// pixels processed by each stage estimated from the header of the first
+// image, without decoding or writing anything.
+func (p *Processor) Explain(images []string) (*Stage, error) {
+	var c image.Config
+	if len(images) > 0 {
+		var err error
+		c, err = (&source{path: images[0]}).decodeConfig()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", images[0], err)
+		}
+	}
+
+	root := Stage{
+		Name: "process",
+		Params: params(
+			"images", len(images),
+			"concurrency", p.concurrency,
+			"io-concurrency", p.ioWorkers,
+			"adaptive", p.adaptive,
+			"schedule", p.schedule,
+			"timeout", p.timeout,
+			"max-pixels", p.maxPixels,
+			"max-memory", p.maxMemory),
+	}
+
+	// skip
+	skip := Stage{Name: "skip", Params: params("force", p.force)}
+	if _, ok := p.skip.(*HashSkip); ok {
+		skip.Params = append(skip.Params, "policy=hash")
+	}
+	root.Stages = append(root.Stages, skip)
+
+	// pass through
+	if p.tolerance > 0 {
+		root.Stages = append(root.Stages, Stage{Name: "passthrough", Params: params("tolerance", p.tolerance, "hard-links", p.links)})
+	}
+
+	// sidecar
+	if p.sidecars {
+		root.Stages = append(root.Stages, Stage{Name: "sidecar", Params: []string{"reject", "crop"}})
+	}
+
+	px := c.Width * c.Height
+
+	// read and decode
+	if p.loader != nil {
+		root.Stages = append(root.Stages, Stage{Name: "load", Params: params("loader", typeName(p.loader))})
+	} else {
+		if p.ioWorkers > 0 {
+			root.Stages = append(root.Stages, Stage{Name: "read", Params: params("io-concurrency", p.ioWorkers)})
+		}
+		decode := Stage{Name: "decode", Params: params("decoder", "go"), Pixels: px}
+		if p.scaledDecode && len(images) > 0 {
+			n := p.decodeScale(&source{path: images[0]}, p.orientedTargets(image.Pt(c.Width, c.Height)))
+			decode.Params = append(decode.Params, params("scale", fmt.Sprintf("1/%d", n))...)
+			decode.Pixels = px / (n * n)
+		}
+		root.Stages = append(root.Stages, decode)
+	}
+
+	for _, t := range p.orientedTargets(image.Pt(c.Width, c.Height)) {
+		root.Stages = append(root.Stages, p.explainTarget(t, c))
+	}
+
+	return &root, nil
+}
+
+// explainTarget returns the stages of the target for an image of config c.
+func (p *Processor) explainTarget(t target, c image.Config) Stage {
+	s := Stage{Name: "target " + t.name, Params: params("dir", t.dir)}
+
+	sr, db, dr := image.Rectangle{}, image.Rectangle{}, image.Rectangle{}
+	if c.Width > 0 && c.Height > 0 {
+		sr = p.region(nil, image.Rect(0, 0, c.Width, c.Height), nil, t)
+		db, dr = p.layout(sr, t, nil)
+	}
+	area := func(r image.Rectangle) int { return r.Dx() * r.Dy() }
+
+	// split
+	if p.split {
+		s.Stages = append(s.Stages, Stage{Name: "split", Params: params("overlap", percent(p.overlap))})
+	}
+
+	// crop
+	if p.fit == "cover" {
+		s.Stages = append(s.Stages, Stage{Name: "crop", Params: params("fit", p.fit, "gravity", p.gravity)})
+	}
+
+	// layout
+	layout := params("aspect", t.name, "fit", p.fit, "gravity", p.gravity, "round", p.round)
+	if p.size.Width > 0 || p.size.Height > 0 {
+		layout = append(layout, params("size", fmt.Sprintf("%dx%d", p.size.Width, p.size.Height), "upscale", p.upscale)...)
+	}
+	if p.maxSize.Width > 0 || p.maxSize.Height > 0 {
+		layout = append(layout, params("max-size", fmt.Sprintf("%dx%d", p.maxSize.Width, p.maxSize.Height))...)
+	}
+	layout = append(layout, params(
+		"padding", percent(p.padding),
+		"pad-to", percent(p.padTo),
+		"margin", p.margin != Margin{},
+		"offset", p.offset != [2]Length{},
+		"force-even", p.forceEven)...)
+	if db != (image.Rectangle{}) {
+		layout = append(layout, params("canvas", fmt.Sprintf("%dx%d", db.Dx(), db.Dy()))...)
+	}
+	s.Stages = append(s.Stages, Stage{Name: "layout", Params: layout})
+
+	// compose
+	compose := Stage{Name: "compose"}
+
+	fill := Stage{Name: "fill", Params: params("background", hexColor(p.background))}
+	fill.Pixels = area(db) - area(dr)
+	if p.radius > 0 || len(p.effects()) > 0 {
+		fill.Pixels = area(db)
+	}
+	compose.Stages = append(compose.Stages, fill)
+
+	if sh := p.shadow; sh != nil {
+		compose.Stages = append(compose.Stages, Stage{Name: "shadow", Params: params("blur", sh.blur, "offset", sh.offset), Pixels: area(dr)})
+	}
+
+	if b := p.border; b != nil {
+		compose.Stages = append(compose.Stages, Stage{Name: "border", Params: params("width", b.width)})
+	}
+
+	scale := Stage{Name: "scale", Params: params("resampler", resamplerName(p.resampler)), Pixels: area(dr)}
+	if p.radius > 0 {
+		scale.Params = append(scale.Params, params("corner-radius", p.radius)...)
+	}
+	compose.Stages = append(compose.Stages, scale)
+
+	if w := p.watermark; w != nil {
+		compose.Stages = append(compose.Stages, Stage{Name: "watermark", Params: params("position", w.position, "opacity", w.opacity), Pixels: area(w.img.Bounds())})
+	}
+
+	s.Stages = append(s.Stages, compose)
+
+	// encode
+	encode := Stage{Name: "encode", Params: params("format", p.format), Pixels: area(db)}
+	switch p.format {
+	case "png":
+		encode.Params = append(encode.Params, params("compression", compressionName(p.compression))...)
+	case "jpeg":
+		encode.Params = append(encode.Params, params("quality", p.quality)...)
+		if p.parallelEncode > 0 && area(db) >= p.parallelEncode {
+			encode.Params = append(encode.Params, params("parallel", true)...)
+		}
+	}
+	if p.depth == 16 {
+		encode.Params = append(encode.Params, params("depth", p.depth)...)
+	}
+	s.Stages = append(s.Stages, encode)
+
+	// write
+	write := Stage{Name: "write", Params: []string{"atomic"}}
+	if p.backups != "" {
+		write.Params = append(write.Params, params("backup-dir", p.backups)...)
+	}
+	s.Stages = append(s.Stages, write)
+
+	if p.metadata != nil {
+		s.Stages = append(s.Stages, Stage{Name: "metadata", Params: params("backend", typeName(p.metadata))})
+	}
+
+	if p.thumbnail > 0 {
+		s.Stages = append(s.Stages, Stage{Name: "thumbnail", Params: params("size", p.thumbnail), Pixels: p.thumbnail * p.thumbnail})
+	}
+
+	if p.verify {
+		s.Stages = append(s.Stages, Stage{Name: "verify", Pixels: area(db)})
+	}
+
+	return s
+}
+
+// hexColor returns the color as #rrggbb, or #rrggbbaa when not opaque.
+func hexColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", n.R, n.G, n.B, n.A)
+}
+
+// params returns the name and value pairs kv formatted as "name=value".
+func params(kv ...interface{}) (list []string) {
+	for i := 0; i+1 < len(kv); i += 2 {
+		list = append(list, fmt.Sprintf("%s=%v", kv[i], kv[i+1]))
+	}
+	return
+}
+
+// percent returns the fraction f as a percentage.
+func percent(f float64) string {
+	return fmt.Sprintf("%g%%", f*100)
+}
+
+// typeName returns the unqualified type name of v, such as "ExifTool".
+func typeName(v interface{}) string {
+	name := fmt.Sprintf("%T", v)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// compressionName returns the name of the png compression level.
+func compressionName(l png.CompressionLevel) string {
+	switch l {
+	case png.NoCompression:
+		return "none"
+	case png.BestSpeed:
+		return "fast"
+	case png.BestCompression:
+		return "best"
+	default:
+		return "default"
+	}
+}
+
+// String returns the stage name and its parameters.
+func (s Stage) String() string {
+	if len(s.Params) == 0 {
+		return s.Name
+	}
+	return s.Name + " " + strings.Join(s.Params, " ")
+}
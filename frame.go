@@ -0,0 +1,86 @@
+package letterbox
+
+import (
+	"fmt"
+	"image"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// FrameProcessor letterboxes a stream of decoded frames of consistent
+// dimensions, such as a live video feed, computing the layout once and
+// reusing its buffers across frames. Kernel resamplers precompute their
+// weights once but allocate scratch space per frame, which the "bilinear"
+// and "nearest" resamplers avoid.
+type FrameProcessor struct {
+	p      *Processor
+	t      target
+	size   image.Point
+	sr     image.Rectangle
+	dr     image.Rectangle
+	scaler xdraw.Scaler
+	mask   *image.Alpha
+	canvas *image.RGBA
+	dst    *image.RGBA
+}
+
+// NewFrameProcessor returns a frame processor with the given options. Only
+// the first aspect ratio, or that of the orientation of the first frame, is
+// used, and smart gravity is computed from the first frame. Options
+// concerning files are ignored.
+func NewFrameProcessor(options ...Option) (*FrameProcessor, error) {
+	p, err := New("", options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FrameProcessor{p: p}, nil
+}
+
+// Process letterboxes the frame. The returned image is reused by the next
+// call, so it must be consumed or copied beforehand.
+func (f *FrameProcessor) Process(frame image.Image) (*image.RGBA, error) {
+	r := frame.Bounds()
+
+	if f.dst == nil {
+		f.init(frame)
+	}
+
+	if r.Size() != f.size {
+		return nil, fmt.Errorf("frame dimensions changed from %dx%d to %dx%d", f.size.X, f.size.Y, r.Dx(), r.Dy())
+	}
+
+	copy(f.dst.Pix, f.canvas.Pix)
+	f.p.paint(f.dst, f.dr, frame, f.sr.Add(r.Min), f.scaler, f.mask, !opaque(frame))
+	return f.dst, nil
+}
+
+// init computes the layout from the first frame and allocates the buffers,
+// pre-rendering the background and effects.
+func (f *FrameProcessor) init(frame image.Image) {
+	p := f.p
+	r := frame.Bounds()
+	f.size = r.Size()
+	f.t = p.orientedTargets(f.size)[0]
+
+	// region relative to the frame origin, as frames may differ in origin
+	f.sr = p.region(frame, r, nil, f.t).Sub(r.Min)
+
+	var db image.Rectangle
+	db, f.dr = p.layout(f.sr, f.t, p.focus(frame, f.sr.Add(r.Min)))
+
+	if p.radius > 0 {
+		f.mask = roundedMask(f.dr, p.radius)
+	}
+
+	// kernels precompute their weights for the fixed dimensions
+	f.scaler = p.resampler
+	if k, ok := p.resampler.(*xdraw.Kernel); ok {
+		f.scaler = k.NewScaler(f.dr.Dx(), f.dr.Dy(), f.sr.Dx(), f.sr.Dy())
+	}
+
+	f.canvas = image.NewRGBA(db)
+	// filled beneath the source as well, for frames with transparency
+	p.fill(f.canvas, f.dr, true)
+	f.dst = image.NewRGBA(db)
+}
@@ -0,0 +1,181 @@
+package letterbox
+
+import (
+	"image"
+	"math"
+)
+
+// centered returns a rect with rect s centered in rect d. An odd remainder
+// is placed at the bottom and right, or the top and left when rounding up.
+func centered(s, d image.Rectangle, rule string) image.Rectangle {
+	x := d.Min.X + half(d.Dx()-s.Dx(), rule)
+	y := d.Min.Y + half(d.Dy()-s.Dy(), rule)
+	return image.Rect(x, y, x+s.Dx(), y+s.Dy())
+}
+
+// half returns n halved by the rounding rule.
+func half(n int, rule string) int {
+	if rule == "ceil" {
+		return int(math.Ceil(float64(n) / 2))
+	}
+	return int(math.Floor(float64(n) / 2))
+}
+
+// epsilon absorbs floating point error, such as 1600 / (16 / 9) being
+// slightly less than 900, before rounding.
+const epsilon = 1e-6
+
+// rounded returns n rounded by the rule: "floor", "ceil", or "even" which
+// rounds up to the nearest even number.
+func rounded(n float64, rule string) int {
+	switch rule {
+	case "ceil":
+		return int(math.Ceil(n - epsilon))
+	case "even":
+		return 2 * int(math.Ceil(n/2-epsilon))
+	default:
+		return int(math.Floor(n + epsilon))
+	}
+}
+
+// anchors are the relative placements of gravities other than center and smart.
+var anchors = map[string]point{
+	"top":          {0.5, 0},
+	"bottom":       {0.5, 1},
+	"left":         {0, 0.5},
+	"right":        {1, 0.5},
+	"top-left":     {0, 0},
+	"top-right":    {1, 0},
+	"bottom-left":  {0, 1},
+	"bottom-right": {1, 1},
+}
+
+// anchored returns a rect with rect s placed in rect d at the relative anchor,
+// where 0 aligns the edges at the start and 1 at the end.
+func anchored(s, d image.Rectangle, a point) image.Rectangle {
+	x := d.Min.X + int(math.Round(float64(d.Dx()-s.Dx())*a.X))
+	y := d.Min.Y + int(math.Round(float64(d.Dy()-s.Dy())*a.Y))
+	return image.Rect(x, y, x+s.Dx(), y+s.Dy())
+}
+
+// contained returns rect r moved within rect d, along each axis it fits.
+func contained(r, d image.Rectangle) image.Rectangle {
+	var p image.Point
+
+	if r.Dx() <= d.Dx() {
+		p.X = min(max(r.Min.X, d.Min.X), d.Max.X-r.Dx()) - r.Min.X
+	}
+
+	if r.Dy() <= d.Dy() {
+		p.Y = min(max(r.Min.Y, d.Min.Y), d.Max.Y-r.Dy()) - r.Min.Y
+	}
+
+	return r.Add(p)
+}
+
+// inset returns rect r inset by the bars b of rect s, scaled by the size of r relative to s.
+func inset(r, s image.Rectangle, b Bars) image.Rectangle {
+	sx := float64(r.Dx()) / float64(s.Dx())
+	sy := float64(r.Dy()) / float64(s.Dy())
+	return image.Rect(
+		r.Min.X+int(math.Round(float64(b.Left)*sx)),
+		r.Min.Y+int(math.Round(float64(b.Top)*sy)),
+		r.Max.X-int(math.Round(float64(b.Right)*sx)),
+		r.Max.Y-int(math.Round(float64(b.Bottom)*sy)))
+}
+
+// bars returns the size of the bars surrounding rect r in rect d.
+func bars(d, r image.Rectangle) Bars {
+	return Bars{
+		Top:    r.Min.Y - d.Min.Y,
+		Right:  d.Max.X - r.Max.X,
+		Bottom: d.Max.Y - r.Max.Y,
+		Left:   r.Min.X - d.Min.X,
+	}
+}
+
+// cropAspect returns rect r center-cropped to the aspect ratio, at least a
+// pixel wide and tall.
+func cropAspect(r image.Rectangle, aspect float64) image.Rectangle {
+	w := float64(r.Dx())
+	h := float64(r.Dy())
+
+	if w/h > aspect {
+		n := max(1, int(math.Round(h*aspect)))
+		x := r.Min.X + (r.Dx()-n)/2
+		return image.Rect(x, r.Min.Y, x+n, r.Max.Y)
+	}
+
+	n := max(1, int(math.Round(w/aspect)))
+	y := r.Min.Y + (r.Dy()-n)/2
+	return image.Rect(r.Min.X, y, r.Max.X, y+n)
+}
+
+// fit returns rect r scaled to fit within w and h, only scaling up when enabled,
+// at least a pixel wide and tall.
+func fit(r image.Rectangle, w, h float64, upscale bool) image.Rectangle {
+	scale := math.Min(w/float64(r.Dx()), h/float64(r.Dy()))
+	if scale == 1 || (scale > 1 && !upscale) {
+		return r
+	}
+
+	return image.Rect(0, 0,
+		max(1, int(math.Round(float64(r.Dx())*scale))),
+		max(1, int(math.Round(float64(r.Dy())*scale))))
+}
+
+// focused returns a rect with rect s placed in rect d so that the focus point
+// of s is as close to the center of d as possible while s remains within d.
+func focused(s, d image.Rectangle, focus point) image.Rectangle {
+	x := offset(s.Dx(), d.Dx(), focus.X)
+	y := offset(s.Dy(), d.Dy(), focus.Y)
+	return image.Rect(x, y, x+s.Dx(), y+s.Dy())
+}
+
+// offset returns the offset of a span of length s within d centering the focus.
+func offset(s, d int, focus float64) int {
+	if s >= d {
+		return (d - s) / 2
+	}
+
+	n := int(math.Round(float64(d)/2 - focus*float64(s)))
+	return min(max(n, 0), d-s)
+}
+
+// padding returns a rect with padding applied.
+func padding(r image.Rectangle, padding float64, rule string) image.Rectangle {
+	w := float64(r.Max.X)
+	h := float64(r.Max.Y)
+	return image.Rect(0, 0, rounded(w+(w*padding), rule), rounded(h+(h*padding), rule))
+}
+
+// aspect returns a rect with aspect ratio applied, adding height (letterbox)
+// when r is wider than the aspect ratio, or width (pillarbox) when it is taller.
+func aspect(r image.Rectangle, aspect float64, rule string) image.Rectangle {
+	w := float64(r.Max.X)
+	h := float64(r.Max.Y)
+
+	if w/h > aspect {
+		h = w / aspect
+	} else {
+		w = h * aspect
+	}
+
+	return image.Rect(0, 0, rounded(w, rule), rounded(h, rule))
+}
+
+// outside returns the rects of r which lie outside of rect d, above, below,
+// left and right of it.
+func outside(r, d image.Rectangle) []image.Rectangle {
+	d = d.Intersect(r)
+	if d.Empty() {
+		return []image.Rectangle{r}
+	}
+
+	return []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, d.Min.Y),
+		image.Rect(r.Min.X, d.Max.Y, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, d.Min.Y, d.Min.X, d.Max.Y),
+		image.Rect(d.Max.X, d.Min.Y, r.Max.X, d.Max.Y),
+	}
+}
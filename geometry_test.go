@@ -0,0 +1,286 @@
+package letterbox
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestAspect(t *testing.T) {
+	cases := []struct {
+		name   string
+		r      image.Rectangle
+		aspect float64
+		want   image.Rectangle
+	}{
+		{"exact fit", image.Rect(0, 0, 1920, 1080), 16.0 / 9, image.Rect(0, 0, 1920, 1080)},
+		{"exact fit inexact ratio", image.Rect(0, 0, 1600, 900), 16.0 / 9, image.Rect(0, 0, 1600, 900)},
+		{"letterbox", image.Rect(0, 0, 1920, 800), 16.0 / 9, image.Rect(0, 0, 1920, 1080)},
+		{"pillarbox", image.Rect(0, 0, 1080, 1080), 16.0 / 9, image.Rect(0, 0, 1920, 1080)},
+		{"square", image.Rect(0, 0, 1000, 1000), 1, image.Rect(0, 0, 1000, 1000)},
+		{"1px", image.Rect(0, 0, 1, 1), 1, image.Rect(0, 0, 1, 1)},
+		{"1px wide", image.Rect(0, 0, 1, 1), 16.0 / 9, image.Rect(0, 0, 1, 1)},
+		{"1px tall", image.Rect(0, 0, 1, 1000), 1, image.Rect(0, 0, 1000, 1000)},
+		{"extreme panorama", image.Rect(0, 0, 10000, 10), 1, image.Rect(0, 0, 10000, 10000)},
+		{"extreme tall", image.Rect(0, 0, 10, 10000), 16.0 / 9, image.Rect(0, 0, 17777, 10000)},
+		{"extreme ratio", image.Rect(0, 0, 1000, 1000), 100, image.Rect(0, 0, 100000, 1000)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := aspect(c.r, c.aspect, "floor")
+			if got != c.want {
+				t.Errorf("aspect(%v, %v) = %v, want %v", c.r, c.aspect, got, c.want)
+			}
+		})
+	}
+}
+
+func TestFit(t *testing.T) {
+	cases := []struct {
+		name    string
+		r       image.Rectangle
+		w, h    float64
+		upscale bool
+		want    image.Rectangle
+	}{
+		{"downscale", image.Rect(0, 0, 4000, 3000), 1920, 1080, false, image.Rect(0, 0, 1440, 1080)},
+		{"exact fit", image.Rect(0, 0, 1920, 1080), 1920, 1080, false, image.Rect(0, 0, 1920, 1080)},
+		{"exact fit offset", image.Rect(10, 10, 1930, 1090), 1920, 1080, true, image.Rect(10, 10, 1930, 1090)},
+		{"no upscale", image.Rect(0, 0, 100, 50), 1920, 1080, false, image.Rect(0, 0, 100, 50)},
+		{"upscale", image.Rect(0, 0, 100, 50), 1920, 1080, true, image.Rect(0, 0, 1920, 960)},
+		{"1px upscale", image.Rect(0, 0, 1, 1), 1920, 1080, true, image.Rect(0, 0, 1080, 1080)},
+		{"1px wide", image.Rect(0, 0, 1, 10000), 100, 100, false, image.Rect(0, 0, 1, 100)},
+		{"1px tall", image.Rect(0, 0, 10000, 1), 100, 100, false, image.Rect(0, 0, 100, 1)},
+		{"extreme panorama", image.Rect(0, 0, 10000, 10), 1920, 1080, false, image.Rect(0, 0, 1920, 2)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := fit(c.r, c.w, c.h, c.upscale)
+			if got != c.want {
+				t.Errorf("fit(%v, %v, %v, %v) = %v, want %v", c.r, c.w, c.h, c.upscale, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCropAspect(t *testing.T) {
+	cases := []struct {
+		name   string
+		r      image.Rectangle
+		aspect float64
+		want   image.Rectangle
+	}{
+		{"exact fit", image.Rect(0, 0, 1920, 1080), 16.0 / 9, image.Rect(0, 0, 1920, 1080)},
+		{"wide", image.Rect(0, 0, 2000, 1000), 1, image.Rect(500, 0, 1500, 1000)},
+		{"tall", image.Rect(0, 0, 1000, 2000), 1, image.Rect(0, 500, 1000, 1500)},
+		{"offset", image.Rect(10, 10, 30, 20), 1, image.Rect(15, 10, 25, 20)},
+		{"odd", image.Rect(0, 0, 1001, 1000), 1, image.Rect(0, 0, 1000, 1000)},
+		{"1px", image.Rect(0, 0, 1, 1), 16.0 / 9, image.Rect(0, 0, 1, 1)},
+		{"1px extreme ratio", image.Rect(0, 0, 1, 1), 0.01, image.Rect(0, 0, 1, 1)},
+		{"extreme panorama", image.Rect(0, 0, 10000, 10), 1, image.Rect(4995, 0, 5005, 10)},
+		{"extreme tall", image.Rect(0, 0, 10, 10000), 16.0 / 9, image.Rect(0, 4997, 10, 5003)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := cropAspect(c.r, c.aspect)
+			if got != c.want {
+				t.Errorf("cropAspect(%v, %v) = %v, want %v", c.r, c.aspect, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCentered(t *testing.T) {
+	cases := []struct {
+		name string
+		s, d image.Rectangle
+		want image.Rectangle
+	}{
+		{"exact fit", image.Rect(0, 0, 10, 10), image.Rect(0, 0, 10, 10), image.Rect(0, 0, 10, 10)},
+		{"even", image.Rect(0, 0, 4, 2), image.Rect(0, 0, 10, 10), image.Rect(3, 4, 7, 6)},
+		{"offset", image.Rect(0, 0, 4, 2), image.Rect(5, 5, 15, 15), image.Rect(8, 9, 12, 11)},
+		{"larger", image.Rect(0, 0, 14, 10), image.Rect(0, 0, 10, 10), image.Rect(-2, 0, 12, 10)},
+		{"1px", image.Rect(0, 0, 1, 1), image.Rect(0, 0, 3, 3), image.Rect(1, 1, 2, 2)},
+		{"extreme panorama", image.Rect(0, 0, 10000, 10), image.Rect(0, 0, 10000, 10000), image.Rect(0, 4995, 10000, 5005)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := centered(c.s, c.d, "floor")
+			if got != c.want {
+				t.Errorf("centered(%v, %v) = %v, want %v", c.s, c.d, got, c.want)
+			}
+		})
+	}
+}
+
+func TestContained(t *testing.T) {
+	d := image.Rect(0, 0, 20, 20)
+
+	cases := []struct {
+		name string
+		r    image.Rectangle
+		want image.Rectangle
+	}{
+		{"inside", image.Rect(5, 5, 10, 10), image.Rect(5, 5, 10, 10)},
+		{"exact fit", image.Rect(0, 0, 20, 20), image.Rect(0, 0, 20, 20)},
+		{"before", image.Rect(-5, 0, 5, 10), image.Rect(0, 0, 10, 10)},
+		{"after", image.Rect(15, 15, 25, 25), image.Rect(10, 10, 20, 20)},
+		{"wider", image.Rect(-5, -5, 25, 5), image.Rect(-5, 0, 25, 10)},
+		{"1px", image.Rect(20, 20, 21, 21), image.Rect(19, 19, 20, 20)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := contained(c.r, d)
+			if got != c.want {
+				t.Errorf("contained(%v, %v) = %v, want %v", c.r, d, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRounded(t *testing.T) {
+	cases := []struct {
+		n                 float64
+		floor, ceil, even int
+	}{
+		{900, 900, 900, 900},
+		{1600 / (16.0 / 9), 900, 900, 900},
+		{1079.5, 1079, 1080, 1080},
+		{1081, 1081, 1081, 1082},
+		{562.5, 562, 563, 564},
+		{1, 1, 1, 2},
+		{0.4, 0, 1, 2},
+	}
+
+	for _, c := range cases {
+		for rule, want := range map[string]int{"floor": c.floor, "ceil": c.ceil, "even": c.even} {
+			if got := rounded(c.n, rule); got != want {
+				t.Errorf("rounded(%v, %s) = %d, want %d", c.n, rule, got, want)
+			}
+		}
+	}
+}
+
+func TestHalf(t *testing.T) {
+	cases := []struct {
+		n                 int
+		floor, ceil, even int
+	}{
+		{4, 2, 2, 2},
+		{5, 2, 3, 2},
+		{1, 0, 1, 0},
+		{0, 0, 0, 0},
+		{-1, -1, 0, -1},
+		{-5, -3, -2, -3},
+	}
+
+	for _, c := range cases {
+		for rule, want := range map[string]int{"floor": c.floor, "ceil": c.ceil, "even": c.even} {
+			if got := half(c.n, rule); got != want {
+				t.Errorf("half(%d, %s) = %d, want %d", c.n, rule, got, want)
+			}
+		}
+	}
+}
+
+func TestCenteredRounding(t *testing.T) {
+	cases := []struct {
+		name        string
+		s, d        image.Rectangle
+		floor, ceil image.Rectangle
+	}{
+		{"odd remainder", image.Rect(0, 0, 3, 3), image.Rect(0, 0, 10, 10), image.Rect(3, 3, 6, 6), image.Rect(4, 4, 7, 7)},
+		{"odd source", image.Rect(0, 0, 1920, 1079), image.Rect(0, 0, 1920, 1080), image.Rect(0, 0, 1920, 1079), image.Rect(0, 1, 1920, 1080)},
+		{"1px", image.Rect(0, 0, 1, 1), image.Rect(0, 0, 2, 2), image.Rect(0, 0, 1, 1), image.Rect(1, 1, 2, 2)},
+		{"larger odd", image.Rect(0, 0, 12, 11), image.Rect(0, 0, 9, 9), image.Rect(-2, -1, 10, 10), image.Rect(-1, -1, 11, 10)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// even rounds canvas dimensions, centering as floor
+			for rule, want := range map[string]image.Rectangle{"floor": c.floor, "ceil": c.ceil, "even": c.floor} {
+				if got := centered(c.s, c.d, rule); got != want {
+					t.Errorf("centered(%v, %v, %s) = %v, want %v", c.s, c.d, rule, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAspectRounding(t *testing.T) {
+	cases := []struct {
+		name              string
+		r                 image.Rectangle
+		aspect            float64
+		floor, ceil, even image.Point
+	}{
+		{"exact fit", image.Rect(0, 0, 1920, 1080), 16.0 / 9, image.Pt(1920, 1080), image.Pt(1920, 1080), image.Pt(1920, 1080)},
+		{"odd letterbox", image.Rect(0, 0, 1001, 563), 16.0 / 9, image.Pt(1001, 563), image.Pt(1001, 564), image.Pt(1002, 564)},
+		{"odd pillarbox", image.Rect(0, 0, 1080, 1079), 16.0 / 9, image.Pt(1918, 1079), image.Pt(1919, 1079), image.Pt(1920, 1080)},
+		{"odd square", image.Rect(0, 0, 999, 999), 1, image.Pt(999, 999), image.Pt(999, 999), image.Pt(1000, 1000)},
+		{"3x1", image.Rect(0, 0, 3, 1), 16.0 / 9, image.Pt(3, 1), image.Pt(3, 2), image.Pt(4, 2)},
+		{"1px", image.Rect(0, 0, 1, 1), 16.0 / 9, image.Pt(1, 1), image.Pt(2, 1), image.Pt(2, 2)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for rule, want := range map[string]image.Point{"floor": c.floor, "ceil": c.ceil, "even": c.even} {
+				if got := aspect(c.r, c.aspect, rule).Size(); got != want {
+					t.Errorf("aspect(%v, %v, %s) = %v, want %v", c.r, c.aspect, rule, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAspect(t *testing.T) {
+	cases := []struct {
+		s    string
+		want float64
+		err  bool
+	}{
+		{"16:9", 16.0 / 9, false},
+		{"1920x1080", 16.0 / 9, false},
+		{"1920X1080", 16.0 / 9, false},
+		{"1.777", 1.777, false},
+		{"2.39:1", 2.39, false},
+		{" 4:3 ", 4.0 / 3, false},
+		{"9:16", 9.0 / 16, false},
+		{"", 0, true},
+		{"16:", 0, true},
+		{":9", 0, true},
+		{"16:9:1", 0, true},
+		{"16/9", 0, true},
+		{"wide", 0, true},
+		{"0", 0, true},
+		{"16:0", 0, true},
+		{"-1.5", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.s, func(t *testing.T) {
+			got, err := parseAspect(c.s)
+			if c.err {
+				if err == nil {
+					t.Errorf("parseAspect(%q) = %v, want an error", c.s, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseAspect(%q): %v", c.s, err)
+			}
+
+			if math.Abs(got-c.want) > 1e-9 {
+				t.Errorf("parseAspect(%q) = %v, want %v", c.s, got, c.want)
+			}
+		})
+	}
+}
@@ -1,5 +1,9 @@
 module github.com/tj/letterbox
 
-go 1.12
+go 1.21
 
-require golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
+require (
+	golang.org/x/image v0.18.0
+	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
+	gopkg.in/yaml.v3 v3.0.1
+)
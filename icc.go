@@ -0,0 +1,154 @@
+package letterbox
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// iccPrefix is the prefix of APP2 segments holding ICC profile chunks.
+var iccPrefix = []byte("ICC_PROFILE\x00")
+
+// iccTag is the TIFF InterColorProfile tag.
+const iccTag = 34675
+
+// isICC returns true if the jpeg segment s holds an ICC profile chunk.
+func isICC(s []byte) bool {
+	return s[1] == 0xE2 && bytes.HasPrefix(s[4:], iccPrefix)
+}
+
+// iccProfile returns the ICC profile reassembled from the chunks of the jpeg
+// segments by their sequence numbers, or nil when there are none.
+func iccProfile(segments [][]byte) ([]byte, error) {
+	chunks := make(map[int][]byte)
+	for _, s := range segments {
+		if !isICC(s) {
+			continue
+		}
+
+		b := s[4+len(iccPrefix):]
+		if len(b) < 2 {
+			return nil, errors.New("invalid icc chunk")
+		}
+		chunks[int(b[0])] = b[2:]
+	}
+
+	var profile []byte
+	for i := 1; i <= len(chunks); i++ {
+		c, ok := chunks[i]
+		if !ok {
+			return nil, fmt.Errorf("missing icc chunk %d", i)
+		}
+		profile = append(profile, c...)
+	}
+
+	return profile, nil
+}
+
+// isPNG returns true if b starts with the png signature.
+func isPNG(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n"))
+}
+
+// isTIFF returns true if b starts with a tiff header.
+func isTIFF(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("II*\x00")) || bytes.HasPrefix(b, []byte("MM\x00*"))
+}
+
+// embedPNG returns the png b with the ICC profile in an iCCP chunk following
+// its IHDR chunk.
+func embedPNG(b, profile []byte) ([]byte, error) {
+	// signature and IHDR
+	const ihdr = 8 + 8 + 13 + 4
+	if len(b) < ihdr || string(b[12:16]) != "IHDR" {
+		return nil, errors.New("invalid png header")
+	}
+
+	var data bytes.Buffer
+	data.WriteString("ICC Profile\x00\x00")
+	z := zlib.NewWriter(&data)
+	z.Write(profile)
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(b) + data.Len() + 12)
+	buf.Write(b[:ihdr])
+	binary.Write(&buf, binary.BigEndian, uint32(data.Len()))
+	chunk := append([]byte("iCCP"), data.Bytes()...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	buf.Write(b[ihdr:])
+
+	return buf.Bytes(), nil
+}
+
+// embedTIFF returns the tiff b with the ICC profile in the InterColorProfile
+// tag of its first IFD. The profile and a copy of the IFD with the tag are
+// appended, leaving the data the IFD references in place.
+func embedTIFF(b, profile []byte) ([]byte, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	if b[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	ifd := int(order.Uint32(b[4:]))
+	if ifd+2 > len(b) {
+		return nil, errors.New("invalid tiff IFD offset")
+	}
+
+	n := int(order.Uint16(b[ifd:]))
+	end := ifd + 2 + 12*n
+	if end+4 > len(b) {
+		return nil, errors.New("invalid tiff IFD")
+	}
+
+	// entries sorted by tag, replacing any profile
+	var entries [][]byte
+	for i := 0; i < n; i++ {
+		e := b[ifd+2+12*i : ifd+2+12*(i+1)]
+		if order.Uint16(e) != iccTag {
+			entries = append(entries, e)
+		}
+	}
+
+	buf := bytes.NewBuffer(append([]byte{}, b...))
+	if buf.Len()%2 == 1 {
+		buf.WriteByte(0)
+	}
+
+	offset := buf.Len()
+	buf.Write(profile)
+	if buf.Len()%2 == 1 {
+		buf.WriteByte(0)
+	}
+
+	e := make([]byte, 12)
+	order.PutUint16(e, iccTag)
+	order.PutUint16(e[2:], 7)
+	order.PutUint32(e[4:], uint32(len(profile)))
+	order.PutUint32(e[8:], uint32(offset))
+	entries = append(entries, e)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return order.Uint16(entries[i]) < order.Uint16(entries[j])
+	})
+
+	// the IFD, pointed to by the header
+	ifd = buf.Len()
+	var count [2]byte
+	order.PutUint16(count[:], uint16(len(entries)))
+	buf.Write(count[:])
+	for _, e := range entries {
+		buf.Write(e)
+	}
+	buf.Write(b[end : end+4])
+
+	out := buf.Bytes()
+	order.PutUint32(out[4:], uint32(ifd))
+	return out, nil
+}
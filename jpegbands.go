@@ -0,0 +1,159 @@
+package letterbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// bandPixels is the approximate number of pixels of each band encoded in
+// parallel, independent of the number of cores so outputs are reproducible.
+var bandPixels = 2 << 20
+
+// WithParallelEncode changes the number of output pixels from which jpeg
+// outputs are encoded in bands of MCU rows on multiple cores, separated by
+// restart markers, such as 50000000 for very large scans where encoding
+// dominates. Outputs are slightly larger, and decode identically to those
+// encoded serially. Defaults to 0 for serial encoding.
+func WithParallelEncode(pixels int) Option {
+	return func(p *Processor) error {
+		if pixels < 0 {
+			return fmt.Errorf("invalid parallel encode pixels %d, must be positive", pixels)
+		}
+
+		p.parallelEncode = pixels
+		return nil
+	}
+}
+
+// encodeBands writes img as a jpeg to w, encoding bands of MCU rows in
+// parallel. As each band is encoded with the same tables and its entropy-coded
+// data starts with reset DC predictors and ends byte-aligned, the bands are
+// stitched behind the header of the first with restart markers between them.
+func encodeBands(w io.Writer, img image.Image, o *jpeg.Options) error {
+	sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+
+	// beyond the jpeg limits the encoder errors
+	b := img.Bounds()
+	if !ok || b.Empty() || b.Dx() > 0xffff || b.Dy() > 0xffff {
+		return jpeg.Encode(w, img, o)
+	}
+
+	// 4:2:0 MCUs, or 8x8 for grayscale
+	mcu := 16
+	if _, ok := img.(*image.Gray); ok {
+		mcu = 8
+	}
+
+	// bands of whole MCU rows, within the maximum restart interval
+	cols := (b.Dx() + mcu - 1) / mcu
+	rows := max(1, bandPixels/(b.Dx()*mcu))
+	rows = min(rows, 0xffff/cols)
+	height := rows * mcu
+
+	var bands []image.Rectangle
+	for y := b.Min.Y; y < b.Max.Y; y += height {
+		bands = append(bands, image.Rect(b.Min.X, y, b.Max.X, min(y+height, b.Max.Y)))
+	}
+
+	if len(bands) == 1 {
+		return jpeg.Encode(w, img, o)
+	}
+
+	// encode
+	encoded := make([][]byte, len(bands))
+	var g errgroup.Group
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for i, r := range bands {
+		i, r := i, r
+		g.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, sub.SubImage(r), o); err != nil {
+				return err
+			}
+			encoded[i] = buf.Bytes()
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	// header of the first band, at the full height with a restart interval
+	header, _, err := splitScan(encoded[0])
+	if err != nil {
+		return err
+	}
+
+	dri := []byte{0xFF, 0xDD, 0, 4, 0, 0}
+	binary.BigEndian.PutUint16(dri[4:], uint16(cols*rows))
+
+	var out bytes.Buffer
+	out.Write([]byte{0xFF, 0xD8})
+	for len(header) > 0 {
+		n := 2 + int(binary.BigEndian.Uint16(header[2:]))
+		switch header[1] {
+		case 0xC0:
+			sof := append([]byte(nil), header[:n]...)
+			binary.BigEndian.PutUint16(sof[5:], uint16(b.Dy()))
+			out.Write(sof)
+		case 0xDA:
+			out.Write(dri)
+			out.Write(header[:n])
+		default:
+			out.Write(header[:n])
+		}
+		header = header[n:]
+	}
+
+	// entropy-coded data
+	for i, e := range encoded {
+		_, data, err := splitScan(e)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			out.Write([]byte{0xFF, 0xD0 + byte((i-1)%8)})
+		}
+		out.Write(data)
+	}
+	out.Write([]byte{0xFF, 0xD9})
+
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// splitScan returns the segments following SOI up to and including the start
+// of scan, and the entropy-coded data of the single scan of the jpeg b.
+func splitScan(b []byte) (header, data []byte, err error) {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 || b[len(b)-2] != 0xFF || b[len(b)-1] != 0xD9 {
+		return nil, nil, fmt.Errorf("invalid jpeg band")
+	}
+
+	for i := 2; i+4 <= len(b); {
+		if b[i] != 0xFF {
+			return nil, nil, fmt.Errorf("invalid jpeg band segment")
+		}
+
+		n := 2 + int(binary.BigEndian.Uint16(b[i+2:]))
+		if b[i+1] == 0xDA {
+			return b[2 : i+n], b[i+n : len(b)-2], nil
+		}
+		i += n
+	}
+
+	return nil, nil, fmt.Errorf("missing jpeg band scan")
+}
@@ -0,0 +1,56 @@
+package letterbox
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"testing"
+)
+
+func TestEncodeBands(t *testing.T) {
+	prev := bandPixels
+	defer func() { bandPixels = prev }()
+
+	for _, gray := range []bool{false, true} {
+		// a height which isn't a multiple of the MCU height
+		img := testPhoto(200, 150, gray)
+		bandPixels = 200 * 32
+
+		t.Run(fmt.Sprintf("gray=%v", gray), func(t *testing.T) {
+			o := &jpeg.Options{Quality: 85}
+
+			var b bytes.Buffer
+			if err := encodeBands(&b, img, o); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := parseFrame(b.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if f.restart == 0 || f.width != 200 || f.height != 150 {
+				t.Fatalf("restart interval %d size %dx%d, want bands of 200x150", f.restart, f.width, f.height)
+			}
+
+			got, err := jpeg.Decode(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var serial bytes.Buffer
+			if err := jpeg.Encode(&serial, img, o); err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := jpeg.Decode(&serial)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p, ok := firstDifference(got, want); ok {
+				t.Fatalf("mismatch at %v: %v, want %v", p, got.At(p.X, p.Y), want.At(p.X, p.Y))
+			}
+		})
+	}
+}
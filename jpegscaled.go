@@ -0,0 +1,553 @@
+package letterbox
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// errScaledUnsupported is returned for jpegs which can't be decoded scaled,
+// such as progressive, 12-bit, CMYK or multi-scan jpegs.
+var errScaledUnsupported = errors.New("unsupported for scaled decoding")
+
+// unzig maps the zig-zag order of coefficients to their natural order.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// idctScaled holds the basis of the reduced inverse DCTs producing 1, 2 and
+// 4 pixels per block side, indexed by pixel and frequency. The low
+// frequencies of a block are those of the block downscaled, so that the m
+// point inverse DCT of them is the block at 1/(8/m) scale.
+var idctScaled = func() (t [5][4][4]float32) {
+	for _, m := range []int{1, 2, 4} {
+		for x := 0; x < m; x++ {
+			for u := 0; u < m; u++ {
+				c := 1.0
+				if u == 0 {
+					c = 1 / math.Sqrt2
+				}
+				t[m][x][u] = float32(c / 2 * math.Cos(float64(2*x+1)*float64(u)*math.Pi/float64(2*m)))
+			}
+		}
+	}
+	return
+}()
+
+// huffman is a jpeg Huffman table.
+type huffman struct {
+	// lookup is the length and value of codes of up to 8 bits by their
+	// leading bits, as length<<8 | value.
+	lookup [256]uint16
+
+	// codes of 9 to 16 bits by length.
+	maxcode [17]int32
+	mincode [17]int32
+	valptr  [17]int32
+	vals    []byte
+}
+
+// newHuffman returns the table of the code counts by length and values.
+func newHuffman(counts []byte, vals []byte) (*huffman, error) {
+	h := &huffman{vals: vals}
+
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		h.valptr[l] = k
+		h.mincode[l] = code
+		h.maxcode[l] = -1
+
+		for i := 0; i < int(counts[l-1]); i++ {
+			if int(k) >= len(vals) || code >= 1<<l {
+				return nil, errors.New("invalid huffman table")
+			}
+
+			if l <= 8 {
+				for j := code << (8 - l); j < (code+1)<<(8-l); j++ {
+					h.lookup[j] = uint16(l)<<8 | uint16(vals[k])
+				}
+			}
+
+			h.maxcode[l] = code
+			code++
+			k++
+		}
+
+		code <<= 1
+	}
+
+	return h, nil
+}
+
+// scanBits reads the bits of entropy-coded data, removing stuffed bytes and
+// reading zeros at markers and the end of data.
+type scanBits struct {
+	data []byte
+	i    int
+
+	// acc holds n bits read ahead, of which the last pad are zeros past the
+	// end of the segment.
+	acc uint64
+	n   uint
+	pad uint
+
+	// marker is true when a marker ends the segment.
+	marker bool
+}
+
+// fill reads ahead at least 56 bits.
+func (b *scanBits) fill() {
+	for b.n <= 56 {
+		var c byte
+		switch {
+		case b.marker || b.i >= len(b.data):
+			b.pad += 8
+		case b.data[b.i] != 0xFF:
+			c = b.data[b.i]
+			b.i++
+		case b.i+1 < len(b.data) && b.data[b.i+1] == 0x00:
+			c = 0xFF
+			b.i += 2
+		default:
+			b.marker = true
+			b.pad += 8
+		}
+
+		b.acc = b.acc<<8 | uint64(c)
+		b.n += 8
+	}
+}
+
+// consume discards k bits, failing when they run past the segment.
+func (b *scanBits) consume(k uint) error {
+	b.n -= k
+	if b.n < b.pad {
+		return errors.New("unexpected end of segment")
+	}
+	return nil
+}
+
+// decode returns the next Huffman-coded value.
+func (b *scanBits) decode(h *huffman) (byte, error) {
+	if b.n < 16 {
+		b.fill()
+	}
+
+	if v := h.lookup[b.acc>>(b.n-8)&0xFF]; v != 0 {
+		return byte(v), b.consume(uint(v >> 8))
+	}
+
+	code := int32(b.acc >> (b.n - 16) & 0xFFFF)
+	for l := 9; l <= 16; l++ {
+		c := code >> (16 - l)
+		if c <= h.maxcode[l] {
+			return h.vals[h.valptr[l]+c-h.mincode[l]], b.consume(uint(l))
+		}
+	}
+
+	return 0, errors.New("invalid huffman code")
+}
+
+// receive returns the next s bits as a signed coefficient.
+func (b *scanBits) receive(s uint) (int32, error) {
+	if s == 0 {
+		return 0, nil
+	}
+
+	if b.n < s {
+		b.fill()
+	}
+
+	v := int32(b.acc >> (b.n - s) & (1<<s - 1))
+	if v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v, b.consume(s)
+}
+
+// restart skips the restart marker ending the interval, resetting the bits.
+func (b *scanBits) restart() error {
+	b.acc, b.n, b.pad = 0, 0, 0
+	b.marker = false
+
+	for b.i < len(b.data) && b.data[b.i] == 0xFF {
+		b.i++
+	}
+
+	if b.i >= len(b.data) || b.data[b.i] < 0xD0 || b.data[b.i] > 0xD7 {
+		return errors.New("missing restart marker")
+	}
+
+	b.i++
+	return nil
+}
+
+// scaledComponent is a component of a frame.
+type scaledComponent struct {
+	id    byte
+	h, v  int
+	tq    int
+	dc    *huffman
+	ac    *huffman
+	pred  int32
+	plane []byte
+	step  int
+}
+
+// decodeScaled decodes the baseline jpeg data at 1/n scale, for n of 2, 4 or 8,
+// computing only the low frequencies of each block, as a reduced image with
+// the bounds of the jpeg. Gray and YCbCr jpegs of
+// a single interleaved scan are supported, and errScaledUnsupported is
+// returned for others. Decoding fails once ctx is done.
+func decodeScaled(ctx context.Context, data []byte, n int) (image.Image, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a jpeg")
+	}
+
+	var quant [4][64]int32
+	var dcTables, acTables [4]*huffman
+	var comps []scaledComponent
+	var width, height, restart int
+	var adobe, transform bool
+
+	for i := 2; ; {
+		if i+4 > len(data) {
+			return nil, errors.New("missing scan")
+		}
+
+		if data[i] != 0xFF {
+			return nil, errors.New("invalid segment")
+		}
+
+		marker := data[i+1]
+		if marker == 0xFF {
+			i++
+			continue
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return nil, errors.New("truncated header")
+		}
+		seg := data[i+4 : i+2+size]
+		i += 2 + size
+
+		switch marker {
+		case 0xC0, 0xC1:
+			if len(seg) < 6 || len(seg) < 6+3*int(seg[5]) {
+				return nil, errors.New("invalid frame")
+			}
+
+			height = int(binary.BigEndian.Uint16(seg[1:]))
+			width = int(binary.BigEndian.Uint16(seg[3:]))
+			if seg[0] != 8 || width == 0 || height == 0 {
+				return nil, errScaledUnsupported
+			}
+
+			for c := 0; c < int(seg[5]); c++ {
+				s := seg[6+3*c:]
+				comps = append(comps, scaledComponent{id: s[0], h: int(s[1] >> 4), v: int(s[1] & 0xF), tq: int(s[2] & 3)})
+			}
+		case 0xC2, 0xC3, 0xC5, 0xC6, 0xC7, 0xC9, 0xCA, 0xCB, 0xCD, 0xCE, 0xCF:
+			return nil, errScaledUnsupported
+		case 0xC4:
+			for len(seg) > 0 {
+				if len(seg) < 17 {
+					return nil, errors.New("invalid huffman table")
+				}
+
+				total := 0
+				for _, c := range seg[1:17] {
+					total += int(c)
+				}
+				if len(seg) < 17+total {
+					return nil, errors.New("invalid huffman table")
+				}
+
+				h, err := newHuffman(seg[1:17], seg[17:17+total])
+				if err != nil {
+					return nil, err
+				}
+
+				if seg[0]>>4 == 0 {
+					dcTables[seg[0]&3] = h
+				} else {
+					acTables[seg[0]&3] = h
+				}
+				seg = seg[17+total:]
+			}
+		case 0xDB:
+			for len(seg) > 0 {
+				q := &quant[seg[0]&3]
+				if seg[0]>>4 == 0 {
+					if len(seg) < 65 {
+						return nil, errors.New("invalid quantization table")
+					}
+					for k := range q {
+						q[k] = int32(seg[1+k])
+					}
+					seg = seg[65:]
+				} else {
+					if len(seg) < 129 {
+						return nil, errors.New("invalid quantization table")
+					}
+					for k := range q {
+						q[k] = int32(binary.BigEndian.Uint16(seg[1+2*k:]))
+					}
+					seg = seg[129:]
+				}
+			}
+		case 0xDD:
+			if len(seg) >= 2 {
+				restart = int(binary.BigEndian.Uint16(seg))
+			}
+		case 0xEE:
+			if len(seg) >= 12 && string(seg[:5]) == "Adobe" {
+				adobe, transform = true, seg[11] != 0
+			}
+		case 0xDA:
+			if len(seg) < 1 || len(seg) < 4+2*int(seg[0]) {
+				return nil, errors.New("invalid scan")
+			}
+
+			// a single scan of every component
+			if len(comps) == 0 || int(seg[0]) != len(comps) {
+				return nil, errScaledUnsupported
+			}
+
+			// in the order of the frame
+			for s := range comps {
+				tables := seg[2+2*s]
+				if seg[1+2*s] != comps[s].id {
+					return nil, errScaledUnsupported
+				}
+				comps[s].dc, comps[s].ac = dcTables[tables>>4&3], acTables[tables&3]
+			}
+
+			if len(comps) == 3 && rgbComponents(comps, adobe, transform) {
+				return nil, errScaledUnsupported
+			}
+
+			return decodeScan(ctx, data[i:], comps, &quant, width, height, restart, n)
+		}
+	}
+}
+
+// rgbComponents returns true if the three components are RGB rather than YCbCr.
+func rgbComponents(comps []scaledComponent, adobe, transform bool) bool {
+	if adobe {
+		return !transform
+	}
+	return comps[0].id == 'R' && comps[1].id == 'G' && comps[2].id == 'B'
+}
+
+// decodeScan decodes the entropy-coded data of the scan at 1/n scale.
+func decodeScan(ctx context.Context, data []byte, comps []scaledComponent, quant *[4][64]int32, width, height, restart, n int) (image.Image, error) {
+	m := 8 / n
+
+	// a single component is a block per MCU whatever its sampling
+	if len(comps) == 1 {
+		comps[0].h, comps[0].v = 1, 1
+	}
+
+	hmax, vmax := 1, 1
+	for _, c := range comps {
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.dc == nil || c.ac == nil {
+			return nil, errors.New("invalid component")
+		}
+		hmax, vmax = max(hmax, c.h), max(vmax, c.v)
+	}
+
+	mx := (width + 8*hmax - 1) / (8 * hmax)
+	my := (height + 8*vmax - 1) / (8 * vmax)
+	r := image.Rect(0, 0, mx*hmax*m, my*vmax*m)
+	bounds := image.Rect(0, 0, (width+n-1)/n, (height+n-1)/n)
+
+	// planes of whole MCUs
+	var img image.Image
+	switch len(comps) {
+	case 1:
+		g := image.NewGray(r)
+		comps[0].plane, comps[0].step = g.Pix, g.Stride
+		img = g.SubImage(bounds)
+	case 3:
+		ratio, ok := subsampleRatio(comps)
+		if !ok {
+			return nil, errScaledUnsupported
+		}
+
+		y := image.NewYCbCr(r, ratio)
+		comps[0].plane, comps[0].step = y.Y, y.YStride
+		comps[1].plane, comps[1].step = y.Cb, y.CStride
+		comps[2].plane, comps[2].step = y.Cr, y.CStride
+		img = y.SubImage(bounds)
+	default:
+		return nil, errScaledUnsupported
+	}
+
+	b := &scanBits{data: data}
+	var coef [64]float32
+	var tmp [4][4]float32
+
+	for mcu, row := 0, 0; row < my; row++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		for col := 0; col < mx; col, mcu = col+1, mcu+1 {
+			if restart > 0 && mcu > 0 && mcu%restart == 0 {
+				if err := b.restart(); err != nil {
+					return nil, err
+				}
+				for i := range comps {
+					comps[i].pred = 0
+				}
+			}
+
+			for i := range comps {
+				c := &comps[i]
+				q := &quant[c.tq]
+
+				for by := 0; by < c.v; by++ {
+					for bx := 0; bx < c.h; bx++ {
+						if err := decodeBlock(b, c, q, &coef, m); err != nil {
+							return nil, fmt.Errorf("decoding block: %w", err)
+						}
+
+						// reduced inverse DCT of the low frequencies
+						t := &idctScaled[m]
+						for v := 0; v < m; v++ {
+							for x := 0; x < m; x++ {
+								var s float32
+								for u := 0; u < m; u++ {
+									s += t[x][u] * coef[v*8+u]
+								}
+								tmp[v][x] = s
+							}
+						}
+
+						x0 := (col*c.h + bx) * m
+						y0 := (row*c.v + by) * m
+						for y := 0; y < m; y++ {
+							p := c.plane[(y0+y)*c.step+x0:]
+							for x := 0; x < m; x++ {
+								var s float32
+								for v := 0; v < m; v++ {
+									s += t[y][v] * tmp[v][x]
+								}
+								p[x] = clampByte(s + 128.5)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return &reduced{Image: img, scale: n, bounds: image.Rect(0, 0, width, height)}, nil
+}
+
+// decodeBlock decodes the next block of the component, dequantizing the
+// coefficients of the low m×m frequencies into coef.
+func decodeBlock(b *scanBits, c *scaledComponent, q *[64]int32, coef *[64]float32, m int) error {
+	for v := 0; v < m; v++ {
+		for u := 0; u < m; u++ {
+			coef[v*8+u] = 0
+		}
+	}
+
+	// dc
+	s, err := b.decode(c.dc)
+	if err != nil {
+		return err
+	}
+	if s > 11 {
+		return errors.New("invalid dc coefficient")
+	}
+
+	d, err := b.receive(uint(s))
+	if err != nil {
+		return err
+	}
+	c.pred += d
+	coef[0] = float32(c.pred * q[0])
+
+	// ac
+	for k := 1; k < 64; k++ {
+		rs, err := b.decode(c.ac)
+		if err != nil {
+			return err
+		}
+
+		r, s := int(rs>>4), uint(rs&0xF)
+		if s == 0 {
+			if r != 15 {
+				break
+			}
+			k += 15
+			continue
+		}
+
+		k += r
+		if k > 63 {
+			return errors.New("invalid ac coefficient")
+		}
+
+		a, err := b.receive(s)
+		if err != nil {
+			return err
+		}
+
+		if z := unzig[k]; z%8 < m && z/8 < m {
+			coef[z] = float32(a * q[k])
+		}
+	}
+
+	return nil
+}
+
+// subsampleRatio returns the chroma subsampling ratio of the YCbCr components,
+// which image.YCbCr supports when the chroma components aren't subsampled.
+func subsampleRatio(comps []scaledComponent) (image.YCbCrSubsampleRatio, bool) {
+	if comps[1].h != 1 || comps[1].v != 1 || comps[2].h != 1 || comps[2].v != 1 {
+		return 0, false
+	}
+
+	switch image.Pt(comps[0].h, comps[0].v) {
+	case image.Pt(1, 1):
+		return image.YCbCrSubsampleRatio444, true
+	case image.Pt(2, 1):
+		return image.YCbCrSubsampleRatio422, true
+	case image.Pt(2, 2):
+		return image.YCbCrSubsampleRatio420, true
+	case image.Pt(1, 2):
+		return image.YCbCrSubsampleRatio440, true
+	case image.Pt(4, 1):
+		return image.YCbCrSubsampleRatio411, true
+	case image.Pt(4, 2):
+		return image.YCbCrSubsampleRatio410, true
+	}
+
+	return 0, false
+}
+
+// clampByte returns v truncated to a byte, clamped to 0-255.
+func clampByte(v float32) byte {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return byte(v)
+}
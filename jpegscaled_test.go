@@ -0,0 +1,228 @@
+package letterbox
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPhoto returns a smooth image of the given size with some detail.
+func testPhoto(w, h int, gray bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			r := 128 + 100*math.Sin(fx*7+fy*3)
+			g := 128 + 100*math.Cos(fy*9-fx*2)
+			b := 128 + 100*math.Sin((fx+fy)*5)
+			if gray {
+				g, b = r, r
+			}
+			img.Set(x, y, color.RGBA{uint8(r), uint8(g), uint8(b), 255})
+		}
+	}
+
+	if !gray {
+		return img
+	}
+
+	g := image.NewGray(img.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			g.Set(x, y, img.At(x, y))
+		}
+	}
+	return g
+}
+
+// plane is a plane of samples.
+type plane struct {
+	pix    []byte
+	stride int
+	size   image.Point
+}
+
+// planes returns the planes of a gray or YCbCr image.
+func planes(img image.Image) []plane {
+	switch img := img.(type) {
+	case *image.Gray:
+		return []plane{{img.Pix, img.Stride, img.Rect.Size()}}
+	case *image.YCbCr:
+		y := img.Rect.Size()
+		c := y
+		switch img.SubsampleRatio {
+		case image.YCbCrSubsampleRatio420:
+			c = image.Pt((y.X+1)/2, (y.Y+1)/2)
+		case image.YCbCrSubsampleRatio422:
+			c = image.Pt((y.X+1)/2, y.Y)
+		}
+		return []plane{{img.Y, img.YStride, y}, {img.Cb, img.CStride, c}, {img.Cr, img.CStride, c}}
+	}
+	return nil
+}
+
+func TestDecodeScaled(t *testing.T) {
+	cases := []struct {
+		name   string
+		w, h   int
+		gray   bool
+		encode func(*bytes.Buffer, image.Image) error
+	}{
+		{"ycbcr", 640, 480, false, nil},
+		{"ycbcr partial mcus", 333, 211, false, nil},
+		{"gray", 320, 240, true, nil},
+		{"gray partial blocks", 101, 77, true, nil},
+		{"restart markers", 1024, 4100, false, func(b *bytes.Buffer, img image.Image) error {
+			return encodeBands(b, img, &jpeg.Options{Quality: 90})
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			src := testPhoto(c.w, c.h, c.gray)
+
+			var b bytes.Buffer
+			var err error
+			if c.encode != nil {
+				err = c.encode(&b, src)
+			} else {
+				err = jpeg.Encode(&b, src, &jpeg.Options{Quality: 90})
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			full, err := jpeg.Decode(bytes.NewReader(b.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, n := range []int{2, 4, 8} {
+				img, err := decodeScaled(context.Background(), b.Bytes(), n)
+				if err != nil {
+					t.Fatalf("1/%d: %v", n, err)
+				}
+
+				r, ok := img.(*reduced)
+				if !ok {
+					t.Fatalf("1/%d: decoded a %T, want *reduced", n, img)
+				}
+
+				if got := r.Bounds(); got != full.Bounds() {
+					t.Fatalf("1/%d: bounds = %v, want %v", n, got, full.Bounds())
+				}
+
+				want := image.Rect(0, 0, (c.w+n-1)/n, (c.h+n-1)/n)
+				if got := r.Image.Bounds(); got != want {
+					t.Fatalf("1/%d: reduced bounds = %v, want %v", n, got, want)
+				}
+
+				// samples are close to the mean of those decoded at full
+				// size, within the whole blocks unpadded by the encoder
+				fp, rp := planes(full), planes(r.Image)
+				if len(fp) != len(rp) {
+					t.Fatalf("1/%d: %d planes, want %d", n, len(rp), len(fp))
+				}
+
+				var sum, worst, count float64
+				for i := range fp {
+					f, p := fp[i], rp[i]
+					for y := 0; y < f.size.Y/8*8/n; y++ {
+						for x := 0; x < f.size.X/8*8/n; x++ {
+							var a float64
+							for j := 0; j < n; j++ {
+								for k := 0; k < n; k++ {
+									a += float64(f.pix[(y*n+j)*f.stride+x*n+k])
+								}
+							}
+
+							d := math.Abs(float64(p.pix[y*p.stride+x]) - a/float64(n*n))
+							sum += d
+							worst = math.Max(worst, d)
+							count++
+						}
+					}
+				}
+
+				mean := sum / count
+				if mean > 1.5 || worst > 8 {
+					t.Errorf("1/%d: mean difference %.2f, max %.1f", n, mean, worst)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeScaledUnsupported(t *testing.T) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, testPhoto(64, 64, false), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// progressive
+	data := bytes.Replace(b.Bytes(), []byte{0xFF, 0xC0}, []byte{0xFF, 0xC2}, 1)
+	if _, err := decodeScaled(context.Background(), data, 2); err != errScaledUnsupported {
+		t.Fatalf("progressive error = %v, want %v", err, errScaledUnsupported)
+	}
+
+	// decoded at full size instead
+	src := &source{path: "photo.jpg", data: append([]byte(nil), b.Bytes()...), scale: 2}
+	img, err := src.decode(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := img.(*reduced); !ok {
+		t.Fatalf("baseline decoded as a %T, want *reduced", img)
+	}
+
+	src = &source{path: "photo.png", data: []byte("not a jpeg"), scale: 2}
+	if _, err := src.decode(context.Background()); err == nil {
+		t.Fatal("decoded an invalid image")
+	}
+}
+
+func TestDecodeScale(t *testing.T) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, testPhoto(1600, 1200, false), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		options []Option
+		want    int
+	}{
+		{"disabled", []Option{WithSize(200, 150)}, 1},
+		{"1/8", []Option{WithScaledDecode(true), WithSize(200, 150)}, 8},
+		{"1/4", []Option{WithScaledDecode(true), WithSize(300, 300)}, 4},
+		{"1/2", []Option{WithScaledDecode(true), WithMaxSize(640, 0)}, 2},
+		{"full size", []Option{WithScaledDecode(true), WithMaxSize(1000, 0)}, 1},
+		{"unbounded", []Option{WithScaledDecode(true)}, 1},
+		{"largest of several targets", []Option{WithScaledDecode(true), WithFit("cover"), WithMaxSize(400, 400), WithAspects("4:3", "1:4")}, 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := New(t.TempDir(), c.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := p.decodeScale(&source{path: path}, p.targets()); got != c.want {
+				t.Errorf("scale = 1/%d, want 1/%d", got, c.want)
+			}
+		})
+	}
+}
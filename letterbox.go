@@ -1,18 +1,28 @@
 package letterbox
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/jpeg"
-	"log"
+	"image/png"
+	"io"
+	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 )
@@ -20,16 +30,223 @@ import (
 // Option function.
 type Option func(*Processor) error
 
+// Size is a width and height in pixels.
+type Size struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Length is a distance in pixels, or a percentage of the canvas dimension.
+type Length struct {
+	Value   float64
+	Percent bool
+}
+
+// ParseLength returns a parsed length such as "120", "120px" or "10%".
+func ParseLength(s string) (Length, error) {
+	percent := strings.HasSuffix(s, "%")
+	v := strings.TrimSuffix(strings.TrimSuffix(s, "px"), "%")
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return Length{}, fmt.Errorf("invalid length %q", s)
+	}
+	return Length{Value: n, Percent: percent}, nil
+}
+
+// pixels returns the length in pixels relative to the canvas dimension d.
+func (l Length) pixels(d int) int {
+	if l.Percent {
+		return int(math.Round(l.Value / 100 * float64(d)))
+	}
+	return int(math.Round(l.Value))
+}
+
+// Margin is the space added around the source on each side, forming a matte
+// border before padding to the aspect ratio. Percentages are of the source
+// height for the top and bottom, and of the source width for the left and right.
+type Margin struct {
+	Top, Right, Bottom, Left Length
+}
+
+// ParseMargin returns a parsed margin of one to four space or comma separated
+// lengths, applied to the sides in the same order as CSS.
+func ParseMargin(s string) (Margin, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+
+	var v []Length
+	for _, f := range fields {
+		l, err := ParseLength(f)
+		if err != nil {
+			return Margin{}, err
+		}
+		v = append(v, l)
+	}
+
+	switch len(v) {
+	case 0:
+		return Margin{}, nil
+	case 1:
+		return Margin{v[0], v[0], v[0], v[0]}, nil
+	case 2:
+		return Margin{v[0], v[1], v[0], v[1]}, nil
+	case 3:
+		return Margin{v[0], v[1], v[2], v[1]}, nil
+	case 4:
+		return Margin{v[0], v[1], v[2], v[3]}, nil
+	default:
+		return Margin{}, fmt.Errorf("invalid margin %q, must be one to four lengths", s)
+	}
+}
+
+// pixels returns the margin in pixels for a source of size r.
+func (m Margin) pixels(r image.Rectangle) Bars {
+	return Bars{
+		Top:    m.Top.pixels(r.Dy()),
+		Right:  m.Right.pixels(r.Dx()),
+		Bottom: m.Bottom.pixels(r.Dy()),
+		Left:   m.Left.pixels(r.Dx()),
+	}
+}
+
+// Bars is the size of the bars added to each side in pixels.
+type Bars struct {
+	Top    int `json:"top"`
+	Right  int `json:"right"`
+	Bottom int `json:"bottom"`
+	Left   int `json:"left"`
+}
+
+// Result is the outcome of processing a single image.
+type Result struct {
+	Source      string            `json:"source"`
+	Output      string            `json:"output"`
+	Original    Size              `json:"original"`
+	Final       Size              `json:"final"`
+	Bars        Bars              `json:"bars"`
+	Layout      string            `json:"layout,omitempty"`
+	Scale       float64           `json:"scale,omitempty"`
+	Crop        *Bars             `json:"crop,omitempty"`
+	Page        int               `json:"page,omitempty"`
+	Pages       int               `json:"pages,omitempty"`
+	Duration    time.Duration     `json:"duration"`
+	Timings     *Timings          `json:"timings,omitempty"`
+	Bytes       int64             `json:"bytes"`
+	Skipped     bool              `json:"skipped,omitempty"`
+	Rejected    bool              `json:"rejected,omitempty"`
+	Overwritten bool              `json:"overwritten,omitempty"`
+	Passthrough bool              `json:"passthrough,omitempty"`
+	Unsupported *UnsupportedError `json:"unsupported,omitempty"`
+	Corrupt     bool              `json:"corrupt,omitempty"`
+	Backup      string            `json:"backup,omitempty"`
+	Warnings    []string          `json:"warnings,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// The layouts of results, by the sides bars were added to.
+const (
+	LayoutNone      = "none"
+	LayoutLetterbox = "letterbox"
+	LayoutPillarbox = "pillarbox"
+	LayoutWindowbox = "windowbox"
+)
+
+// Timings are the durations of the stages of processing an image. Sources
+// decoded for a previous target have no decode duration.
+type Timings struct {
+	// Decode is the duration of decoding, or loading when using a Loader.
+	Decode time.Duration `json:"decode"`
+
+	// Compose is the duration of cropping, scaling and compositing.
+	Compose time.Duration `json:"compose"`
+
+	// Encode is the duration of encoding and writing the output.
+	Encode time.Duration `json:"encode"`
+}
+
+// namedAspect is an aspect ratio and its original notation.
+type namedAspect struct {
+	name  string
+	ratio float64
+}
+
+// target is an output produced for each source image.
+type target struct {
+	dir    string
+	name   string
+	aspect float64
+
+	// page is the source rect of the page when splitting, of the
+	// 1-based index of count pages.
+	page  image.Rectangle
+	index int
+	count int
+}
+
 // Processor is a batch image processor for automating
 // cropping and letterboxes.
 type Processor struct {
-	dir         string
-	white       bool
-	aspect      float64
-	quality     int
-	concurrency int
-	padding     float64
-	force       bool
+	dir            string
+	background     color.Color
+	aspects        []namedAspect
+	portrait       *namedAspect
+	landscape      *namedAspect
+	quality        int
+	size           Size
+	upscale        bool
+	maxSize        Size
+	fit            string
+	gravity        string
+	round          string
+	forceEven      bool
+	offset         [2]Length
+	padTo          float64
+	split          bool
+	overlap        float64
+	margin         Margin
+	watermark      *watermark
+	border         *border
+	shadow         *shadow
+	radius         int
+	resampler      xdraw.Scaler
+	format         string
+	depth          int
+	compression    png.CompressionLevel
+	concurrency    int
+	ioWorkers      int
+	adaptive       bool
+	schedule       string
+	maxDuration    time.Duration
+	maxImages      int
+	timeout        time.Duration
+	scaledDecode   bool
+	parallelEncode int
+	tolerate       bool
+	preserveTimes  bool
+	maxPixels      int
+	maxMemory      int64
+	memory         *semaphore.Weighted
+	padding        float64
+	tolerance      float64
+	links          bool
+	force          bool
+	skip           SkipPolicy
+	dryRun         bool
+	metadata       MetadataBackend
+	loader         Loader
+	thumbnail      int
+	backups        string
+	sidecars       bool
+	name           *template.Template
+	preserve       bool
+	roots          []string
+	strict         bool
+	verify         bool
+	handler        func(Result)
+	log            *slog.Logger
+	canvases       sync.Pool
+	mu             sync.Mutex
 }
 
 // New processor outputting to dir with the given options.
@@ -37,7 +254,18 @@ func New(dir string, options ...Option) (*Processor, error) {
 	var v Processor
 	v.concurrency = 1
 	v.quality = 90
+	v.format = "jpeg"
+	v.depth = 8
+	v.fit = "pad"
+	v.gravity = "center"
+	v.round = "floor"
+	v.background = color.Black
+	v.schedule = "input"
+	v.resampler = xdraw.CatmullRom
+	v.aspects = []namedAspect{{name: "16:9", ratio: 16.0 / 9}}
+	v.skip = SkipUnmodified
 	v.dir = dir
+	v.log = slog.Default()
 	for _, o := range options {
 		if err := o(&v); err != nil {
 			return nil, err
@@ -46,10 +274,66 @@ func New(dir string, options ...Option) (*Processor, error) {
 	return &v, nil
 }
 
+// Compose returns the src image letterboxed with the given options, purely in
+// memory without any decoding, encoding or files, for applications which already
+// have decoded frames. Only the first aspect ratio, or that of the orientation
+// of src, is used, and options concerning files such as skipping and metadata
+// are ignored.
+func Compose(src image.Image, options ...Option) (image.Image, error) {
+	p, err := New("", options...)
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.orientedTargets(src.Bounds().Size())[0]
+	sr := p.region(src, src.Bounds(), nil, t)
+	dst, _ := p.compose(src, sr, t)
+	return dst, nil
+}
+
+// ComposeInto letterboxes src into dst, such as a framebuffer or shared memory
+// image, avoiding the allocation and copy of Compose. The bounds of dst are
+// the output size in place of WithSize, and every pixel within them is drawn,
+// so layouts which don't fill them, such as the contain fit, are an error.
+// Other options are as with Compose.
+func ComposeInto(dst draw.Image, src image.Image, options ...Option) error {
+	b := dst.Bounds()
+	if b.Empty() {
+		return errors.New("empty destination")
+	}
+
+	options = append(options[:len(options):len(options)], WithSize(b.Dx(), b.Dy()))
+	p, err := New("", options...)
+	if err != nil {
+		return err
+	}
+
+	t := p.orientedTargets(src.Bounds().Size())[0]
+	sr := p.region(src, src.Bounds(), nil, t)
+	db, dr := p.layout(sr, t, p.focus(src, sr))
+	if db.Size() != b.Size() {
+		return fmt.Errorf("layout of %dx%d does not fill the destination of %dx%d", db.Dx(), db.Dy(), b.Dx(), b.Dy())
+	}
+
+	p.composite(dst, src, sr, dr.Add(b.Min.Sub(db.Min)))
+	return nil
+}
+
 // WithWhiteBackground changes the background color to white.
 func WithWhiteBackground(v bool) Option {
 	return func(p *Processor) error {
-		p.white = v
+		p.background = withColor(v)
+		return nil
+	}
+}
+
+// WithBackground changes the background color, which defaults to black.
+// Sources with transparency are blended over it, flattening them for jpeg
+// output. Transparent and translucent colors, such as color.Transparent,
+// require png or tiff output, leaving the bars transparent.
+func WithBackground(c color.Color) Option {
+	return func(p *Processor) error {
+		p.background = c
 		return nil
 	}
 }
@@ -62,6 +346,83 @@ func WithForce(v bool) Option {
 	}
 }
 
+// WithSkipPolicy changes the policy deciding whether or not images are skipped,
+// which defaults to SkipUnmodified. Forcing re-processing takes precedence.
+func WithSkipPolicy(s SkipPolicy) Option {
+	return func(p *Processor) error {
+		p.skip = s
+		return nil
+	}
+}
+
+// WithDryRun changes whether or not images are only inspected, without decoding
+// or writing anything.
+func WithDryRun(v bool) Option {
+	return func(p *Processor) error {
+		p.dryRun = v
+		return nil
+	}
+}
+
+// WithMetadataBackend changes the backend used to copy metadata from
+// source images to their outputs, nil disables copying.
+func WithMetadataBackend(b MetadataBackend) Option {
+	return func(p *Processor) error {
+		p.metadata = b
+		return nil
+	}
+}
+
+// WithLoader changes the loader used to decode and scale sources, such as
+// Vips, which defaults to nil for decoding and scaling in Go.
+func WithLoader(l Loader) Option {
+	return func(p *Processor) error {
+		p.loader = l
+		return nil
+	}
+}
+
+// WithSidecars changes whether or not XMP sidecars are read and written.
+// Images rejected in Lightroom are skipped, crops are applied before
+// letterboxing, and the rating and label are written next to the output.
+func WithSidecars(v bool) Option {
+	return func(p *Processor) error {
+		p.sidecars = v
+		return nil
+	}
+}
+
+// WithPreservePaths changes whether outputs mirror the relative directory
+// structure of their sources under the output directory, instead of being
+// flattened to their base names. Absolute and parent-relative sources never
+// escape the output directory.
+func WithPreservePaths(v bool) Option {
+	return func(p *Processor) error {
+		p.preserve = v
+		return nil
+	}
+}
+
+// WithSourceRoot changes the directories sources are made relative to when
+// preserving paths, the first containing them, such as those of extracted
+// archives or merged input directories, which defaults to the working
+// directory.
+func WithSourceRoot(dirs ...string) Option {
+	return func(p *Processor) error {
+		p.roots = dirs
+		return nil
+	}
+}
+
+// WithWarningsAsErrors changes whether or not warnings, such as metadata which
+// could not be preserved, fail the image.
+func WithWarningsAsErrors(v bool) Option {
+	return func(p *Processor) error {
+		p.strict = v
+		return nil
+	}
+}
+
 // WithPadding changes the image padding which is applied as a percentage.
 func WithPadding(n int) Option {
 	return func(p *Processor) error {
@@ -70,13 +431,91 @@ func WithPadding(n int) Option {
 	}
 }
 
-// WithAspect changes the aspect ratio which defaults to "16:9".
+// WithMargin changes the margin around the source, which is inset from the
+// canvas edges even along the dimension fitting the aspect ratio. Pixel margins
+// are relative to the source and scale along with it.
+func WithMargin(m Margin) Option {
+	return func(p *Processor) error {
+		p.margin = m
+		return nil
+	}
+}
+
+// WithPadTo changes the bars to add exactly the given percentage of the source
+// height, split between the top and bottom, instead of padding to the aspect
+// ratio. Zero disables it.
+func WithPadTo(percent float64) Option {
+	return func(p *Processor) error {
+		if percent < 0 {
+			return fmt.Errorf("invalid pad-to percentage %v", percent)
+		}
+		p.padTo = percent / 100
+		return nil
+	}
+}
+
+// WithAspect changes the aspect ratio which defaults to "16:9", written as a
+// ratio such as "16:9" or "9:16", dimensions such as "1920x1080", or a number
+// such as "1.777".
 func WithAspect(ratio string) Option {
+	return WithAspects(ratio)
+}
+
+// WithAspects changes the aspect ratios, each source is decoded once and written
+// for every ratio, into a sub-directory per ratio when there are several.
+func WithAspects(ratios ...string) Option {
 	return func(p *Processor) error {
-		n, err := parseAspect(ratio)
-		p.aspect = n
-		return err
+		if len(ratios) == 0 {
+			return fmt.Errorf("at least one aspect ratio is required")
+		}
+
+		p.aspects = nil
+		for _, r := range ratios {
+			n, err := parseAspect(r)
+			if err != nil {
+				return fmt.Errorf("parsing aspect %q: %w", r, err)
+			}
+			p.aspects = append(p.aspects, namedAspect{name: r, ratio: n})
+		}
+
+		return nil
+	}
+}
+
+// WithOrientedAspects changes the aspect ratios of portrait and landscape
+// sources, such as "4:5" and "16:9", written in place of those of WithAspects
+// for sources of that orientation. Square sources are landscape, and an empty
+// ratio leaves sources of that orientation to WithAspects.
+func WithOrientedAspects(portrait, landscape string) Option {
+	return func(p *Processor) error {
+		var err error
+
+		p.portrait, err = optionalAspect(portrait)
+		if err != nil {
+			return fmt.Errorf("parsing portrait aspect %q: %w", portrait, err)
+		}
+
+		p.landscape, err = optionalAspect(landscape)
+		if err != nil {
+			return fmt.Errorf("parsing landscape aspect %q: %w", landscape, err)
+		}
+
+		return nil
+	}
+}
+
+// optionalAspect returns the parsed aspect ratio, or nil when empty.
+func optionalAspect(s string) (*namedAspect, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	n, err := parseAspect(s)
+	if err != nil {
+		return nil, err
 	}
+
+	return &namedAspect{name: s, ratio: n}, nil
 }
 
 // WithQuality changes the jpeg output quality, from 0-100.
@@ -87,153 +526,1379 @@ func WithQuality(n int) Option {
 	}
 }
 
-// WithConcurrency changes the processing concurrency.
-func WithConcurrency(n int) Option {
+// WithSize changes the exact output dimensions, the source is scaled down (or up,
+// see WithUpscale) to fit within the canvas and padded to its size. The aspect
+// ratio is ignored.
+func WithSize(width, height int) Option {
 	return func(p *Processor) error {
-		p.concurrency = n
+		if width < 0 || height < 0 {
+			return fmt.Errorf("invalid size %dx%d", width, height)
+		}
+		p.size = Size{width, height}
 		return nil
 	}
 }
 
-// Process the given images.
-func (p *Processor) Process(ctx context.Context, images []string) error {
-	sem := semaphore.NewWeighted(int64(p.concurrency))
-	var errg errgroup.Group
+// WithUpscale changes whether or not sources smaller than the exact output
+// dimensions are scaled up to fit, instead of only being padded.
+func WithUpscale(v bool) Option {
+	return func(p *Processor) error {
+		p.upscale = v
+		return nil
+	}
+}
 
-	for _, path := range images {
-		err := sem.Acquire(ctx, 1)
-		if err != nil {
-			return err
+// WithFit changes how the source is fitted to the aspect ratio or size:
+// "pad" adds bars, "cover" crops to fill, "contain" scales within the size
+// without bars, and "stretch" distorts to fill. Defaults to "pad".
+func WithFit(name string) Option {
+	return func(p *Processor) error {
+		switch name {
+		case "pad", "cover", "contain", "stretch":
+			p.fit = name
+			return nil
+		default:
+			return fmt.Errorf("unsupported fit %q", name)
 		}
+	}
+}
 
-		path := path
-		errg.Go(func() error {
-			defer sem.Release(1)
-			return p.process(path)
-		})
+// WithGravity changes the placement of the source within the canvas, and of
+// the crop within the source when covering: "center", "top", "bottom", "left",
+// "right", corners such as "bottom-left", or "smart". Smart gravity uses an
+// edge-density heuristic to keep subjects in frame when cropping, and towards
+// the center of the canvas when padding. Defaults to "center".
+func WithGravity(name string) Option {
+	return func(p *Processor) error {
+		if _, ok := anchors[name]; ok || name == "center" || name == "smart" {
+			p.gravity = name
+			return nil
+		}
+		return fmt.Errorf("unsupported gravity %q", name)
 	}
+}
 
-	return errg.Wait()
+// WithRounding changes how fractional canvas dimensions are rounded: "floor",
+// "ceil", or "even" which rounds both dimensions up to even numbers as required
+// by video encoders. When bars can't be split evenly the extra pixel is placed
+// at the bottom or right, or at the top or left with "ceil". Defaults to "floor".
+func WithRounding(name string) Option {
+	return func(p *Processor) error {
+		switch name {
+		case "floor", "ceil", "even":
+			p.round = name
+			return nil
+		default:
+			return fmt.Errorf("unsupported rounding %q", name)
+		}
+	}
 }
 
-// process implementation.
-func (p *Processor) process(path string) error {
-	dstpath := filepath.Join(p.dir, path)
+// WithForceEven changes whether the canvas is padded to even dimensions, as
+// required by H.264 yuv420p, including explicit sizes and cropped outputs.
+func WithForceEven(v bool) Option {
+	return func(p *Processor) error {
+		p.forceEven = v
+		return nil
+	}
+}
 
-	// unmodified
-	if unmodified(path, dstpath) && !p.force {
-		log.Printf("Umodified %s", path)
+// WithOffset changes the offset applied to the placed source, such as moving
+// it up to leave room for a caption bar. The source remains within the canvas.
+func WithOffset(x, y Length) Option {
+	return func(p *Processor) error {
+		p.offset = [2]Length{x, y}
 		return nil
 	}
+}
 
-	// open
-	log.Printf("Processing %s\n", path)
-	f, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("opening: %w", err)
+// WithMaxSize changes the maximum width and height of the source, larger sources
+// are scaled down before letterboxing. Zero values are unlimited.
+func WithMaxSize(width, height int) Option {
+	return func(p *Processor) error {
+		if width < 0 || height < 0 {
+			return fmt.Errorf("invalid max size %dx%d", width, height)
+		}
+		p.maxSize = Size{width, height}
+		return nil
 	}
-	defer f.Close()
+}
 
-	// decode
-	src, _, err := image.Decode(f)
-	if err != nil {
-		return fmt.Errorf("decoding: %w", err)
+// WithResampler changes the resampler used for scaling: "lanczos",
+// "catmull-rom", "bilinear" or "nearest", which defaults to "catmull-rom".
+func WithResampler(name string) Option {
+	return func(p *Processor) error {
+		r, err := resampler(name)
+		p.resampler = r
+		return err
 	}
+}
 
-	// dimensions
-	sb := src.Bounds()
-	db := aspect(sb, p.aspect)
-	db = padding(db, p.padding)
-	dr := centered(sb, db)
+// WithPNGCompression changes the compression of png outputs: "default",
+// "none", "fast" or "best", which defaults to "default".
+func WithPNGCompression(level string) Option {
+	return func(p *Processor) error {
+		switch level {
+		case "default", "":
+			p.compression = png.DefaultCompression
+		case "none":
+			p.compression = png.NoCompression
+		case "fast":
+			p.compression = png.BestSpeed
+		case "best":
+			p.compression = png.BestCompression
+		default:
+			return fmt.Errorf("unsupported png compression %q", level)
+		}
+		return nil
+	}
+}
 
-	// dst image
-	dst := image.NewRGBA(db)
+// WithFormat changes the output format, "jpeg", "png" or "tiff", which defaults
+// to "jpeg".
+func WithFormat(name string) Option {
+	return func(p *Processor) error {
+		switch name {
+		case "jpeg", "png", "tiff":
+			p.format = name
+			return nil
+		case "jpg":
+			p.format = "jpeg"
+			return nil
+		case "tif":
+			p.format = "tiff"
+			return nil
+		default:
+			return fmt.Errorf("unsupported format %q", name)
+		}
+	}
+}
 
-	// fill the background with black or white
-	draw.Draw(dst, db, &image.Uniform{withColor(p.white)}, image.ZP, draw.Src)
+// WithDepth changes the bit depth per channel of compositing and output, 8 or
+// 16, which defaults to 8. A depth of 16 preserves the precision of 16-bit
+// sources for archival, and requires png or tiff output.
+func WithDepth(bits int) Option {
+	return func(p *Processor) error {
+		switch bits {
+		case 8, 16:
+			p.depth = bits
+			return nil
+		default:
+			return fmt.Errorf("unsupported depth %d, must be 8 or 16", bits)
+		}
+	}
+}
 
-	// draw the src image onto dst
-	draw.Draw(dst, dr, src, src.Bounds().Min, draw.Src)
+// WithConcurrency changes the processing concurrency.
+func WithConcurrency(n int) Option {
+	return func(p *Processor) error {
+		p.concurrency = n
+		return nil
+	}
+}
 
-	// write
-	return writeImage(dst, dstpath, p.quality)
+// WithAdaptiveConcurrency changes whether or not the concurrency is adjusted at
+// runtime based on the observed throughput and memory headroom, up to the
+// maximum set by WithConcurrency.
+func WithAdaptiveConcurrency(v bool) Option {
+	return func(p *Processor) error {
+		p.adaptive = v
+		return nil
+	}
 }
 
-// withColor returns the color specified.
-func withColor(white bool) color.Color {
-	if white {
-		return color.White
+// WithSchedule changes the order images are scheduled in, "input" for the
+// order given, or "largest" for the largest files first, which improves the
+// tail latency of batches mixing giant and small images. Defaults to "input".
+func WithSchedule(name string) Option {
+	return func(p *Processor) error {
+		switch name {
+		case "input", "largest":
+			p.schedule = name
+			return nil
+		default:
+			return fmt.Errorf("unsupported schedule %q", name)
+		}
 	}
-	return color.Black
 }
 
-// centered returns a rect with rect s centered in rect d.
-func centered(s, d image.Rectangle) image.Rectangle {
-	sw := s.Max.X
-	sh := s.Max.Y
-	dw := d.Max.X
-	dh := d.Max.Y
-	return image.Rect(
-		dw/2-(sw/2),
-		dh/2-(sh/2),
-		dw/2+dw,
-		dh/2+sh)
+// WithMaxDuration changes the duration after which no new images are scheduled,
+// in-flight images complete and a *BudgetError is returned.
+func WithMaxDuration(d time.Duration) Option {
+	return func(p *Processor) error {
+		p.maxDuration = d
+		return nil
+	}
 }
 
-// padding returns a rect with padding applied.
-func padding(r image.Rectangle, padding float64) image.Rectangle {
-	w := float64(r.Max.X)
-	h := float64(r.Max.Y)
-	return image.Rect(0, 0, int(w+(w*padding)), int(h+(h*padding)))
+// WithIOConcurrency changes the number of sources read and outputs written
+// concurrently, pipelining them with decoding, composing and encoding, which
+// are then limited by the concurrency. It defaults to 0, where each image is
+// processed from reading to writing while holding one of the concurrency slots.
+func WithIOConcurrency(n int) Option {
+	return func(p *Processor) error {
+		p.ioWorkers = n
+		return nil
+	}
 }
 
-// aspect returns a rect with aspect ratio applied.
-func aspect(r image.Rectangle, aspect float64) image.Rectangle {
-	w := float64(r.Max.X)
-	h := float64(r.Max.Y)
+// WithTimeout changes the duration after which an image fails, stopping its
+// decoding and encoding, which defaults to no timeout.
+func WithTimeout(d time.Duration) Option {
+	return func(p *Processor) error {
+		p.timeout = d
+		return nil
+	}
+}
 
-	if w > h {
-		h = w / aspect
-	} else {
-		w = h * aspect
+// WithMaxImages changes the number of images after which no new images are
+// scheduled, in-flight images complete and a *BudgetError is returned.
+func WithMaxImages(n int) Option {
+	return func(p *Processor) error {
+		p.maxImages = n
+		return nil
 	}
+}
 
-	return image.Rect(0, 0, int(w), int(h))
+// WithLogger changes the logger, which defaults to slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(p *Processor) error {
+		p.log = l
+		return nil
+	}
 }
 
-// writeImage writes a jpeg image to the given path.
-func writeImage(img image.Image, path string, quality int) error {
-	f, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("creating: %w", err)
+// WithResultHandler changes the function invoked with the result of each
+// image, calls are serialized so the handler does not need to be thread-safe.
+func WithResultHandler(fn func(Result)) Option {
+	return func(p *Processor) error {
+		p.handler = fn
+		return nil
 	}
+}
 
-	err = jpeg.Encode(f, img, &jpeg.Options{
-		Quality: quality,
-	})
+// BudgetError is returned when processing stops early due to
+// the maximum duration or number of images being exceeded.
+type BudgetError struct {
+	// Remaining images which were not scheduled.
+	Remaining []string
+}
 
-	if err != nil {
-		return fmt.Errorf("encoding: %w", err)
-	}
+// Error implementation.
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("budget exceeded with %d images remaining", len(e.Remaining))
+}
 
-	return nil
+// CanceledError is returned when processing stops early due to the context
+// being cancelled, after the images in-flight have completed.
+type CanceledError struct {
+	// Err is the context error.
+	Err error
+
+	// Remaining images which were not scheduled.
+	Remaining []string
 }
 
-// parseAspect returns a parsed aspect ratio.
-func parseAspect(s string) (float64, error) {
-	parts := strings.Split(s, ":")
+// Error implementation.
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("%s with %d images remaining", e.Err, len(e.Remaining))
+}
 
-	a, err := strconv.ParseFloat(parts[0], 64)
-	if err != nil {
-		return 0, err
+// Unwrap implementation.
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// Process the given images. When an image fails or the context is cancelled
+// no more images are scheduled, and once those in-flight complete the error
+// of the failed image, or a CanceledError when cancelled, is returned.
+// Concurrent calls each have their own concurrency and output collisions.
+func (p *Processor) Process(ctx context.Context, images []string) error {
+	start := time.Now()
+
+	// jpeg has no alpha channel, which would turn transparent bars black
+	if _, _, _, a := p.background.RGBA(); a < 0xffff && p.format == "jpeg" {
+		return errors.New("transparent backgrounds require png or tiff output")
 	}
 
-	b, err := strconv.ParseFloat(parts[1], 64)
-	if err != nil {
-		return 0, err
+	// jpeg is limited to 8 bits per channel
+	if p.depth == 16 && p.format == "jpeg" {
+		return errors.New("16-bit depth requires png or tiff output")
 	}
 
-	return a / b, nil
+	// concurrency
+	var sem limiter = fixedLimiter{semaphore.NewWeighted(int64(p.concurrency))}
+	if p.adaptive {
+		l := newAdaptiveLimiter(p.concurrency)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go l.control(ctx, 2*time.Second, p.log)
+		sem = l
+	}
+
+	// pipeline, limiting the stages separately and
+	// scheduling enough images to keep each busy
+	r := &run{claimed: make(map[string]string)}
+	if p.ioWorkers > 0 {
+		r.cpu = sem
+		r.io = fixedLimiter{semaphore.NewWeighted(int64(p.ioWorkers))}
+		sem = fixedLimiter{semaphore.NewWeighted(int64(p.concurrency + p.ioWorkers))}
+	}
+
+	// options invalidating skipped outputs
+	if o, ok := p.skip.(optionsSetter); ok {
+		o.setOptions(p.optionsKey())
+	}
+
+	// schedule
+	if p.schedule == "largest" {
+		images = largestFirst(images)
+	}
+
+	// scheduling stops once an image fails or ctx is cancelled
+	errg, gctx := errgroup.WithContext(ctx)
+
+	for i, path := range images {
+		err := gctx.Err()
+		if err == nil {
+			err = sem.Acquire(gctx)
+		}
+
+		// cancelled or failed
+		if err != nil {
+			err := errg.Wait()
+			if ctx.Err() != nil {
+				return &CanceledError{Err: ctx.Err(), Remaining: images[i:]}
+			}
+			return err
+		}
+
+		// budget
+		if p.exceeded(i, start) {
+			sem.Release()
+			if err := errg.Wait(); err != nil {
+				return err
+			}
+			return &BudgetError{Remaining: images[i:]}
+		}
+
+		path := path
+		errg.Go(func() error {
+			defer sem.Release()
+			return p.processAndReport(gctx, r, path)
+		})
+	}
+
+	err := errg.Wait()
+	if ctx.Err() != nil {
+		return &CanceledError{Err: ctx.Err(), Remaining: []string{}}
+	}
+
+	return err
+}
+
+// run is the state of a call to Process, so that concurrent calls
+// don't share their pipeline or outputs.
+type run struct {
+	// cpu and io limit the stages of the pipeline, or are nil when not pipelined.
+	cpu limiter
+	io  limiter
+
+	// claimed maps outputs to the sources which claimed them.
+	mu      sync.Mutex
+	claimed map[string]string
+}
+
+// claim records the output of the source, returning an error when another
+// source of the run has the same output.
+func (r *run) claim(output, path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if other, ok := r.claimed[output]; ok && other != path {
+		return fmt.Errorf("output %s collides with that of %s, preserving paths avoids this", output, other)
+	}
+
+	r.claimed[output] = path
+	return nil
+}
+
+// limiter limits the number of in-flight images.
+type limiter interface {
+	Acquire(ctx context.Context) error
+	Release()
+}
+
+// stage runs fn holding one of the slots of limiter l, or directly when nil.
+func stage(ctx context.Context, l limiter, fn func() error) error {
+	if l == nil {
+		return fn()
+	}
+
+	if err := l.Acquire(ctx); err != nil {
+		return err
+	}
+	defer l.Release()
+
+	return fn()
+}
+
+// fixedLimiter is a limiter with fixed concurrency.
+type fixedLimiter struct {
+	sem *semaphore.Weighted
+}
+
+// Acquire implementation.
+func (l fixedLimiter) Acquire(ctx context.Context) error {
+	return l.sem.Acquire(ctx, 1)
+}
+
+// Release implementation.
+func (l fixedLimiter) Release() {
+	l.sem.Release(1)
+}
+
+// exceeded returns true if the budget is exceeded after n images scheduled since start.
+func (p *Processor) exceeded(n int, start time.Time) bool {
+	if p.maxImages > 0 && n >= p.maxImages {
+		return true
+	}
+
+	if p.maxDuration > 0 && time.Since(start) >= p.maxDuration {
+		return true
+	}
+
+	return false
+}
+
+// processAndReport processes the image for each target and passes the results to the handler.
+func (p *Processor) processAndReport(ctx context.Context, r *run, path string) error {
+	src := &source{path: path}
+
+	// in-flight images complete when cancelled, subject to the timeout
+	ctx = context.WithoutCancel(ctx)
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	// memory reserved when decoding
+	defer p.release(src)
+
+	// sidecar
+	if p.sidecars {
+		sc, err := readSidecar(path)
+		if err != nil {
+			err = fmt.Errorf("reading sidecar: %w", err)
+			p.report(Result{Source: path, Error: err.Error()})
+			return err
+		}
+		src.sidecar = sc
+	}
+
+	oriented, err := p.sourceTargets(src)
+	if err != nil {
+		p.report(failed(Result{Source: path}, err))
+		return err
+	}
+
+	var targets []target
+	for _, t := range oriented {
+		pages, err := p.pages(src, t)
+		if err != nil {
+			err = fmt.Errorf("splitting: %w", err)
+			p.report(failed(Result{Source: path}, err))
+			return err
+		}
+		targets = append(targets, pages...)
+	}
+
+	src.scale = p.decodeScale(src, targets)
+
+	for _, t := range targets {
+		start := time.Now()
+		res := Result{
+			Source: path,
+			Page:   t.index,
+			Pages:  t.count,
+		}
+
+		output, err := p.output(t, src)
+		if err == nil {
+			res.Output = output
+			err = r.claim(output, path)
+		}
+
+		if err == nil {
+			err = p.process(ctx, r, &res, src, t)
+		}
+		res.Duration = time.Since(start)
+		if err != nil {
+			res = failed(res, err)
+		} else if !res.Skipped && !p.dryRun {
+			p.log.Debug("Processed",
+				"path", path,
+				"output", res.Output,
+				"width", res.Final.Width,
+				"height", res.Final.Height,
+				"bytes", res.Bytes,
+				"duration", res.Duration)
+		}
+
+		p.report(res)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// failed returns the result with the error, and its details when the source
+// is unsupported.
+func failed(res Result, err error) Result {
+	res.Error = err.Error()
+	errors.As(err, &res.Unsupported)
+	return res
+}
+
+// report passes the result to the handler.
+func (p *Processor) report(res Result) {
+	if p.handler != nil {
+		p.mu.Lock()
+		p.handler(res)
+		p.mu.Unlock()
+	}
+}
+
+// process the source image for the given target.
+func (p *Processor) process(ctx context.Context, r *run, res *Result, src *source, t target) error {
+	path := res.Source
+	dstpath := res.Output
+	sc := src.sidecar
+
+	// rejected
+	if sc != nil && sc.Rejected() {
+		p.log.Info("Rejected", "path", path)
+		res.Skipped = true
+		res.Rejected = true
+		return nil
+	}
+
+	// skip
+	if !p.force {
+		skip, err := p.skip.Skip(path, dstpath)
+		if err != nil {
+			return fmt.Errorf("checking skip policy: %w", err)
+		}
+
+		if skip {
+			p.log.Info("Skipped", "path", path, "output", dstpath)
+			res.Skipped = true
+			return nil
+		}
+	}
+
+	// existing
+	res.Overwritten = exists(dstpath)
+
+	// pass through sources within the tolerance
+	if b, ok, err := p.passable(src, t); err != nil {
+		return err
+	} else if ok {
+		return p.passThrough(res, src, b)
+	}
+
+	// dry run
+	if p.dryRun {
+		return p.inspect(res, src, t)
+	}
+
+	// decode
+	p.log.Info("Processing", "path", path, "output", dstpath)
+	if err := p.expired(ctx); err != nil {
+		return err
+	}
+
+	if err := p.admit(ctx, src); err != nil {
+		return err
+	}
+
+	// read when pipelined
+	if r.io != nil && p.loader == nil {
+		err := stage(ctx, r.io, src.read)
+		if err != nil {
+			return err
+		}
+	}
+
+	// decode, crop, compose and encode when pipelined
+	var dst draw.Image
+	var encoded bytes.Buffer
+
+	res.Timings = &Timings{}
+
+	err := stage(ctx, r.cpu, func() error {
+		var err error
+		if p.loader != nil {
+			dst, err = p.loaded(res, src, t)
+		} else {
+			dst, err = p.decoded(ctx, res, src, t)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := p.expired(ctx); err != nil {
+			return err
+		}
+
+		// encode
+		if r.io != nil {
+			start := time.Now()
+			defer func() { res.Timings.Encode += time.Since(start) }()
+			return p.encode(ctx, &encoded, dst)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+	defer p.recycle(dst)
+
+	// write
+	err = stage(ctx, r.io, func() error {
+		err := os.MkdirAll(filepath.Dir(dstpath), 0755)
+		if err != nil {
+			return fmt.Errorf("creating directory: %w", err)
+		}
+
+		// backup
+		if res.Overwritten && p.backups != "" {
+			res.Backup, err = p.backup(dstpath)
+			if err != nil {
+				return fmt.Errorf("backing up: %w", err)
+			}
+		}
+
+		start := time.Now()
+		if r.io != nil {
+			err = writeFileAtomic(dstpath, encoded.Bytes())
+		} else {
+			err = p.writeImage(ctx, dst, dstpath)
+		}
+		res.Timings.Encode += time.Since(start)
+
+		if err != nil {
+			return err
+		}
+
+		// sidecar
+		if sc != nil {
+			err = writeSidecar(dstpath, sc)
+			if err != nil {
+				return fmt.Errorf("writing sidecar: %w", err)
+			}
+		}
+
+		// metadata
+		if p.metadata != nil {
+			err = p.metadata.CopyMetadata(path, dstpath)
+			if err != nil {
+				p.warn(res, fmt.Sprintf("metadata not preserved: %s", err))
+			}
+		}
+
+		// thumbnail, replacing any copied from the source
+		if p.thumbnail > 0 {
+			err = writeThumbnail(dstpath, dst, p.thumbnail)
+			if err != nil {
+				p.warn(res, fmt.Sprintf("thumbnail not embedded: %s", err))
+			}
+		}
+
+		// times, once the output is no longer rewritten
+		if p.preserveTimes {
+			err = preserveTimes(path, dstpath)
+			if err != nil {
+				p.warn(res, fmt.Sprintf("times not preserved: %s", err))
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// verify
+	if p.verify {
+		err = stage(ctx, r.cpu, func() error {
+			return p.verifyOutput(dstpath, res.Final)
+		})
+
+		if err != nil {
+			return fmt.Errorf("verifying output: %w", err)
+		}
+	}
+
+	// icc profile
+	if p.metadata == nil && hasICC(path) {
+		p.warn(res, "ICC profile not preserved")
+	}
+
+	// size
+	info, err := os.Stat(dstpath)
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+	res.Bytes = info.Size()
+
+	if si, err := os.Stat(path); err == nil && res.Bytes > si.Size() {
+		p.warn(res, fmt.Sprintf("output is larger than the source (%d > %d bytes)", res.Bytes, si.Size()))
+	}
+
+	// warnings as errors
+	if p.strict && len(res.Warnings) > 0 {
+		return fmt.Errorf("warnings: %s", strings.Join(res.Warnings, ", "))
+	}
+
+	// record
+	if r, ok := p.skip.(SkipRecorder); ok {
+		err = r.Record(path, dstpath)
+		if err != nil {
+			return fmt.Errorf("recording: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// expired returns an error when the image's timeout has elapsed.
+func (p *Processor) expired(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out after %s", p.timeout)
+	}
+	return nil
+}
+
+// decoded returns the composed canvas of the decoded source.
+func (p *Processor) decoded(ctx context.Context, res *Result, src *source, t target) (draw.Image, error) {
+	start := time.Now()
+	img, err := src.decode(ctx)
+	if err != nil && ctx.Err() != nil {
+		return nil, p.expired(ctx)
+	}
+	if err != nil && p.tolerate {
+		img, err = p.salvage(src, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Timings.Decode = time.Since(start)
+
+	if src.corrupt != "" {
+		res.Corrupt = true
+		p.warn(res, "salvaged corrupt jpeg, "+src.corrupt)
+	}
+
+	if err := p.expired(ctx); err != nil {
+		return nil, err
+	}
+
+	// crop
+	start = time.Now()
+	sr := p.region(img, img.Bounds(), src.sidecar, t)
+
+	// compose
+	dst, dr := p.compose(img, sr, t)
+	res.Timings.Compose = time.Since(start)
+	placed(res, img.Bounds(), sr, dst.Bounds(), dr)
+	return dst, nil
+}
+
+// loaded returns the composed canvas of the source loaded at its drawn size,
+// laid out from its header as smart gravity requires the decoded pixels.
+func (p *Processor) loaded(res *Result, src *source, t target) (draw.Image, error) {
+	c, err := src.decodeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	sr := p.region(nil, image.Rect(0, 0, c.Width, c.Height), src.sidecar, t)
+	db, dr := p.layout(sr, t, nil)
+
+	// load
+	start := time.Now()
+	img, err := p.loader.Load(src.path, sr, dr.Size())
+	if err != nil {
+		return nil, fmt.Errorf("loading: %w", err)
+	}
+	res.Timings.Decode = time.Since(start)
+
+	// compose
+	start = time.Now()
+	dst := p.render(img, img.Bounds(), db, dr)
+	res.Timings.Compose = time.Since(start)
+	placed(res, image.Rect(0, 0, c.Width, c.Height), sr, db, dr)
+	return dst, nil
+}
+
+// placed records the placement of the source rect sr of the source bounds sb
+// drawn to the rect dr of the canvas db, so that it may be inverted.
+func placed(res *Result, sb, sr, db, dr image.Rectangle) {
+	res.Original = Size{sb.Dx(), sb.Dy()}
+	res.Final = Size{db.Dx(), db.Dy()}
+	res.Bars = bars(db, dr)
+	res.Scale = float64(dr.Dx()) / float64(sr.Dx())
+
+	if sr != sb {
+		crop := bars(sb, sr)
+		res.Crop = &crop
+	}
+
+	b := res.Bars
+	vertical := b.Top > 0 || b.Bottom > 0
+	horizontal := b.Left > 0 || b.Right > 0
+
+	switch {
+	case vertical && horizontal:
+		res.Layout = LayoutWindowbox
+	case vertical:
+		res.Layout = LayoutLetterbox
+	case horizontal:
+		res.Layout = LayoutPillarbox
+	default:
+		res.Layout = LayoutNone
+	}
+}
+
+// warn adds a warning to the result.
+func (p *Processor) warn(res *Result, msg string) {
+	p.log.Warn(msg, "path", res.Source, "output", res.Output)
+	res.Warnings = append(res.Warnings, msg)
+}
+
+// compose returns the letterboxed image of the source rect sr of img,
+// and the rect it was drawn to.
+func (p *Processor) compose(img image.Image, sr image.Rectangle, t target) (draw.Image, image.Rectangle) {
+	db, dr := p.layout(sr, t, p.focus(img, sr))
+	return p.render(img, sr, db, dr), dr
+}
+
+// render returns the canvas db with the source rect sr of img drawn to rect dr.
+func (p *Processor) render(img image.Image, sr, db, dr image.Rectangle) draw.Image {
+	dst := p.canvas(db)
+	p.composite(dst, img, sr, dr)
+	return dst
+}
+
+// composite fills dst and draws the source rect sr of img to rect dr.
+// Compositing is in premultiplied alpha, as image/draw premultiplies sources
+// with straight alpha such as NRGBA, so translucent edges don't darken.
+func (p *Processor) composite(dst draw.Image, img image.Image, sr, dr image.Rectangle) {
+	var mask *image.Alpha
+	if p.radius > 0 {
+		mask = roundedMask(dr, p.radius)
+	}
+
+	// sources with transparency are flattened over the background,
+	// as jpeg has no alpha channel
+	over := !opaque(img)
+
+	p.fill(dst, dr, over)
+
+	// reduced sources are drawn from their reduced pixels
+	if r, ok := img.(*reduced); ok {
+		img, sr = r.Image, r.rect(sr)
+	}
+
+	p.paint(dst, dr, img, sr, p.resampler, mask, over)
+}
+
+// opaque returns true if img has no transparent or translucent pixels,
+// assuming it may when it can't tell.
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// fill fills the background of dst and draws the effects beneath rect dr,
+// filling rect dr as well when the source is drawn over it.
+func (p *Processor) fill(dst draw.Image, dr image.Rectangle, over bool) {
+	bg := &image.Uniform{p.background}
+	effects := p.effects()
+
+	// the source replaces rect dr unless it has rounded corners, effects
+	// or transparency, so only the bars are filled
+	if p.radius > 0 || len(effects) > 0 || over {
+		draw.Draw(dst, dst.Bounds(), bg, image.ZP, draw.Src)
+	} else {
+		for _, r := range outside(dst.Bounds(), dr) {
+			draw.Draw(dst, r, bg, image.ZP, draw.Src)
+		}
+	}
+
+	// effects such as borders and shadows
+	for _, e := range effects {
+		e.draw(dst, dr, p.radius)
+	}
+}
+
+// paint draws the source rect sr of img onto rect dr of dst, scaling when
+// necessary and masking rounded corners when given a mask, then the watermark.
+// The source replaces rect dr, or is blended over it when over is true.
+func (p *Processor) paint(dst draw.Image, dr image.Rectangle, img image.Image, sr image.Rectangle, scaler xdraw.Scaler, mask *image.Alpha, over bool) {
+	op := draw.Src
+	if over {
+		op = draw.Over
+	}
+
+	switch {
+	case mask != nil:
+		scaler.Scale(dst, dr, img, sr, draw.Over, &xdraw.Options{
+			DstMask: mask,
+		})
+	case dr.Size() == sr.Size():
+		draw.Draw(dst, dr, img, sr.Min, op)
+	default:
+		scaler.Scale(dst, dr, img, sr, op, nil)
+	}
+
+	// watermark
+	if p.watermark != nil {
+		p.watermark.draw(dst, p.resampler)
+	}
+}
+
+// inspect populates the result dimensions from the image header.
+func (p *Processor) inspect(res *Result, src *source, t target) error {
+	c, err := src.decodeConfig()
+	if err != nil {
+		return err
+	}
+
+	sr := p.region(nil, image.Rect(0, 0, c.Width, c.Height), src.sidecar, t)
+
+	db, dr := p.layout(sr, t, nil)
+
+	placed(res, image.Rect(0, 0, c.Width, c.Height), sr, db, dr)
+
+	msg := "Would process"
+	if res.Overwritten {
+		msg = "Would overwrite"
+	}
+
+	p.log.Info(msg,
+		"path", res.Source,
+		"output", res.Output,
+		"width", db.Dx(),
+		"height", db.Dy())
+	return nil
+}
+
+// region returns the rect of the source bounds r which is drawn, applying
+// the sidecar crop and, when covering, cropping to the target aspect ratio.
+func (p *Processor) region(img image.Image, r image.Rectangle, sc *sidecar, t target) image.Rectangle {
+	// pages are cropped already
+	if t.index > 0 {
+		return t.page
+	}
+
+	if sc != nil {
+		r = sc.Crop(r)
+	}
+
+	if p.fit == "cover" {
+		ratio := t.aspect
+		if p.size.Width > 0 && p.size.Height > 0 {
+			ratio = float64(p.size.Width) / float64(p.size.Height)
+		}
+
+		c := cropAspect(r, ratio)
+		if a, ok := anchors[p.gravity]; ok {
+			c = anchored(c, r, a)
+		}
+		if p.gravity == "smart" && img != nil && c != r {
+			c = newSaliency(img, r).Crop(c.Dx(), c.Dy())
+		}
+		r = c
+	}
+
+	return r
+}
+
+// focus returns the point of the source rect sr which is kept closest to the
+// center of the canvas, or nil to center the source.
+func (p *Processor) focus(img image.Image, sr image.Rectangle) *point {
+	if p.gravity != "smart" || p.fit != "pad" || img == nil {
+		return nil
+	}
+
+	f := newSaliency(img, sr).Focus()
+	return &f
+}
+
+// layout returns the canvas and the rect within it where the source rect is drawn,
+// keeping the focus point as close to the center of the canvas as possible when given.
+func (p *Processor) layout(sr image.Rectangle, t target, focus *point) (db, dr image.Rectangle) {
+	sb := image.Rect(0, 0, sr.Dx(), sr.Dy())
+
+	// max dimensions
+	if p.maxSize.Width > 0 || p.maxSize.Height > 0 {
+		w := math.Inf(1)
+		if p.maxSize.Width > 0 {
+			w = float64(p.maxSize.Width)
+		}
+
+		h := math.Inf(1)
+		if p.maxSize.Height > 0 {
+			h = float64(p.maxSize.Height)
+		}
+
+		sb = fit(sb, w, h, false)
+	}
+
+	// margin, laid out as part of the source and inset afterwards
+	m := p.margin.pixels(sb)
+	if m != (Bars{}) {
+		if focus != nil {
+			focus = &point{
+				X: (float64(m.Left) + focus.X*float64(sb.Dx())) / float64(sb.Dx()+m.Left+m.Right),
+				Y: (float64(m.Top) + focus.Y*float64(sb.Dy())) / float64(sb.Dy()+m.Top+m.Bottom),
+			}
+		}
+		sb = image.Rect(0, 0, sb.Dx()+m.Left+m.Right, sb.Dy()+m.Top+m.Bottom)
+	}
+	mb := sb
+
+	// fit
+	sized := p.size.Width > 0 && p.size.Height > 0
+	w := float64(p.size.Width) / (1 + p.padding)
+	h := float64(p.size.Height) / (1 + p.padding)
+
+	switch {
+	case p.fit == "stretch" && sized:
+		db = image.Rect(0, 0, p.size.Width, p.size.Height)
+		sb = fit(db, w, h, true)
+	case p.fit == "stretch":
+		sb = aspect(sb, t.aspect, p.round)
+		db = padding(sb, p.padding, p.round)
+	case p.fit == "contain" && sized:
+		sb = fit(sb, w, h, p.upscale)
+		db = padding(sb, p.padding, p.round)
+	case p.fit == "contain":
+		db = padding(sb, p.padding, p.round)
+	case sized:
+		db = image.Rect(0, 0, p.size.Width, p.size.Height)
+		sb = fit(sb, w, h, p.upscale)
+	case p.padTo > 0:
+		db = image.Rect(0, 0, sb.Dx(), rounded(float64(sb.Dy())*(1+p.padTo), p.round))
+		db = padding(db, p.padding, p.round)
+	default:
+		db = aspect(sb, t.aspect, p.round)
+		db = padding(db, p.padding, p.round)
+	}
+
+	// even dimensions
+	if p.forceEven {
+		db.Max.X += db.Dx() % 2
+		db.Max.Y += db.Dy() % 2
+	}
+
+	dr = centered(sb, db, p.round)
+
+	if a, ok := anchors[p.gravity]; ok {
+		dr = anchored(sb, db, a)
+	}
+
+	if focus != nil {
+		dr = focused(sb, db, *focus)
+	}
+
+	// offset
+	dr = dr.Add(image.Pt(p.offset[0].pixels(db.Dx()), p.offset[1].pixels(db.Dy())))
+	dr = contained(dr, db)
+
+	// margin
+	dr = inset(dr, mb, m)
+
+	return
+}
+
+// targets returns the output targets, one per aspect ratio. When there are
+// multiple aspect ratios each is written to a sub-directory such as "16x9".
+func (p *Processor) targets() []target {
+	if len(p.aspects) == 1 {
+		a := p.aspects[0]
+		return []target{{dir: p.dir, name: strings.Replace(a.name, ":", "x", 1), aspect: a.ratio}}
+	}
+
+	var targets []target
+	for _, a := range p.aspects {
+		name := strings.Replace(a.name, ":", "x", 1)
+		targets = append(targets, target{
+			dir:    filepath.Join(p.dir, name),
+			name:   name,
+			aspect: a.ratio,
+		})
+	}
+	return targets
+}
+
+// sourceTargets returns the targets of the source, which is a single target
+// of the aspect ratio of its orientation when set.
+func (p *Processor) sourceTargets(src *source) ([]target, error) {
+	if p.portrait == nil && p.landscape == nil {
+		return p.targets(), nil
+	}
+
+	c, err := src.decodeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	r := image.Rect(0, 0, c.Width, c.Height)
+	if src.sidecar != nil {
+		r = src.sidecar.Crop(r)
+	}
+
+	return p.orientedTargets(r.Size()), nil
+}
+
+// Targets returns the number of results the source image at path produces,
+// one for each aspect ratio and split page, reading its header only when
+// oriented aspect ratios or splitting are used. Sources which can't be read
+// produce a single failed result.
+func (p *Processor) Targets(path string) int {
+	src := &source{path: path}
+
+	if p.sidecars {
+		sc, err := readSidecar(path)
+		if err != nil {
+			return 1
+		}
+		src.sidecar = sc
+	}
+
+	oriented, err := p.sourceTargets(src)
+	if err != nil {
+		return 1
+	}
+
+	n := 0
+	for _, t := range oriented {
+		pages, err := p.pages(src, t)
+		if err != nil {
+			return 1
+		}
+		n += len(pages)
+	}
+
+	return n
+}
+
+// orientedTargets returns the targets of a source of the given size, which
+// is a single target of the aspect ratio of its orientation when set.
+func (p *Processor) orientedTargets(size image.Point) []target {
+	a := p.landscape
+	if size.Y > size.X {
+		a = p.portrait
+	}
+
+	if a == nil {
+		return p.targets()
+	}
+
+	name := strings.Replace(a.name, ":", "x", 1)
+	return []target{{dir: p.dir, name: name, aspect: a.ratio}}
+}
+
+// output returns the output path for the source image, named by the
+// name template when present.
+func (p *Processor) output(t target, src *source) (string, error) {
+	rel := p.relative(src.path)
+
+	if p.name == nil {
+		return filepath.Join(t.dir, paged(withExt(rel, p.format), t)), nil
+	}
+
+	name, err := p.templateName(t, src)
+	if err != nil {
+		return "", fmt.Errorf("naming output: %w", err)
+	}
+
+	return filepath.Join(t.dir, filepath.Dir(rel), paged(name, t)), nil
+}
+
+// relative returns the source path relative to the output directory, which
+// is its base name, or its relative path when preserving paths. Parent
+// components and roots are dropped, so that it never escapes.
+func (p *Processor) relative(path string) string {
+	if !p.preserve {
+		return filepath.Base(path)
+	}
+
+	path = filepath.Clean(path)
+
+	// paths within a root or the working directory are made relative
+	roots := p.roots
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		for _, dir := range roots {
+			root, err := filepath.Abs(dir)
+			if err != nil {
+				continue
+			}
+
+			if rel, err := filepath.Rel(root, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+				break
+			}
+		}
+	}
+
+	path = strings.TrimPrefix(path, filepath.VolumeName(path))
+
+	var parts []string
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+
+	return filepath.Join(parts...)
+}
+
+// withColor returns the color specified.
+func withColor(white bool) color.Color {
+	if white {
+		return color.White
+	}
+	return color.Black
+}
+
+// writeImage writes a jpeg or png image to the given path atomically.
+func (p *Processor) writeImage(ctx context.Context, img image.Image, path string) error {
+	return writeAtomic(path, func(w io.Writer) error {
+		return p.encode(ctx, w, img)
+	})
+}
+
+// encode the image to w in the output format, failing once ctx is done.
+func (p *Processor) encode(ctx context.Context, w io.Writer, img image.Image) error {
+	var err error
+	w = contextWriter{ctx, w}
+
+	switch p.format {
+	case "png":
+		e := png.Encoder{CompressionLevel: p.compression}
+		err = e.Encode(w, img)
+	case "tiff":
+		err = tiff.Encode(w, img, &tiff.Options{
+			Compression: tiff.Deflate,
+			Predictor:   true,
+		})
+	default:
+		o := &jpeg.Options{Quality: p.quality}
+		if b := img.Bounds(); p.parallelEncode > 0 && b.Dx()*b.Dy() >= p.parallelEncode {
+			err = encodeBands(w, img, o)
+		} else {
+			err = jpeg.Encode(w, img, o)
+		}
+	}
+
+	if err != nil && ctx.Err() != nil {
+		return p.expired(ctx)
+	}
+
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+
+	return nil
+}
+
+// parseAspect returns a parsed aspect ratio, written as a ratio such as
+// "16:9", dimensions such as "1920x1080", or a number such as "1.777".
+// Portrait ratios such as "9:16" are kept as written.
+func parseAspect(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	// number
+	i := strings.IndexAny(s, ":x")
+	if i < 0 {
+		return aspectTerm(s)
+	}
+
+	// ratio or dimensions
+	a, err := aspectTerm(s[:i])
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := aspectTerm(s[i+1:])
+	if err != nil {
+		return 0, err
+	}
+
+	return a / b, nil
+}
+
+// aspectTerm returns the positive number s of an aspect ratio.
+func aspectTerm(s string) (float64, error) {
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(n > 0) || math.IsInf(n, 1) {
+		return 0, errors.New("must be a ratio such as 16:9, dimensions such as 1920x1080, or a positive number such as 1.777")
+	}
+	return n, nil
+}
+
+// withExt returns the path with an extension suitable for the format,
+// preserving the existing extension when it matches.
+func withExt(path, format string) string {
+	ext := filepath.Ext(path)
+
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		if format == "jpeg" {
+			return path
+		}
+	case ".png":
+		if format == "png" {
+			return path
+		}
+	case ".tif", ".tiff":
+		if format == "tiff" {
+			return path
+		}
+	}
+
+	switch format {
+	case "png":
+		return strings.TrimSuffix(path, ext) + ".png"
+	case "tiff":
+		return strings.TrimSuffix(path, ext) + ".tif"
+	}
+
+	return strings.TrimSuffix(path, ext) + ".jpg"
+}
+
+// exists returns true if the given path exists.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 // unmodified returns true if the output image already exists,
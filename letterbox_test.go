@@ -0,0 +1,211 @@
+package letterbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// blend returns the 8-bit channel s with alpha a over the opaque channel bg.
+func blend(s, bg, a uint8) uint8 {
+	return uint8((int(s)*int(a) + int(bg)*(255-int(a)) + 127) / 255)
+}
+
+// near returns true if the colors differ by at most one per channel.
+func near(a, b color.RGBA) bool {
+	d := func(x, y uint8) bool { d := int(x) - int(y); return d >= -1 && d <= 1 }
+	return d(a.R, b.R) && d(a.G, b.G) && d(a.B, b.B) && d(a.A, b.A)
+}
+
+func TestComposeTransparentEdges(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+
+	// opaque center, translucent edges of varying alpha, transparent corners
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c := red
+			switch {
+			case (x == 0 || x == 3) && (y == 0 || y == 3):
+				c.A = 0
+			case x == 0:
+				c.A = 64
+			case x == 3:
+				c.A = 128
+			case y == 0 || y == 3:
+				c.A = 192
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+
+	for _, bg := range []color.RGBA{{255, 255, 255, 255}, {0, 0, 255, 255}, {0, 0, 0, 255}} {
+		img, err := Compose(src, WithAspect("2:1"), WithBackground(bg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := img.Bounds(); got != image.Rect(0, 0, 8, 4) {
+			t.Fatalf("bounds = %v, want 8x4", got)
+		}
+
+		// pillarboxed at x 2 to 6
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 8; x++ {
+				want := bg
+				if x >= 2 && x < 6 {
+					a := src.NRGBAAt(x-2, y).A
+					want = color.RGBA{blend(255, bg.R, a), blend(0, bg.G, a), blend(0, bg.B, a), 255}
+				}
+
+				got := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+				if !near(got, want) {
+					t.Errorf("background %v at %d,%d = %v, want %v", bg, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestComposeTransparentScaled(t *testing.T) {
+	// fully transparent sources show the background when scaled, not black
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	bg := color.RGBA{255, 255, 255, 255}
+
+	img, err := Compose(src, WithSize(16, 8), WithUpscale(true), WithBackground(bg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if got := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA); got != bg {
+				t.Fatalf("at %d,%d = %v, want %v", x, y, got, bg)
+			}
+		}
+	}
+}
+
+// benchmarks draw a 4:3 source pillarboxed on a 4000x2250 canvas.
+var (
+	benchCanvas = image.Rect(0, 0, 4000, 2250)
+	benchRect   = image.Rect(500, 0, 3500, 2250)
+)
+
+func TestProcessConcurrent(t *testing.T) {
+	dir := t.TempDir()
+
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, testPhoto(64, 48, false), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// calls with sources of the same names in separate directories
+	calls := make([][]string, 2)
+	for i := range calls {
+		for j := 0; j < 4; j++ {
+			path := filepath.Join(dir, fmt.Sprint(i), fmt.Sprintf("%d-%d.jpg", i, j))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			calls[i] = append(calls[i], path)
+		}
+	}
+
+	var results []Result
+	p, err := New(filepath.Join(dir, "processed"),
+		WithConcurrency(2),
+		WithIOConcurrency(2),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithResultHandler(func(r Result) {
+			results = append(results, r)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var g errgroup.Group
+	for _, images := range calls {
+		images := images
+		g.Go(func() error {
+			return p.Process(context.Background(), images)
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 8 {
+		t.Fatalf("got %d results, want 8", len(results))
+	}
+
+	for _, r := range results {
+		if r.Error != "" {
+			t.Errorf("%s: %s", r.Source, r.Error)
+		}
+	}
+}
+
+func BenchmarkFill(b *testing.B) {
+	p, err := New("", WithBackground(color.RGBA{20, 40, 60, 255}))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	dst := image.NewRGBA(benchCanvas)
+
+	// the fast path fills the bars only, and the generic path the canvas
+	// beneath sources drawn over it
+	for _, c := range []struct {
+		name string
+		over bool
+	}{{"bars", false}, {"canvas", true}} {
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(dst.Pix)))
+			for i := 0; i < b.N; i++ {
+				p.fill(dst, benchRect, c.over)
+			}
+		})
+	}
+}
+
+func BenchmarkPaint(b *testing.B) {
+	p, err := New("")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	dst := image.NewRGBA(benchCanvas)
+	src := image.NewRGBA(image.Rect(0, 0, benchRect.Dx(), benchRect.Dy()))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+
+	// the fast path copies opaque sources, and the generic path blends them
+	for _, c := range []struct {
+		name string
+		over bool
+	}{{"src", false}, {"over", true}} {
+		b.Run(c.name, func(b *testing.B) {
+			b.SetBytes(int64(len(src.Pix)))
+			for i := 0; i < b.N; i++ {
+				p.paint(dst, benchRect, src, src.Bounds(), p.resampler, nil, c.over)
+			}
+		})
+	}
+}
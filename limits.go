@@ -0,0 +1,78 @@
+package letterbox
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// bytesPerPixel is the estimated size of decoded pixels.
+const bytesPerPixel = 4
+
+// WithMaxPixels changes the maximum number of pixels of source images, checked
+// from their header before decoding, which defaults to no limit.
+func WithMaxPixels(n int) Option {
+	return func(p *Processor) error {
+		p.maxPixels = n
+		return nil
+	}
+}
+
+// WithMaxMemory changes the maximum bytes of decoded images in-flight across
+// workers, estimated from their header at 4 bytes per pixel, which defaults to
+// no limit. Images estimated larger than the limit are decoded alone.
+func WithMaxMemory(n int64) Option {
+	return func(p *Processor) error {
+		p.maxMemory = n
+		p.memory = nil
+		if n > 0 {
+			p.memory = semaphore.NewWeighted(n)
+		}
+		return nil
+	}
+}
+
+// admit checks the source dimensions against the limits before it is decoded,
+// reserving its estimated memory until released.
+func (p *Processor) admit(ctx context.Context, src *source) error {
+	if src.img != nil || (p.maxPixels <= 0 && p.memory == nil) {
+		return nil
+	}
+
+	c, err := src.decodeConfig()
+	if err != nil {
+		return err
+	}
+
+	// pixels
+	pixels := c.Width * c.Height
+	if p.maxPixels > 0 && pixels > p.maxPixels {
+		return fmt.Errorf("image is %dx%d, exceeding the maximum of %d pixels", c.Width, c.Height, p.maxPixels)
+	}
+
+	// memory
+	if p.memory == nil {
+		return nil
+	}
+
+	n := int64(pixels) * bytesPerPixel
+	if src.scale > 1 {
+		n /= int64(src.scale * src.scale)
+	}
+	n = min(n, p.maxMemory)
+	if err := p.memory.Acquire(ctx, n); err != nil {
+		return fmt.Errorf("waiting for memory: %w", err)
+	}
+	src.reserved = n
+
+	return nil
+}
+
+// release the memory reserved for the source.
+func (p *Processor) release(src *source) {
+	if src.reserved > 0 {
+		p.memory.Release(src.reserved)
+		src.reserved = 0
+	}
+}
@@ -0,0 +1,248 @@
+package letterbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// MetadataBackend copies metadata from a source image to its output.
+type MetadataBackend interface {
+	CopyMetadata(src, dst string) error
+}
+
+// GoMetadata is a pure-Go metadata backend which copies the EXIF, XMP,
+// ICC and IPTC segments of jpeg images, and the ICC profile alone to png and
+// tiff outputs. As outputs are composed from the stored pixels, the EXIF
+// orientation is reset and the source thumbnail dropped.
+type GoMetadata struct{}
+
+// CopyMetadata implementation.
+func (GoMetadata) CopyMetadata(src, dst string) error {
+	sb, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	// non-jpeg sources have nothing to copy
+	if !isJPEG(sb) {
+		return nil
+	}
+
+	segments, err := metadataSegments(sb)
+	if err != nil {
+		return err
+	}
+
+	if len(segments) == 0 {
+		return nil
+	}
+
+	db, err := ioutil.ReadFile(dst)
+	if err != nil {
+		return err
+	}
+
+	if isPNG(db) || isTIFF(db) {
+		return copyProfile(segments, dst, db)
+	}
+
+	if !isJPEG(db) {
+		return errors.New("output format does not support metadata")
+	}
+
+	// insert the segments directly after SOI
+	var buf bytes.Buffer
+	buf.Write(db[:2])
+	for _, s := range segments {
+		if bytes.HasPrefix(s[4:], exifHeader) {
+			s, err = uprightExif(s)
+			if err != nil {
+				return err
+			}
+		}
+		buf.Write(s)
+	}
+	buf.Write(db[2:])
+
+	return writeFileAtomic(dst, buf.Bytes())
+}
+
+// copyProfile embeds the ICC profile of the jpeg segments in the png or tiff
+// output db at dst, failing when other metadata is not preserved.
+func copyProfile(segments [][]byte, dst string, db []byte) error {
+	profile, err := iccProfile(segments)
+	if err != nil {
+		return err
+	}
+
+	if profile != nil {
+		if isPNG(db) {
+			db, err = embedPNG(db, profile)
+		} else {
+			db, err = embedTIFF(db, profile)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := writeFileAtomic(dst, db); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range segments {
+		if !isICC(s) {
+			return errors.New("output format supports the ICC profile only")
+		}
+	}
+
+	return nil
+}
+
+// ExifTool is a metadata backend which delegates to exiftool,
+// providing full tag fidelity including MakerNotes and XMP sidecars. The
+// orientation is reset and the source thumbnail dropped as with GoMetadata.
+type ExifTool struct {
+	// Path to the exiftool binary.
+	Path string
+}
+
+// NewExifTool returns an exiftool metadata backend,
+// or an error if it is not installed.
+func NewExifTool() (*ExifTool, error) {
+	path, err := exec.LookPath("exiftool")
+	if err != nil {
+		return nil, fmt.Errorf("exiftool is required: %w", err)
+	}
+	return &ExifTool{Path: path}, nil
+}
+
+// CopyMetadata implementation.
+func (e *ExifTool) CopyMetadata(src, dst string) error {
+	args := []string{
+		"-q",
+		"-overwrite_original",
+		"-TagsFromFile", src,
+		"-all:all",
+		"-icc_profile",
+		"-Orientation#=1",
+		"-ThumbnailImage=",
+	}
+
+	// XMP sidecar
+	if sidecar := sidecarPath(src); exists(sidecar) {
+		args = append(args, "-TagsFromFile", sidecar, "-xmp:all")
+	}
+
+	args = append(args, dst)
+
+	out, err := exec.Command(e.Path, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("exiftool: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+
+	return nil
+}
+
+// uprightExif returns a copy of the EXIF APP1 segment s with its orientation
+// reset to 1 and its IFD1 thumbnail unreferenced.
+func uprightExif(s []byte) ([]byte, error) {
+	s = append([]byte{}, s...)
+	tiff := s[4+len(exifHeader):]
+
+	i, order, err := orientationEntry(tiff)
+	if err != nil {
+		return nil, err
+	}
+
+	if i >= 0 {
+		order.PutUint16(tiff[i:], 1)
+	}
+
+	// IFD0 next offset
+	ifd0 := int(order.Uint32(tiff[4:]))
+	next := ifd0 + 2 + 12*int(order.Uint16(tiff[ifd0:]))
+	if next+4 > len(tiff) {
+		return nil, errors.New("invalid exif IFD0")
+	}
+	order.PutUint32(tiff[next:], 0)
+
+	return s, nil
+}
+
+// sidecarPath returns the XMP sidecar path for an image.
+func sidecarPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".xmp"
+}
+
+// hasICC returns true if the image at path is a jpeg with an ICC profile.
+func hasICC(path string) bool {
+	b, err := ioutil.ReadFile(path)
+	if err != nil || !isJPEG(b) {
+		return false
+	}
+
+	segments, err := metadataSegments(b)
+	if err != nil {
+		return false
+	}
+
+	for _, s := range segments {
+		if isICC(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isJPEG returns true if b starts with a jpeg SOI marker.
+func isJPEG(b []byte) bool {
+	return len(b) > 2 && b[0] == 0xFF && b[1] == 0xD8
+}
+
+// metadataSegments returns the raw APP1 (EXIF, XMP), APP2 (ICC)
+// and APP13 (IPTC) segments of a jpeg, including their markers.
+func metadataSegments(b []byte) (segments [][]byte, err error) {
+	i := 2
+	for i+4 <= len(b) {
+		if b[i] != 0xFF {
+			return nil, errors.New("invalid jpeg marker")
+		}
+
+		marker := b[i+1]
+
+		// padding
+		if marker == 0xFF {
+			i++
+			continue
+		}
+
+		// start of scan, no more metadata
+		if marker == 0xDA {
+			break
+		}
+
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		end := i + 2 + n
+		if n < 2 || end > len(b) {
+			return nil, errors.New("invalid jpeg segment length")
+		}
+
+		switch marker {
+		case 0xE1, 0xE2, 0xED:
+			segments = append(segments, b[i:end])
+		}
+
+		i = end
+	}
+
+	return
+}
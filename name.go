@@ -0,0 +1,234 @@
+package letterbox
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Name is the data available to output name templates.
+type Name struct {
+	// Base is the source filename without its extension, such as "photo".
+	Base string
+
+	// Ext is the output extension, such as ".jpg".
+	Ext string
+
+	// Dir is the source directory.
+	Dir string
+
+	// Aspect is the aspect ratio, such as "16x9".
+	Aspect string
+
+	// Width is the output width in pixels.
+	Width int
+
+	// Height is the output height in pixels.
+	Height int
+
+	// Format is the output format, such as "jpeg".
+	Format string
+
+	// Date is the modification time of the source.
+	Date time.Time
+}
+
+// WithNameTemplate changes the output filename to a text/template such as
+// "{{.Base}}_{{.Aspect}}_{{.Width}}x{{.Height}}{{.Ext}}", executed with a
+// Name. Outputs are placed in the same relative directory as their source
+// when preserving paths.
+func WithNameTemplate(s string) Option {
+	return func(p *Processor) error {
+		t, err := template.New("name").Option("missingkey=error").Parse(s)
+		if err != nil {
+			return fmt.Errorf("parsing name template: %w", err)
+		}
+		p.name = t
+		return nil
+	}
+}
+
+// templateName returns the output filename from the name template. The
+// dimensions are computed from the image header without decoding.
+func (p *Processor) templateName(t target, src *source) (string, error) {
+	info, err := os.Stat(src.path)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := src.decodeConfig()
+	if err != nil {
+		return "", err
+	}
+
+	sr := p.region(nil, image.Rect(0, 0, c.Width, c.Height), src.sidecar, t)
+	db, _ := p.layout(sr, t, nil)
+
+	ext := filepath.Ext(withExt(src.path, p.format))
+	base := filepath.Base(src.path)
+
+	var b strings.Builder
+	err = p.name.Execute(&b, Name{
+		Base:   strings.TrimSuffix(base, filepath.Ext(base)),
+		Ext:    ext,
+		Dir:    filepath.Dir(src.path),
+		Aspect: t.name,
+		Width:  db.Dx(),
+		Height: db.Dy(),
+		Format: p.format,
+		Date:   info.ModTime(),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	if b.Len() == 0 {
+		return "", fmt.Errorf("empty name")
+	}
+
+	return b.String(), nil
+}
+
+// Mapping is the output of a source for a target, as previewed by Names.
+type Mapping struct {
+	// Source is the source path.
+	Source string `json:"source"`
+
+	// Output is the output path, empty when it could not be named.
+	Output string `json:"output,omitempty"`
+
+	// Problems are the reasons the output would fail or clobber another.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// maxNameLength is the maximum length of a file name in bytes on most
+// filesystems.
+const maxNameLength = 255
+
+// maxPathLength returns the maximum length of a path on the platform.
+func maxPathLength() int {
+	if runtime.GOOS == "windows" {
+		return 259
+	}
+	return 4095
+}
+
+// Names returns the output of each image for each target without processing,
+// reading image headers only, for previewing name templates. Problems are
+// reported for naming errors, outputs shared by several sources or differing
+// only in case, which collide on case-insensitive filesystems, outputs
+// escaping the output directory, and names or paths which are too long.
+func (p *Processor) Names(images []string) []Mapping {
+	var mappings []Mapping
+
+	for _, path := range images {
+		src := &source{path: path}
+
+		if p.sidecars {
+			sc, err := readSidecar(path)
+			if err != nil {
+				mappings = append(mappings, Mapping{Source: path, Problems: []string{fmt.Sprintf("reading sidecar: %s", err)}})
+				continue
+			}
+			src.sidecar = sc
+		}
+
+		targets, err := p.sourceTargets(src)
+		if err != nil {
+			mappings = append(mappings, Mapping{Source: path, Problems: []string{err.Error()}})
+			continue
+		}
+
+		for _, t := range targets {
+			pages, err := p.pages(src, t)
+			if err != nil {
+				mappings = append(mappings, Mapping{Source: path, Problems: []string{fmt.Sprintf("splitting: %s", err)}})
+				continue
+			}
+
+			for _, t := range pages {
+				m := Mapping{Source: path}
+				m.Output, err = p.output(t, src)
+				if err != nil {
+					m.Problems = append(m.Problems, err.Error())
+				} else {
+					m.Problems = append(m.Problems, p.pathProblems(m.Output)...)
+				}
+				mappings = append(mappings, m)
+			}
+		}
+	}
+
+	// collisions
+	sources := make(map[string][]string)
+	for _, m := range mappings {
+		if m.Output != "" && !slices.Contains(sources[m.Output], m.Source) {
+			sources[m.Output] = append(sources[m.Output], m.Source)
+		}
+	}
+
+	folded := make(map[string][]string)
+	for output := range sources {
+		folded[strings.ToLower(output)] = append(folded[strings.ToLower(output)], output)
+	}
+
+	for i, m := range mappings {
+		if m.Output == "" {
+			continue
+		}
+
+		var others []string
+		for _, s := range sources[m.Output] {
+			if s != m.Source {
+				others = append(others, s)
+			}
+		}
+
+		if len(others) > 0 {
+			mappings[i].Problems = append(mappings[i].Problems, fmt.Sprintf("collides with the output of %s", strings.Join(others, ", ")))
+		}
+
+		var cased []string
+		for _, o := range folded[strings.ToLower(m.Output)] {
+			if o != m.Output {
+				cased = append(cased, o)
+			}
+		}
+
+		if len(cased) > 0 {
+			sort.Strings(cased)
+			mappings[i].Problems = append(mappings[i].Problems, fmt.Sprintf("differs only in case from %s, colliding on case-insensitive filesystems", strings.Join(cased, ", ")))
+		}
+	}
+
+	return mappings
+}
+
+// pathProblems returns the problems of the output path, which must be within
+// the output directory and of a length the filesystem supports.
+func (p *Processor) pathProblems(output string) (problems []string) {
+	rel, err := filepath.Rel(p.dir, output)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		problems = append(problems, "escapes the output directory")
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(output), "/") {
+		if len(part) > maxNameLength {
+			problems = append(problems, fmt.Sprintf("name %.20s... is %d bytes, over the limit of %d", part, len(part), maxNameLength))
+		}
+	}
+
+	if abs, err := filepath.Abs(output); err == nil && len(abs) > maxPathLength() {
+		problems = append(problems, fmt.Sprintf("path is %d bytes, over the limit of %d", len(abs), maxPathLength()))
+	}
+
+	return
+}
@@ -0,0 +1,193 @@
+package letterbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+)
+
+// Orientation returns the EXIF orientation of the image at path from 1 to 8,
+// which is 1 for images without one, including non-jpeg images.
+func Orientation(path string) (int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	if !isJPEG(b) {
+		return 1, nil
+	}
+
+	start, end, err := exifSegment(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if start == 0 {
+		return 1, nil
+	}
+
+	tiff := b[start+4+len(exifHeader) : end]
+	i, order, err := orientationEntry(tiff)
+	if err != nil {
+		return 0, err
+	}
+
+	if i < 0 {
+		return 1, nil
+	}
+
+	o := int(order.Uint16(tiff[i:]))
+	if o < 1 || o > 8 {
+		return 1, nil
+	}
+
+	return o, nil
+}
+
+// FixOrientation rotates and flips the pixels of the jpeg at path as its
+// EXIF orientation describes, and resets the orientation to 1, preserving
+// its other metadata. Images which are already upright are left untouched,
+// others are re-encoded with the given jpeg quality.
+func FixOrientation(path string, quality int) error {
+	o, err := Orientation(path)
+	if err != nil {
+		return err
+	}
+
+	if o == 1 {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	segments, err := metadataSegments(b)
+	if err != nil {
+		return err
+	}
+
+	// decode
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	// encode
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, orient(img, o), &jpeg.Options{Quality: quality})
+	if err != nil {
+		return fmt.Errorf("encoding: %w", err)
+	}
+
+	// metadata, with the orientation reset
+	out := buf.Bytes()
+	var dst bytes.Buffer
+	dst.Write(out[:2])
+	for _, s := range segments {
+		if bytes.HasPrefix(s[4:], exifHeader) {
+			s = append([]byte{}, s...)
+			tiff := s[4+len(exifHeader):]
+			i, order, err := orientationEntry(tiff)
+			if err != nil {
+				return err
+			}
+			if i >= 0 {
+				order.PutUint16(tiff[i:], 1)
+			}
+		}
+		dst.Write(s)
+	}
+	dst.Write(out[2:])
+
+	return writeFileAtomic(path, dst.Bytes())
+}
+
+// orientationEntry returns the offset of the orientation value in the IFD0
+// of the TIFF data tiff and its byte order, or -1 when there is none.
+func orientationEntry(tiff []byte) (int, binary.ByteOrder, error) {
+	if len(tiff) < 8 {
+		return 0, nil, errors.New("invalid exif data")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, nil, errors.New("invalid exif byte order")
+	}
+
+	ifd0 := int(order.Uint32(tiff[4:]))
+	if ifd0+2 > len(tiff) {
+		return 0, nil, errors.New("invalid exif IFD0 offset")
+	}
+
+	n := int(order.Uint16(tiff[ifd0:]))
+	for i := 0; i < n; i++ {
+		e := ifd0 + 2 + 12*i
+		if e+12 > len(tiff) {
+			return 0, nil, errors.New("invalid exif IFD0")
+		}
+
+		// orientation, SHORT
+		if order.Uint16(tiff[e:]) == 0x0112 && order.Uint16(tiff[e+2:]) == 3 {
+			return e + 8, order, nil
+		}
+	}
+
+	return -1, order, nil
+}
+
+// orient returns img transformed to display upright for the EXIF orientation o.
+func orient(img image.Image, o int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	// orientations 5 to 8 transpose the dimensions
+	size := image.Pt(w, h)
+	if o >= 5 {
+		size = image.Pt(h, w)
+	}
+
+	dst := image.NewRGBA(image.Rectangle{Max: size})
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+
+	return dst
+}
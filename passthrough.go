@@ -0,0 +1,195 @@
+package letterbox
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// WithTolerance changes the relative difference from the target aspect ratio
+// within which sources are passed through untouched, such as 0.02 for 2%,
+// rather than re-encoded with a bar of a pixel or two. Sources are only passed
+// through when they would be drawn unscaled and uncropped in the output
+// format, without rounded corners, effects or a watermark, and with bars no
+// larger than the tolerance of each dimension. Defaults to 0 for none.
+func WithTolerance(v float64) Option {
+	return func(p *Processor) error {
+		if v < 0 || v >= 1 {
+			return fmt.Errorf("invalid tolerance %v, must be from 0 to 1", v)
+		}
+
+		p.tolerance = v
+		return nil
+	}
+}
+
+// WithHardLinks changes whether or not sources passed through are hard-linked
+// rather than copied, falling back to a copy across filesystems. Linked
+// outputs share the file of their source, so changing one changes the other.
+func WithHardLinks(v bool) Option {
+	return func(p *Processor) error {
+		p.links = v
+		return nil
+	}
+}
+
+// passable returns the dimensions of the source, and true if it may be passed
+// through untouched for the target, reading its header only.
+func (p *Processor) passable(src *source, t target) (image.Rectangle, bool, error) {
+	if p.tolerance == 0 || t.index > 0 || p.radius > 0 || len(p.effects()) > 0 || p.watermark != nil {
+		return image.ZR, false, nil
+	}
+
+	f, err := os.Open(src.path)
+	if err != nil {
+		return image.ZR, false, fmt.Errorf("opening: %w", err)
+	}
+	defer f.Close()
+
+	c, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return image.ZR, false, fmt.Errorf("decoding config: %w", unsupported(src.path, err))
+	}
+
+	b := image.Rect(0, 0, c.Width, c.Height)
+	if format != p.format || b.Empty() {
+		return b, false, nil
+	}
+
+	// ratio
+	ratio := float64(c.Width) / float64(c.Height)
+	if math.Abs(ratio-t.aspect)/t.aspect > p.tolerance {
+		return b, false, nil
+	}
+
+	// drawn unscaled and uncropped, with thin bars
+	sr := p.region(nil, b, src.sidecar, t)
+	if sr != b {
+		return b, false, nil
+	}
+
+	db, dr := p.layout(sr, t, nil)
+	if dr.Size() != sr.Size() {
+		return b, false, nil
+	}
+
+	thin := float64(db.Dx()-dr.Dx()) <= p.tolerance*float64(db.Dx()) &&
+		float64(db.Dy()-dr.Dy()) <= p.tolerance*float64(db.Dy())
+
+	return b, thin, nil
+}
+
+// passThrough writes the source untouched to the output, hard-linked or
+// copied, with its metadata as-is.
+func (p *Processor) passThrough(res *Result, src *source, b image.Rectangle) error {
+	path := res.Source
+	dstpath := res.Output
+
+	res.Original = Size{b.Dx(), b.Dy()}
+	res.Final = res.Original
+	res.Layout = LayoutNone
+	res.Scale = 1
+	res.Passthrough = true
+
+	if p.dryRun {
+		p.log.Info("Would pass through", "path", path, "output", dstpath)
+		return nil
+	}
+
+	p.log.Info("Passing through", "path", path, "output", dstpath)
+
+	// written in place already
+	si, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	if di, err := os.Stat(dstpath); err == nil && os.SameFile(si, di) {
+		res.Bytes = si.Size()
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dstpath), 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+
+	// backup
+	if res.Overwritten && p.backups != "" {
+		res.Backup, err = p.backup(dstpath)
+		if err != nil {
+			return fmt.Errorf("backing up: %w", err)
+		}
+	}
+
+	if !p.links || link(path, dstpath) != nil {
+		if err := copyFile(path, dstpath); err != nil {
+			return fmt.Errorf("copying: %w", err)
+		}
+	}
+
+	// sidecar
+	if src.sidecar != nil {
+		if err := writeSidecar(dstpath, src.sidecar); err != nil {
+			return fmt.Errorf("writing sidecar: %w", err)
+		}
+	}
+
+	// times
+	if p.preserveTimes {
+		if err := preserveTimes(path, dstpath); err != nil {
+			p.warn(res, fmt.Sprintf("times not preserved: %s", err))
+		}
+	}
+
+	res.Bytes = si.Size()
+
+	// record
+	if r, ok := p.skip.(SkipRecorder); ok {
+		if err := r.Record(path, dstpath); err != nil {
+			return fmt.Errorf("recording: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// link hard-links dst to src, replacing any existing file atomically.
+func link(src, dst string) error {
+	f, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Name()
+	f.Close()
+	os.Remove(tmp)
+
+	if err := os.Link(src, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// copyFile copies the file at src to dst atomically.
+func copyFile(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeAtomic(dst, func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	})
+}
@@ -0,0 +1,39 @@
+package letterbox
+
+import (
+	"image"
+	"image/draw"
+)
+
+// canvas returns an RGBA image of bounds r, reusing the pixels of a recycled
+// canvas when large enough. The pixels are not cleared, as every pixel of a
+// canvas is either filled or painted. Canvases of 16-bit depth are RGBA64
+// and not pooled.
+func (p *Processor) canvas(r image.Rectangle) draw.Image {
+	if p.depth == 16 {
+		return image.NewRGBA64(r)
+	}
+
+	n := r.Dx() * r.Dy() * 4
+	if pix, ok := p.canvases.Get().(*[]uint8); ok && cap(*pix) >= n {
+		return &image.RGBA{
+			Pix:    (*pix)[:n],
+			Stride: r.Dx() * 4,
+			Rect:   r,
+		}
+	}
+
+	return image.NewRGBA(r)
+}
+
+// recycle the pixels of the canvas for reuse by another image, which
+// must no longer be referenced.
+func (p *Processor) recycle(img draw.Image) {
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		return
+	}
+
+	pix := rgba.Pix[:cap(rgba.Pix)]
+	p.canvases.Put(&pix)
+}
@@ -0,0 +1,201 @@
+package letterbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io/ioutil"
+)
+
+// ExtractPreview writes the largest JPEG preview embedded in the camera RAW
+// at path, such as CR2, NEF, ARW or DNG, to dst. Previews are rotated upright
+// for the orientation of the RAW and re-encoded with the given jpeg quality
+// when needed, and written as-is otherwise.
+func ExtractPreview(path, dst string, quality int) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	preview, err := rawPreview(b)
+	if err != nil {
+		return err
+	}
+
+	o := 1
+	if i, order, err := orientationEntry(b); err == nil && i >= 0 {
+		if v := int(order.Uint16(b[i:])); v >= 1 && v <= 8 {
+			o = v
+		}
+	}
+
+	if o == 1 {
+		return writeFileAtomic(dst, preview)
+	}
+
+	// decode
+	img, err := jpeg.Decode(bytes.NewReader(preview))
+	if err != nil {
+		return fmt.Errorf("decoding preview: %w", err)
+	}
+
+	// encode
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, orient(img, o), &jpeg.Options{Quality: quality})
+	if err != nil {
+		return fmt.Errorf("encoding preview: %w", err)
+	}
+
+	return writeFileAtomic(dst, buf.Bytes())
+}
+
+// TIFF tags of embedded previews.
+const (
+	tagCompression     = 0x0103
+	tagStripOffsets    = 0x0111
+	tagStripCounts     = 0x0117
+	tagSubIFDs         = 0x014a
+	tagJPEGOffset      = 0x0201
+	tagJPEGLength      = 0x0202
+	compressionOldJPEG = 6
+	compressionJPEG    = 7
+)
+
+// rawPreview returns the largest baseline JPEG embedded in the TIFF-based RAW
+// b, found in the IFD chain and its sub-IFDs. Lossless JPEG sensor data, which
+// is not decodable, is ignored.
+func rawPreview(b []byte) ([]byte, error) {
+	if len(b) < 8 {
+		return nil, errors.New("invalid raw")
+	}
+
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("unsupported raw, must be TIFF-based such as CR2, NEF, ARW or DNG")
+	}
+
+	var best []byte
+	var area int
+	seen := make(map[int]bool)
+
+	consider := func(offset, length int) {
+		if offset <= 0 || length <= 0 || offset+length > len(b) {
+			return
+		}
+
+		data := b[offset : offset+length]
+		c, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+
+		if a := c.Width * c.Height; a > area {
+			best, area = data, a
+		}
+	}
+
+	var walk func(ifd int)
+	walk = func(ifd int) {
+		for ifd > 0 && ifd+2 <= len(b) && !seen[ifd] {
+			seen[ifd] = true
+
+			n := int(order.Uint16(b[ifd:]))
+			if ifd+2+12*n+4 > len(b) {
+				return
+			}
+
+			values := make(map[uint16][]int)
+			for i := 0; i < n; i++ {
+				e := ifd + 2 + 12*i
+				values[order.Uint16(b[e:])] = tiffValues(b, order, e)
+			}
+
+			// JPEGInterchangeFormat previews
+			if off, ok := first(values[tagJPEGOffset]); ok {
+				if length, ok := first(values[tagJPEGLength]); ok {
+					consider(off, length)
+				}
+			}
+
+			// single strip jpeg previews
+			if c, _ := first(values[tagCompression]); c == compressionOldJPEG || c == compressionJPEG {
+				if off := values[tagStripOffsets]; len(off) == 1 {
+					if length := values[tagStripCounts]; len(length) == 1 {
+						consider(off[0], length[0])
+					}
+				}
+			}
+
+			for _, sub := range values[tagSubIFDs] {
+				walk(sub)
+			}
+
+			ifd = int(order.Uint32(b[ifd+2+12*n:]))
+		}
+	}
+
+	walk(int(order.Uint32(b[4:])))
+
+	if best == nil {
+		return nil, errors.New("no jpeg preview found")
+	}
+
+	return best, nil
+}
+
+// tiffValues returns the SHORT or LONG values of the IFD entry at e, or
+// nil for other types.
+func tiffValues(b []byte, order binary.ByteOrder, e int) []int {
+	typ := order.Uint16(b[e+2:])
+	count := int(order.Uint32(b[e+4:]))
+
+	size := 0
+	switch typ {
+	case 3:
+		size = 2
+	case 4, 13:
+		size = 4
+	default:
+		return nil
+	}
+
+	if count <= 0 || count > len(b)/size {
+		return nil
+	}
+
+	// values of 4 bytes or less are inline
+	at := e + 8
+	if count*size > 4 {
+		at = int(order.Uint32(b[e+8:]))
+	}
+
+	if at < 0 || at+count*size > len(b) {
+		return nil
+	}
+
+	values := make([]int, count)
+	for i := range values {
+		if size == 2 {
+			values[i] = int(order.Uint16(b[at+2*i:]))
+		} else {
+			values[i] = int(order.Uint32(b[at+4*i:]))
+		}
+	}
+
+	return values
+}
+
+// first returns the first value, or false when there are none.
+func first(values []int) (int, bool) {
+	if len(values) == 0 {
+		return 0, false
+	}
+	return values[0], true
+}
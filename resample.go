@@ -0,0 +1,55 @@
+package letterbox
+
+import (
+	"fmt"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Lanczos is a Lanczos3 resampling kernel, producing sharper
+// downscaled images than Catmull-Rom at a higher cost.
+var Lanczos = &xdraw.Kernel{
+	Support: 3,
+	At: func(t float64) float64 {
+		if t == 0 {
+			return 1
+		}
+		if t >= 3 {
+			return 0
+		}
+		return sinc(t) * sinc(t/3)
+	},
+}
+
+// sinc returns the normalized sinc of t.
+func sinc(t float64) float64 {
+	t *= math.Pi
+	return math.Sin(t) / t
+}
+
+// resampler returns the resampler by name.
+func resampler(name string) (xdraw.Scaler, error) {
+	switch name {
+	case "lanczos":
+		return Lanczos, nil
+	case "catmull-rom":
+		return xdraw.CatmullRom, nil
+	case "bilinear":
+		return xdraw.BiLinear, nil
+	case "nearest":
+		return xdraw.NearestNeighbor, nil
+	default:
+		return nil, fmt.Errorf("unsupported resampler %q", name)
+	}
+}
+
+// resamplerName returns the name of the resampler r.
+func resamplerName(r xdraw.Scaler) string {
+	for _, name := range []string{"lanczos", "catmull-rom", "bilinear", "nearest"} {
+		if s, _ := resampler(name); s == r {
+			return name
+		}
+	}
+	return fmt.Sprintf("%T", r)
+}
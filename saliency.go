@@ -0,0 +1,135 @@
+package letterbox
+
+import (
+	"image"
+	"math"
+)
+
+// saliencyCells is the number of cells along the longest side of a saliency map.
+const saliencyCells = 64
+
+// point is a normalized point, from 0-1 on each axis.
+type point struct {
+	X, Y float64
+}
+
+// saliency is a coarse edge-density map of an image region, used to keep
+// subjects in frame when cropping and placing images.
+type saliency struct {
+	r      image.Rectangle
+	cell   float64
+	w, h   int
+	energy []float64
+}
+
+// newSaliency returns the saliency map of rect r in img.
+func newSaliency(img image.Image, r image.Rectangle) *saliency {
+	cell := math.Max(1, math.Ceil(float64(max(r.Dx(), r.Dy()))/saliencyCells))
+	w := max(1, int(float64(r.Dx())/cell))
+	h := max(1, int(float64(r.Dy())/cell))
+
+	// luminance sampled at the center of each cell
+	lum := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			px := r.Min.X + int((float64(x)+0.5)*cell)
+			py := r.Min.Y + int((float64(y)+0.5)*cell)
+			cr, cg, cb, _ := img.At(px, py).RGBA()
+			lum[y*w+x] = 0.299*float64(cr) + 0.587*float64(cg) + 0.114*float64(cb)
+		}
+	}
+
+	// gradient magnitude
+	at := func(x, y int) float64 {
+		x = min(max(x, 0), w-1)
+		y = min(max(y, 0), h-1)
+		return lum[y*w+x]
+	}
+
+	energy := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx := at(x+1, y) - at(x-1, y)
+			dy := at(x, y+1) - at(x, y-1)
+			energy[y*w+x] = math.Abs(dx) + math.Abs(dy)
+		}
+	}
+
+	return &saliency{
+		r:      r,
+		cell:   cell,
+		w:      w,
+		h:      h,
+		energy: energy,
+	}
+}
+
+// Focus returns the energy-weighted centroid of the region,
+// or its center when it has no edges at all.
+func (s *saliency) Focus() point {
+	var sum, sx, sy float64
+	for y := 0; y < s.h; y++ {
+		for x := 0; x < s.w; x++ {
+			e := s.energy[y*s.w+x]
+			sum += e
+			sx += e * (float64(x) + 0.5)
+			sy += e * (float64(y) + 0.5)
+		}
+	}
+
+	if sum == 0 {
+		return point{0.5, 0.5}
+	}
+
+	return point{
+		X: sx / sum / float64(s.w),
+		Y: sy / sum / float64(s.h),
+	}
+}
+
+// Crop returns the w by h rect within the region containing the most energy.
+func (s *saliency) Crop(w, h int) image.Rectangle {
+	ww := min(s.w, max(1, int(math.Round(float64(w)/s.cell))))
+	wh := min(s.h, max(1, int(math.Round(float64(h)/s.cell))))
+
+	// summed-area table
+	sat := make([]float64, (s.w+1)*(s.h+1))
+	for y := 0; y < s.h; y++ {
+		for x := 0; x < s.w; x++ {
+			i := (y+1)*(s.w+1) + x + 1
+			sat[i] = s.energy[y*s.w+x] + sat[i-1] + sat[i-(s.w+1)] - sat[i-(s.w+1)-1]
+		}
+	}
+
+	sum := func(x0, y0, x1, y1 int) float64 {
+		return sat[y1*(s.w+1)+x1] - sat[y0*(s.w+1)+x1] - sat[y1*(s.w+1)+x0] + sat[y0*(s.w+1)+x0]
+	}
+
+	// best window, preferring the center on ties
+	best := -1.0
+	var bx, by int
+	cx, cy := (s.w-ww)/2, (s.h-wh)/2
+	for y := 0; y <= s.h-wh; y++ {
+		for x := 0; x <= s.w-ww; x++ {
+			e := sum(x, y, x+ww, y+wh)
+			if e > best || (e == best && abs(x-cx)+abs(y-cy) < abs(bx-cx)+abs(by-cy)) {
+				best, bx, by = e, x, y
+			}
+		}
+	}
+
+	// map back to source pixels, keeping within the region
+	x := min(s.r.Min.X+int(float64(bx)*s.cell), s.r.Max.X-w)
+	y := min(s.r.Min.Y+int(float64(by)*s.cell), s.r.Max.Y-h)
+	x = max(x, s.r.Min.X)
+	y = max(y, s.r.Min.Y)
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// abs returns the absolute value of n.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,357 @@
+package letterbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+)
+
+// WithTolerateCorrupt changes whether or not truncated and corrupt jpeg
+// sources are salvaged rather than failed, decoding as much as possible. The
+// missing region of baseline jpegs is filled with the background color, and
+// progressive jpegs are decoded from their complete scans. Salvaged results
+// are flagged as corrupt with a warning.
+func WithTolerateCorrupt(v bool) Option {
+	return func(p *Processor) error {
+		p.tolerate = v
+		return nil
+	}
+}
+
+// jpegFrame is the frame of a jpeg, parsed up to its first scan.
+type jpegFrame struct {
+	width       int
+	height      int
+	progressive bool
+
+	// mcu is the size of MCUs, and blocks the number of blocks per MCU.
+	mcu    image.Point
+	blocks int
+
+	// restart is the restart interval in MCUs, or 0 for none.
+	restart int
+
+	// scan is the offset of the entropy-coded data of the first scan.
+	scan int
+}
+
+// salvage decodes as much of the damaged jpeg source as possible, returning
+// the image and a description of what is missing.
+func (s *source) salvage(bg color.Color) (image.Image, string, error) {
+	data := s.data
+	if data == nil {
+		b, err := ioutil.ReadFile(s.path)
+		if err != nil {
+			return nil, "", fmt.Errorf("reading: %w", err)
+		}
+		data = b
+	}
+
+	f, err := parseFrame(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var img image.Image
+	var missing string
+	if f.progressive {
+		img, missing, err = salvageProgressive(data, f)
+	} else {
+		img, missing, err = salvageBaseline(data, f, bg)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.img = img
+	s.data = nil
+	s.corrupt = missing
+	return img, missing, nil
+}
+
+// salvageProgressive decodes the complete scans of the progressive jpeg,
+// dropping those from the first which fails.
+func salvageProgressive(data []byte, f jpegFrame) (image.Image, string, error) {
+	// scans, following their headers
+	var scans []int
+	for i := f.scan; i+1 < len(data); i++ {
+		if data[i] == 0xFF && data[i+1] == 0xDA {
+			scans = append(scans, i)
+		}
+	}
+
+	for n := len(scans); n > 0; n-- {
+		b := append(data[:scans[n-1]:scans[n-1]], 0xFF, 0xD9)
+		if img, err := jpeg.Decode(bytes.NewReader(b)); err == nil {
+			return img, fmt.Sprintf("missing %d of %d scans", len(scans)+1-n, len(scans)+1), nil
+		}
+	}
+
+	return nil, "", errors.New("no complete scans")
+}
+
+// salvageBaseline decodes the longest prefix of the entropy-coded data of the
+// baseline jpeg which decodes, padded with zero bits for the remaining MCUs,
+// and fills the MCUs decoded from padding with bg.
+func salvageBaseline(data []byte, f jpegFrame, bg color.Color) (image.Image, string, error) {
+	end := len(data)
+	if bytes.HasSuffix(data, []byte{0xFF, 0xD9}) {
+		end -= 2
+	}
+
+	// truncated, or corrupt at some point before the end
+	n := end
+	img, err := decodePadded(data, n, f)
+	if err != nil {
+		lo, hi := f.scan, end
+		for hi-lo > 1 {
+			mid := (lo + hi) / 2
+			if _, err := decodePadded(data, mid, f); err == nil {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+
+		n = lo
+		img, err = decodePadded(data, n, f)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// the padding begins where decoding a byte less differs
+	m := n - 1
+	for m > f.scan && data[m] == 0 {
+		m--
+	}
+
+	if m <= f.scan {
+		return nil, "", errors.New("no entropy-coded data")
+	}
+
+	prev, err := decodePadded(data, m, f)
+	if err != nil {
+		return img, "missing region unknown", nil
+	}
+
+	p, ok := firstDifference(img, prev)
+	if !ok {
+		return img, "missing region unknown", nil
+	}
+
+	// from the MCU of the first difference
+	start := image.Pt(p.X/f.mcu.X*f.mcu.X, p.Y/f.mcu.Y*f.mcu.Y)
+	fillMissing(img, start, f.mcu.Y, bg)
+
+	row := min(f.mcu.Y, f.height-start.Y)
+	missing := (f.width-start.X)*row + f.width*(f.height-start.Y-row)
+	return img, fmt.Sprintf("missing %.1f%% from row %d", 100*float64(missing)/float64(f.width*f.height), start.Y), nil
+}
+
+// decodePadded decodes the jpeg data with its entropy-coded data truncated at
+// n, padded with zero bits decoding to arbitrary MCUs, and with restart
+// markers when used. As the bits each MCU takes depends on the Huffman tables,
+// padding is retried with more bits per block.
+func decodePadded(data []byte, n int, f jpegFrame) (image.Image, error) {
+	mcus := ((f.width + f.mcu.X - 1) / f.mcu.X) * ((f.height + f.mcu.Y - 1) / f.mcu.Y)
+
+	// restarts seen and remaining
+	seen, intervals := 0, 1
+	if f.restart > 0 {
+		for i := f.scan; i+1 < n; i++ {
+			if data[i] == 0xFF && data[i+1] >= 0xD0 && data[i+1] <= 0xD7 {
+				seen++
+			}
+		}
+		intervals = (mcus+f.restart-1)/f.restart - seen
+	}
+
+	var err error
+	for _, perBlock := range []int{24, 96, 208} {
+		per := f.blocks * perBlock * mcus
+		if f.restart > 0 {
+			per = f.blocks * perBlock * f.restart
+		}
+
+		var b bytes.Buffer
+		b.Grow(n + intervals*(per+2) + 2)
+		b.Write(data[:n])
+		for i := 0; i < intervals; i++ {
+			b.Write(make([]byte, per))
+			if i < intervals-1 {
+				b.Write([]byte{0xFF, 0xD0 + byte((seen+i)%8)})
+			}
+		}
+		b.Write([]byte{0xFF, 0xD9})
+
+		var img image.Image
+		img, err = jpeg.Decode(&b)
+		if err == nil {
+			return img, nil
+		}
+	}
+
+	return nil, err
+}
+
+// parseFrame returns the frame of the jpeg data.
+func parseFrame(data []byte) (f jpegFrame, err error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return f, errors.New("not a jpeg")
+	}
+
+	var sampling []image.Point
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return f, errors.New("invalid segment")
+		}
+
+		marker := data[i+1]
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if i+2+n > len(data) {
+			return f, errors.New("truncated header")
+		}
+		seg := data[i+4 : i+2+n]
+
+		switch marker {
+		case 0xC0, 0xC1, 0xC2:
+			if len(seg) < 6 || len(seg) < 6+3*int(seg[5]) {
+				return f, errors.New("invalid frame")
+			}
+			f.progressive = marker == 0xC2
+			f.height = int(binary.BigEndian.Uint16(seg[1:]))
+			f.width = int(binary.BigEndian.Uint16(seg[3:]))
+			for c := 0; c < int(seg[5]); c++ {
+				hv := seg[6+3*c+1]
+				sampling = append(sampling, image.Pt(int(hv>>4), int(hv&0xF)))
+			}
+		case 0xDD:
+			if len(seg) >= 2 {
+				f.restart = int(binary.BigEndian.Uint16(seg))
+			}
+		case 0xDA:
+			if len(sampling) == 0 || f.width == 0 || f.height == 0 {
+				return f, errors.New("missing frame")
+			}
+
+			f.scan = i + 2 + n
+			f.mcu = image.Pt(8, 8)
+			if len(sampling) == 1 {
+				f.blocks = 1
+				return f, nil
+			}
+
+			for _, s := range sampling {
+				f.mcu.X = max(f.mcu.X, 8*s.X)
+				f.mcu.Y = max(f.mcu.Y, 8*s.Y)
+				f.blocks += s.X * s.Y
+			}
+			return f, nil
+		}
+
+		i += 2 + n
+	}
+
+	return f, errors.New("missing scan")
+}
+
+// firstDifference returns the first point in raster order where a and b
+// differ.
+func firstDifference(a, b image.Image) (image.Point, bool) {
+	r := a.Bounds()
+	if r != b.Bounds() {
+		return r.Min, true
+	}
+
+	if a, ok := a.(*image.YCbCr); ok {
+		if b, ok := b.(*image.YCbCr); ok {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					if a.Y[a.YOffset(x, y)] != b.Y[b.YOffset(x, y)] ||
+						a.Cb[a.COffset(x, y)] != b.Cb[b.COffset(x, y)] ||
+						a.Cr[a.COffset(x, y)] != b.Cr[b.COffset(x, y)] {
+						return image.Pt(x, y), true
+					}
+				}
+			}
+			return image.Point{}, false
+		}
+	}
+
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if a.At(x, y) != b.At(x, y) {
+				return image.Pt(x, y), true
+			}
+		}
+	}
+
+	return image.Point{}, false
+}
+
+// fillMissing fills img with c from start to the end of its MCU row of the
+// given height, and every row below.
+func fillMissing(img image.Image, start image.Point, height int, c color.Color) {
+	r := img.Bounds()
+	rects := []image.Rectangle{
+		image.Rect(start.X, start.Y, r.Max.X, start.Y+height).Intersect(r),
+		image.Rect(r.Min.X, start.Y+height, r.Max.X, r.Max.Y).Intersect(r),
+	}
+
+	switch m := img.(type) {
+	case *image.YCbCr:
+		v := color.YCbCrModel.Convert(c).(color.YCbCr)
+		for _, rect := range rects {
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					m.Y[m.YOffset(x, y)] = v.Y
+					m.Cb[m.COffset(x, y)] = v.Cb
+					m.Cr[m.COffset(x, y)] = v.Cr
+				}
+			}
+		}
+	case *image.Gray:
+		v := color.GrayModel.Convert(c).(color.Gray)
+		for _, rect := range rects {
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					m.SetGray(x, y, v)
+				}
+			}
+		}
+	case *image.CMYK:
+		v := color.CMYKModel.Convert(c).(color.CMYK)
+		for _, rect := range rects {
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					m.SetCMYK(x, y, v)
+				}
+			}
+		}
+	}
+}
+
+// salvage returns the image salvaged from the source which failed to decode
+// with err, or err when it is unsupported or can't be salvaged.
+func (p *Processor) salvage(src *source, err error) (image.Image, error) {
+	var u *UnsupportedError
+	if errors.As(err, &u) {
+		return nil, err
+	}
+
+	img, missing, serr := src.salvage(p.background)
+	if serr != nil {
+		p.log.Debug("Unable to salvage", "path", src.path, "error", serr)
+		return nil, err
+	}
+
+	p.log.Debug("Salvaged", "path", src.path, "error", err, "missing", missing)
+	return img, nil
+}
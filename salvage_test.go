@@ -0,0 +1,360 @@
+package letterbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// bitWriter writes the entropy-coded data of a jpeg scan.
+type bitWriter struct {
+	b    bytes.Buffer
+	bits uint32
+	n    uint
+}
+
+// write the low n bits of v.
+func (w *bitWriter) write(v uint32, n uint) {
+	w.bits = w.bits<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		c := byte(w.bits >> (w.n - 8))
+		w.b.WriteByte(c)
+		if c == 0xFF {
+			w.b.WriteByte(0)
+		}
+		w.n -= 8
+	}
+}
+
+// flush pads the last byte with one bits.
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.write(0xFF, 8-w.n)
+	}
+}
+
+// magnitude writes the category code and bits of the coefficient v with code.
+func (w *bitWriter) magnitude(code func(size int) uint32, v int) {
+	size := 0
+	for a := v; a != 0; a /= 2 {
+		size++
+	}
+
+	w.write(code(size), 8)
+	if v < 0 {
+		v += 1<<size - 1
+	}
+	w.write(uint32(v), uint(size))
+}
+
+// encodeProgressive returns the grayscale img as a progressive jpeg of a DC
+// scan and two AC scans, with restarts every restart blocks when positive.
+// Each Huffman table codes its symbols with 8 bits.
+func encodeProgressive(img *image.Gray, restart int) []byte {
+	cols, rows := img.Rect.Dx()/8, img.Rect.Dy()/8
+
+	// quantized coefficients in zig-zag order
+	blocks := make([][64]int, cols*rows)
+	for i := range blocks {
+		bx, by := i%cols*8, i/cols*8
+		for k := 0; k < 64; k++ {
+			u, v := unzig[k]%8, unzig[k]/8
+			var sum float64
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					f := float64(img.GrayAt(bx+x, by+y).Y) - 128
+					sum += f * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16) * math.Cos(float64(2*y+1)*float64(v)*math.Pi/16)
+				}
+			}
+			cu, cv := 1.0, 1.0
+			if u == 0 {
+				cu = math.Sqrt2 / 2
+			}
+			if v == 0 {
+				cv = math.Sqrt2 / 2
+			}
+			blocks[i][k] = int(math.Round(sum * cu * cv / 4 / 2))
+		}
+	}
+
+	// run and size symbols, preceded by EOB and ZRL
+	ac := []byte{0x00, 0xF0}
+	for r := 0; r < 16; r++ {
+		for s := 1; s <= 10; s++ {
+			ac = append(ac, byte(r<<4|s))
+		}
+	}
+
+	var b bytes.Buffer
+	segment := func(marker byte, data ...byte) {
+		b.Write([]byte{0xFF, marker})
+		binary.Write(&b, binary.BigEndian, uint16(len(data)+2))
+		b.Write(data)
+	}
+
+	b.Write([]byte{0xFF, 0xD8})
+	segment(0xDB, append([]byte{0}, bytes.Repeat([]byte{2}, 64)...)...)
+	segment(0xC2, 8, byte(img.Rect.Dy()>>8), byte(img.Rect.Dy()), byte(img.Rect.Dx()>>8), byte(img.Rect.Dx()), 1, 1, 0x11, 0)
+
+	dc := make([]byte, 17)
+	dc[8] = 12
+	for i := 0; i < 12; i++ {
+		dc = append(dc, byte(i))
+	}
+	table := make([]byte, 17)
+	table[0], table[8] = 0x10, byte(len(ac))
+	segment(0xC4, append(dc, append(table, ac...)...)...)
+
+	if restart > 0 {
+		segment(0xDD, byte(restart>>8), byte(restart))
+	}
+
+	for _, band := range [][2]int{{0, 0}, {1, 5}, {6, 63}} {
+		segment(0xDA, 1, 1, 0, byte(band[0]), byte(band[1]), 0)
+
+		var w bitWriter
+		pred := 0
+		for i, coef := range blocks {
+			if restart > 0 && i > 0 && i%restart == 0 {
+				w.flush()
+				w.b.Write([]byte{0xFF, 0xD0 + byte((i/restart-1)%8)})
+				pred = 0
+			}
+
+			if band[0] == 0 {
+				w.magnitude(func(size int) uint32 { return uint32(size) }, coef[0]-pred)
+				pred = coef[0]
+				continue
+			}
+
+			run := 0
+			for k := band[0]; k <= band[1]; k++ {
+				if coef[k] == 0 {
+					run++
+					continue
+				}
+
+				for ; run > 15; run -= 16 {
+					w.write(1, 8)
+				}
+				w.magnitude(func(size int) uint32 { return uint32(2 + run*10 + size - 1) }, coef[k])
+				run = 0
+			}
+			if run > 0 {
+				w.write(0, 8)
+			}
+		}
+		w.flush()
+		b.Write(w.b.Bytes())
+	}
+
+	b.Write([]byte{0xFF, 0xD9})
+	return b.Bytes()
+}
+
+// encodeBaseline returns img as a baseline jpeg, with restarts between bands
+// of rows MCU rows when positive.
+func encodeBaseline(t *testing.T, img image.Image, rows int) []byte {
+	t.Helper()
+
+	if rows > 0 {
+		prev := bandPixels
+		bandPixels = img.Bounds().Dx() * 16 * rows
+		defer func() { bandPixels = prev }()
+	}
+
+	var b bytes.Buffer
+	if err := encodeBands(&b, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// damage returns a copy of data truncated at n, or corrupted from n with an
+// invalid marker.
+func damage(data []byte, n int, corrupt bool) []byte {
+	if !corrupt {
+		return append([]byte(nil), data[:n]...)
+	}
+
+	b := append([]byte(nil), data...)
+	copy(b[n:], []byte{0xFF, 0xC8, 0xFF, 0xC8})
+	return b
+}
+
+// salvaged returns the result of processing the jpeg data with corrupt
+// sources tolerated.
+func salvaged(t *testing.T, data []byte) Result {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []Result
+	p, err := New(filepath.Join(dir, "processed"),
+		WithTolerateCorrupt(true),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		WithResultHandler(func(r Result) {
+			results = append(results, r)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Process(context.Background(), []string{path}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	return results[0]
+}
+
+func TestSalvageBaseline(t *testing.T) {
+	img := testPhoto(128, 96, false).(*image.RGBA)
+	bg := color.RGBA{0, 0, 0, 255}
+
+	for _, restart := range []int{0, 2} {
+		for _, corrupt := range []bool{false, true} {
+			t.Run(fmt.Sprintf("restart=%d corrupt=%v", restart, corrupt), func(t *testing.T) {
+				data := encodeBaseline(t, img, restart)
+				f, err := parseFrame(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if f.restart == 0 != (restart == 0) {
+					t.Fatalf("restart interval %d", f.restart)
+				}
+
+				// the entropy-coded data of the first rows is that of the
+				// image cropped to them, so damage is placed within row 3
+				size := func(rows int) int {
+					crop := encodeBaseline(t, img.SubImage(image.Rect(0, 0, 128, 16*rows)), restart)
+					_, data, err := splitScan(crop)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return len(data)
+				}
+
+				const row = 3
+				n := f.scan + (size(row)+size(row+1))/2
+				damaged := damage(data, n, corrupt)
+				if _, err := jpeg.Decode(bytes.NewReader(damaged)); err == nil {
+					t.Fatal("damaged jpeg decoded")
+				}
+
+				src := &source{data: damaged}
+				got, missing, err := src.salvage(bg)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if want := fmt.Sprintf("from row %d", 16*row); !strings.HasSuffix(missing, want) {
+					t.Fatalf("missing %q, want %q", missing, want)
+				}
+
+				// complete rows are intact, and those below the damaged row filled
+				want, err := jpeg.Decode(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if p, ok := mismatch(got.(*image.YCbCr).SubImage(image.Rect(0, 0, 128, 16*row)), want.(*image.YCbCr).SubImage(image.Rect(0, 0, 128, 16*row))); ok {
+					t.Fatalf("intact row mismatch at %v", p)
+				}
+
+				for y := 16 * (row + 1); y < 96; y++ {
+					for x := 0; x < 128; x++ {
+						if c := rgba(got.At(x, y)); !near(c, bg) {
+							t.Fatalf("pixel %d,%d is %v, want %v", x, y, c, bg)
+						}
+					}
+				}
+
+				res := salvaged(t, damaged)
+				if res.Error != "" || !res.Corrupt {
+					t.Fatalf("error %q corrupt %v, want a corrupt result", res.Error, res.Corrupt)
+				}
+			})
+		}
+	}
+}
+
+func TestSalvageProgressive(t *testing.T) {
+	img := testPhoto(64, 64, true).(*image.Gray)
+
+	for _, restart := range []int{0, 4} {
+		for _, corrupt := range []bool{false, true} {
+			t.Run(fmt.Sprintf("restart=%d corrupt=%v", restart, corrupt), func(t *testing.T) {
+				data := encodeProgressive(img, restart)
+				f, err := parseFrame(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !f.progressive || f.restart != restart {
+					t.Fatalf("progressive %v restart %d", f.progressive, f.restart)
+				}
+
+				full, err := jpeg.Decode(bytes.NewReader(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// within the error of quantizing by 2
+				for y := 0; y < 64; y++ {
+					for x := 0; x < 64; x++ {
+						a, b := full.(*image.Gray).GrayAt(x, y).Y, img.GrayAt(x, y).Y
+						if d := int(a) - int(b); d < -3 || d > 3 {
+							t.Fatalf("decoded pixel %d,%d is %d, want %d", x, y, a, b)
+						}
+					}
+				}
+
+				// damaged within the last scan
+				last := bytes.LastIndex(data, []byte{0xFF, 0xDA})
+				damaged := damage(data, (last+len(data))/2, corrupt)
+				if _, err := jpeg.Decode(bytes.NewReader(damaged)); err == nil {
+					t.Fatal("damaged jpeg decoded")
+				}
+
+				src := &source{data: damaged}
+				got, missing, err := src.salvage(color.Black)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if missing != "missing 1 of 3 scans" {
+					t.Fatalf("missing %q", missing)
+				}
+
+				if got.Bounds() != img.Rect {
+					t.Fatalf("bounds %v, want %v", got.Bounds(), img.Rect)
+				}
+
+				res := salvaged(t, damaged)
+				if res.Error != "" || !res.Corrupt {
+					t.Fatalf("error %q corrupt %v, want a corrupt result", res.Error, res.Corrupt)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package letterbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// WithScaledDecode changes whether or not baseline JPEG sources are decoded at
+// 1/2, 1/4 or 1/8 scale when every output is drawn at least that much smaller,
+// computing only the low frequencies of each block rather than decoding every
+// pixel and resampling, for faster decoding and lower memory usage of large
+// sources. Progressive and other JPEGs are decoded at full size.
+func WithScaledDecode(v bool) Option {
+	return func(p *Processor) error {
+		p.scaledDecode = v
+		return nil
+	}
+}
+
+// reduced is a source decoded at 1/scale of its size, with the bounds of the
+// source so that regions and layout remain in source pixels.
+type reduced struct {
+	image.Image
+	scale  int
+	bounds image.Rectangle
+}
+
+// Bounds implementation.
+func (r *reduced) Bounds() image.Rectangle {
+	return r.bounds
+}
+
+// At implementation.
+func (r *reduced) At(x, y int) color.Color {
+	return r.Image.At(x/r.scale, y/r.scale)
+}
+
+// Opaque implementation.
+func (r *reduced) Opaque() bool {
+	return opaque(r.Image)
+}
+
+// rect returns the rect of the reduced pixels covering the source rect sr.
+func (r *reduced) rect(sr image.Rectangle) image.Rectangle {
+	s := r.scale
+	return image.Rect(sr.Min.X/s, sr.Min.Y/s, (sr.Max.X+s-1)/s, (sr.Max.Y+s-1)/s).Intersect(r.Image.Bounds())
+}
+
+// decodeScale returns the scale denominator the source is decoded at for all
+// of its targets, the largest of 2, 4 or 8 at which the source rect of every
+// target is still no smaller than it is drawn, or 1.
+func (p *Processor) decodeScale(src *source, targets []target) int {
+	if !p.scaledDecode || p.loader != nil || p.dryRun {
+		return 1
+	}
+
+	size, ok := baselineSize(src.path)
+	if !ok {
+		return 1
+	}
+
+	n := 8
+	for _, t := range targets {
+		sr := p.region(nil, image.Rect(0, 0, size.X, size.Y), src.sidecar, t)
+		_, dr := p.layout(sr, t, nil)
+		for n > 1 && (sr.Dx()/n < dr.Dx() || sr.Dy()/n < dr.Dy()) {
+			n /= 2
+		}
+	}
+
+	return n
+}
+
+// baselineSize returns the size of the baseline jpeg at path from its header,
+// or false when it isn't one.
+func baselineSize(path string) (image.Point, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return image.Point{}, false
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var b [9]byte
+	if _, err := io.ReadFull(r, b[:2]); err != nil || b[0] != 0xFF || b[1] != 0xD8 {
+		return image.Point{}, false
+	}
+
+	for {
+		if _, err := io.ReadFull(r, b[:4]); err != nil || b[0] != 0xFF {
+			return image.Point{}, false
+		}
+
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		switch m := b[1]; {
+		case m == 0xC0 || m == 0xC1:
+			if _, err := io.ReadFull(r, b[:5]); err != nil {
+				return image.Point{}, false
+			}
+			return image.Pt(int(binary.BigEndian.Uint16(b[3:])), int(binary.BigEndian.Uint16(b[1:]))), true
+		case m >= 0xC2 && m <= 0xCF && m != 0xC4 && m != 0xC8 && m != 0xCC:
+			return image.Point{}, false
+		}
+
+		if n < 2 {
+			return image.Point{}, false
+		}
+
+		if _, err := r.Discard(n - 2); err != nil {
+			return image.Point{}, false
+		}
+	}
+}
+
+// decodeReduced returns the source decoded at 1/scale, or an error when it
+// can't be, leaving it read into memory to be decoded at full size.
+func (s *source) decodeReduced(ctx context.Context) (image.Image, error) {
+	if s.data == nil {
+		b, err := ioutil.ReadFile(s.path)
+		if err != nil {
+			return nil, err
+		}
+		s.data = b
+	}
+
+	return decodeScaled(ctx, s.data, s.scale)
+}
@@ -0,0 +1,28 @@
+package letterbox
+
+import (
+	"os"
+	"sort"
+)
+
+// largestFirst returns the images ordered by descending file size, so that
+// giant images start early rather than serializing the tail of the batch.
+// Images which cannot be stat'd keep their relative order at the end.
+func largestFirst(images []string) []string {
+	sizes := make(map[string]int64, len(images))
+	for _, path := range images {
+		if info, err := os.Stat(path); err == nil {
+			sizes[path] = info.Size()
+		} else {
+			sizes[path] = -1
+		}
+	}
+
+	sorted := make([]string, len(images))
+	copy(sorted, images)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sizes[sorted[i]] > sizes[sorted[j]]
+	})
+
+	return sorted
+}
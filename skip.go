@@ -0,0 +1,261 @@
+package letterbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// SkipPolicy decides whether or not an image should be skipped.
+type SkipPolicy interface {
+	Skip(src, dst string) (bool, error)
+}
+
+// SkipRecorder is implemented by skip policies which record
+// images after they are successfully processed.
+type SkipRecorder interface {
+	Record(src, dst string) error
+}
+
+// SkipFunc adapts a function to a SkipPolicy.
+type SkipFunc func(src, dst string) (bool, error)
+
+// Skip implementation.
+func (f SkipFunc) Skip(src, dst string) (bool, error) {
+	return f(src, dst)
+}
+
+// SkipUnmodified skips images when the output exists and is newer than the source.
+var SkipUnmodified SkipPolicy = SkipFunc(func(src, dst string) (bool, error) {
+	return unmodified(src, dst), nil
+})
+
+// SkipAlways skips every image.
+var SkipAlways SkipPolicy = SkipFunc(func(src, dst string) (bool, error) {
+	return true, nil
+})
+
+// SkipNever skips no images.
+var SkipNever SkipPolicy = SkipFunc(func(src, dst string) (bool, error) {
+	return false, nil
+})
+
+// HashSkip skips images when the output exists and both the source content
+// hash and the processing options match those recorded when it was last
+// processed, so touched sources are skipped and changed options are not.
+type HashSkip struct {
+	path    string
+	options string
+	mu      sync.Mutex
+	entries map[string]hashEntry
+}
+
+// hashEntry is the state recorded for an output.
+type hashEntry struct {
+	Source  string `json:"source"`
+	Hash    string `json:"hash"`
+	Options string `json:"options"`
+}
+
+// optionsSetter is implemented by skip policies which take
+// the processing options into account.
+type optionsSetter interface {
+	setOptions(key string)
+}
+
+// NewHashSkip returns a hash skip policy persisted to the JSON file at path.
+// State files written by previous versions are discarded.
+func NewHashSkip(path string) (*HashSkip, error) {
+	s := &HashSkip{
+		path:    path,
+		entries: make(map[string]hashEntry),
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &s.entries)
+	if err == nil {
+		return s, nil
+	}
+
+	// previous versions stored source hashes only
+	var legacy map[string]string
+	if json.Unmarshal(b, &legacy) == nil {
+		s.entries = make(map[string]hashEntry)
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("parsing %s: %w", path, err)
+}
+
+// setOptions implementation.
+func (s *HashSkip) setOptions(key string) {
+	s.mu.Lock()
+	s.options = key
+	s.mu.Unlock()
+}
+
+// Skip implementation.
+func (s *HashSkip) Skip(src, dst string) (bool, error) {
+	if !exists(dst) {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	prev, ok := s.entries[dst]
+	options := s.options
+	s.mu.Unlock()
+
+	if !ok || prev.Source != src || prev.Options != options {
+		return false, nil
+	}
+
+	hash, err := hashFile(src)
+	if err != nil {
+		return false, err
+	}
+
+	return hash == prev.Hash, nil
+}
+
+// Record implementation.
+func (s *HashSkip) Record(src, dst string) error {
+	hash, err := hashFile(src)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.entries[dst] = hashEntry{
+		Source:  src,
+		Hash:    hash,
+		Options: s.options,
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Save the recorded state.
+func (s *HashSkip) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := json.MarshalIndent(s.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, b)
+}
+
+// optionsKey returns the hex sha256 of the options affecting output
+// pixels, so that changing them invalidates previously recorded outputs.
+func (p *Processor) optionsKey() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "aspects=%v\n", p.aspects)
+	fmt.Fprintf(h, "quality=%d compression=%d format=%s\n", p.quality, p.compression, p.format)
+	fmt.Fprintf(h, "size=%v upscale=%v max=%v\n", p.size, p.upscale, p.maxSize)
+	fmt.Fprintf(h, "fit=%s gravity=%s round=%s even=%v\n", p.fit, p.gravity, p.round, p.forceEven)
+	fmt.Fprintf(h, "offset=%v pad-to=%v margin=%v padding=%v\n", p.offset, p.padTo, p.margin, p.padding)
+	fmt.Fprintf(h, "split=%v overlap=%v\n", p.split, p.overlap)
+	fmt.Fprintf(h, "background=%v radius=%d thumbnail=%d\n", rgba(p.background), p.radius, p.thumbnail)
+	fmt.Fprintf(h, "resampler=%s scaled-decode=%v loader=%T sidecars=%v\n", resamplerName(p.resampler), p.scaledDecode, p.loader, p.sidecars)
+
+	if b := p.border; b != nil {
+		fmt.Fprintf(h, "border=%d %v\n", b.width, rgba(b.color))
+	}
+
+	if s := p.shadow; s != nil {
+		fmt.Fprintf(h, "shadow=%d %v %v\n", s.blur, s.offset, rgba(s.color))
+	}
+
+	if w := p.watermark; w != nil {
+		fmt.Fprintf(h, "watermark=%v %s %v\n", w.img.Bounds(), w.position, w.opacity)
+	}
+
+	if a := p.portrait; a != nil {
+		fmt.Fprintf(h, "portrait=%v\n", *a)
+	}
+
+	if a := p.landscape; a != nil {
+		fmt.Fprintf(h, "landscape=%v\n", *a)
+	}
+
+	if p.tolerance > 0 {
+		fmt.Fprintf(h, "tolerance=%v links=%v\n", p.tolerance, p.links)
+	}
+
+	if p.depth != 8 {
+		fmt.Fprintf(h, "depth=%d\n", p.depth)
+	}
+
+	if p.parallelEncode > 0 {
+		fmt.Fprintf(h, "parallel-encode=%d\n", p.parallelEncode)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// rgba returns c in the RGBA model, for comparison.
+func rgba(c color.Color) color.RGBA {
+	return color.RGBAModel.Convert(c).(color.RGBA)
+}
+
+// NewManifestSkip returns a policy which skips the images successfully
+// processed in a previous run, using the JSON report at path.
+func NewManifestSkip(path string) (SkipPolicy, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest struct {
+		Images []Result `json:"images"`
+	}
+
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	done := make(map[string]bool)
+	for _, r := range manifest.Images {
+		if r.Error == "" {
+			done[r.Source] = true
+		}
+	}
+
+	return SkipFunc(func(src, dst string) (bool, error) {
+		return done[src], nil
+	}), nil
+}
+
+// hashFile returns the hex sha256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,127 @@
+package letterbox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// source is an image which is decoded at most once for all targets.
+type source struct {
+	path    string
+	sidecar *sidecar
+	img     image.Image
+
+	// data is the source read into memory ahead of decoding.
+	data []byte
+
+	// reserved is the memory reserved for the decoded image.
+	reserved int64
+
+	// corrupt describes what is missing from a salvaged image.
+	corrupt string
+
+	// scale is the denominator of the scale the source is decoded at.
+	scale int
+}
+
+// decode returns the decoded image, failing once ctx is done.
+func (s *source) decode(ctx context.Context) (image.Image, error) {
+	if s.img != nil {
+		return s.img, nil
+	}
+
+	// reduced, or at full size when unsupported
+	if s.scale > 1 {
+		if img, err := s.decodeReduced(ctx); err == nil {
+			s.img = img
+			s.data = nil
+			return img, nil
+		}
+	}
+
+	var r io.Reader = bytes.NewReader(s.data)
+	if s.data == nil {
+		f, err := os.Open(s.path)
+		if err != nil {
+			return nil, fmt.Errorf("opening: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	img, _, err := image.Decode(contextReader{ctx, r})
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", unsupported(s.path, err))
+	}
+
+	s.img = img
+	s.data = nil
+	return img, nil
+}
+
+// contextReader is a reader which fails once its context is done, so that
+// decoding stops at the deadline.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+// Read implementation.
+func (r contextReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(b)
+}
+
+// contextWriter is a writer which fails once its context is done, so that
+// encoding stops at the deadline.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+// Write implementation.
+func (w contextWriter) Write(b []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(b)
+}
+
+// read reads the source into memory so that it may be decoded without
+// blocking on disk, unless already decoded.
+func (s *source) read() error {
+	if s.img != nil || s.data != nil {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("reading: %w", err)
+	}
+
+	s.data = b
+	return nil
+}
+
+// decodeConfig returns the image config from its header.
+func (s *source) decodeConfig() (image.Config, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return image.Config{}, fmt.Errorf("opening: %w", err)
+	}
+	defer f.Close()
+
+	c, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return image.Config{}, fmt.Errorf("decoding config: %w", unsupported(s.path, err))
+	}
+
+	return c, nil
+}
@@ -0,0 +1,94 @@
+package letterbox
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// WithSplit changes whether sources taller than the target aspect ratio, such
+// as scrolling screenshots, are sliced into sequential pages at the aspect
+// ratio instead of being letterboxed whole. Pages are written with a "_1",
+// "_2" suffix and the last partial page is letterboxed.
+func WithSplit(v bool) Option {
+	return func(p *Processor) error {
+		p.split = v
+		return nil
+	}
+}
+
+// WithSplitOverlap changes the percentage of each page's height which is
+// repeated at the top of the next page when splitting, for continuity.
+func WithSplitOverlap(percent float64) Option {
+	return func(p *Processor) error {
+		if percent < 0 || percent >= 100 {
+			return fmt.Errorf("invalid split overlap percentage %v", percent)
+		}
+		p.overlap = percent / 100
+		return nil
+	}
+}
+
+// pages returns the target for each page of the source when splitting, or
+// the target itself when the source fits on a single page.
+func (p *Processor) pages(src *source, t target) ([]target, error) {
+	if !p.split {
+		return []target{t}, nil
+	}
+
+	c, err := src.decodeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	r := image.Rect(0, 0, c.Width, c.Height)
+	if src.sidecar != nil {
+		r = src.sidecar.Crop(r)
+	}
+
+	ratio := t.aspect
+	if p.size.Width > 0 && p.size.Height > 0 {
+		ratio = float64(p.size.Width) / float64(p.size.Height)
+	}
+
+	// page height at the aspect ratio, rounded down so that full pages are
+	// never padded
+	h := max(1, int(float64(r.Dx())/ratio))
+	if r.Dy() <= h {
+		return []target{t}, nil
+	}
+
+	step := max(1, int(math.Round(float64(h)*(1-p.overlap))))
+
+	var rects []image.Rectangle
+	for y := r.Min.Y; ; y += step {
+		rects = append(rects, image.Rect(r.Min.X, y, r.Max.X, min(y+h, r.Max.Y)))
+		if y+h >= r.Max.Y {
+			break
+		}
+	}
+
+	targets := make([]target, len(rects))
+	for i, rect := range rects {
+		targets[i] = t
+		targets[i].page = rect
+		targets[i].index = i + 1
+		targets[i].count = len(rects)
+	}
+
+	return targets, nil
+}
+
+// paged returns path with the page number of the target appended to its
+// name, zero-padded so that pages sort in order.
+func paged(path string, t target) string {
+	if t.index == 0 {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	digits := len(fmt.Sprint(t.count))
+	return fmt.Sprintf("%s_%0*d%s", strings.TrimSuffix(path, ext), digits, t.index, ext)
+}
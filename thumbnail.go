@@ -0,0 +1,212 @@
+package letterbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// exifHeader is the identifier of EXIF APP1 segments.
+var exifHeader = []byte("Exif\x00\x00")
+
+// WithThumbnail changes the maximum width and height in pixels of the EXIF
+// thumbnail embedded in jpeg outputs, generated from the output rather than
+// the stale thumbnail of the source, which defaults to 0 for none.
+func WithThumbnail(size int) Option {
+	return func(p *Processor) error {
+		p.thumbnail = size
+		return nil
+	}
+}
+
+// writeThumbnail embeds a thumbnail of img no larger than size into the EXIF
+// segment of the jpeg at path, adding the segment when there is none.
+func writeThumbnail(path string, img image.Image, size int) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if !isJPEG(b) {
+		return errors.New("output format does not support thumbnails")
+	}
+
+	thumb, err := thumbnail(img, size)
+	if err != nil {
+		return err
+	}
+
+	// existing segment
+	start, end, err := exifSegment(b)
+	if err != nil {
+		return err
+	}
+
+	var tiff []byte
+	if start > 0 {
+		tiff = b[start+4+len(exifHeader) : end]
+	} else {
+		start, end = 2, 2
+	}
+
+	app1, err := exifThumbnail(tiff, thumb)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(b[:start])
+	buf.Write(app1)
+	buf.Write(b[end:])
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// thumbnail returns img scaled to fit within size as a jpeg.
+func thumbnail(img image.Image, size int) ([]byte, error) {
+	b := img.Bounds()
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = max(1, b.Dy()*size/b.Dx())
+	} else {
+		w = max(1, b.Dx()*size/b.Dy())
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, xdraw.Src, nil)
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 75})
+	if err != nil {
+		return nil, fmt.Errorf("encoding thumbnail: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// exifSegment returns the offsets of the EXIF APP1 segment of the jpeg b
+// including its marker, or zeros when there is none.
+func exifSegment(b []byte) (start, end int, err error) {
+	i := 2
+	for i+4 <= len(b) {
+		if b[i] != 0xFF {
+			return 0, 0, errors.New("invalid jpeg marker")
+		}
+
+		marker := b[i+1]
+
+		// padding
+		if marker == 0xFF {
+			i++
+			continue
+		}
+
+		// start of scan, no more metadata
+		if marker == 0xDA {
+			break
+		}
+
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		end := i + 2 + n
+		if n < 2 || end > len(b) {
+			return 0, 0, errors.New("invalid jpeg segment length")
+		}
+
+		if marker == 0xE1 && bytes.HasPrefix(b[i+4:end], exifHeader) {
+			return i, end, nil
+		}
+
+		i = end
+	}
+
+	return 0, 0, nil
+}
+
+// exifThumbnail returns an EXIF APP1 segment of the TIFF data tiff with its
+// IFD1 replaced by one pointing to the jpeg thumbnail. The previous IFD1 is
+// left unreferenced, as other data may follow it. When tiff is nil a minimal
+// little-endian TIFF is created.
+func exifThumbnail(tiff, thumb []byte) ([]byte, error) {
+	if tiff == nil {
+		tiff = []byte{
+			'I', 'I', 42, 0, 8, 0, 0, 0,
+			// IFD0 of a single orientation entry
+			1, 0,
+			0x12, 0x01, 3, 0, 1, 0, 0, 0, 1, 0, 0, 0,
+			0, 0, 0, 0,
+		}
+	}
+
+	if len(tiff) < 8 {
+		return nil, errors.New("invalid exif data")
+	}
+
+	var order interface {
+		binary.ByteOrder
+		binary.AppendByteOrder
+	}
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("invalid exif byte order")
+	}
+
+	// IFD0 next offset
+	ifd0 := int(order.Uint32(tiff[4:]))
+	if ifd0+2 > len(tiff) {
+		return nil, errors.New("invalid exif IFD0 offset")
+	}
+
+	next := ifd0 + 2 + 12*int(order.Uint16(tiff[ifd0:]))
+	if next+4 > len(tiff) {
+		return nil, errors.New("invalid exif IFD0")
+	}
+
+	// IFD1 appended at a word boundary
+	t := append([]byte{}, tiff...)
+	if len(t)%2 == 1 {
+		t = append(t, 0)
+	}
+
+	ifd1 := len(t)
+	order.PutUint32(t[next:], uint32(ifd1))
+
+	entry := func(tag, kind uint16, v uint32) {
+		e := make([]byte, 12)
+		order.PutUint16(e, tag)
+		order.PutUint16(e[2:], kind)
+		order.PutUint32(e[4:], 1)
+		if kind == 3 {
+			order.PutUint16(e[8:], uint16(v))
+		} else {
+			order.PutUint32(e[8:], v)
+		}
+		t = append(t, e...)
+	}
+
+	t = order.AppendUint16(t, 3)
+	entry(0x0103, 3, 6)                     // compression, jpeg
+	entry(0x0201, 4, uint32(ifd1+2+3*12+4)) // thumbnail offset
+	entry(0x0202, 4, uint32(len(thumb)))    // thumbnail length
+	t = order.AppendUint32(t, 0)
+	t = append(t, thumb...)
+
+	// segment
+	n := 2 + len(exifHeader) + len(t)
+	if n > 0xFFFF {
+		return nil, fmt.Errorf("exif segment of %d bytes exceeds the jpeg limit", n)
+	}
+
+	s := []byte{0xFF, 0xE1, byte(n >> 8), byte(n)}
+	s = append(s, exifHeader...)
+	return append(s, t...), nil
+}
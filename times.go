@@ -0,0 +1,38 @@
+package letterbox
+
+import (
+	"fmt"
+	"os"
+)
+
+// WithPreserveTimes changes whether or not the access and modification times
+// and mode bits of sources are copied onto their outputs, so that sync tools
+// and galleries order outputs by capture. As outputs are then no older than
+// their sources, use a HashSkip policy rather than SkipUnmodified.
+func WithPreserveTimes(v bool) Option {
+	return func(p *Processor) error {
+		p.preserveTimes = v
+		return nil
+	}
+}
+
+// preserveTimes copies the access and modification times and mode bits of
+// the file at src onto dst.
+func preserveTimes(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+
+	err = os.Chmod(dst, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("setting mode: %w", err)
+	}
+
+	err = os.Chtimes(dst, atime(info), info.ModTime())
+	if err != nil {
+		return fmt.Errorf("setting times: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package letterbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+
+	"golang.org/x/image/tiff"
+)
+
+// UnsupportedError is the error of sources recognized as images, but in a
+// format or variant which can't be decoded, such as 12-bit jpeg or JPEG 2000,
+// with a converter to run on them first.
+type UnsupportedError struct {
+	Format    string `json:"format"`
+	Variant   string `json:"variant,omitempty"`
+	Converter string `json:"converter"`
+}
+
+// Error implementation.
+func (e *UnsupportedError) Error() string {
+	if e.Variant == "" {
+		return fmt.Sprintf("unsupported format %s, convert with %s first", e.Format, e.Converter)
+	}
+	return fmt.Sprintf("unsupported %s variant %s, convert with %s first", e.Format, e.Variant, e.Converter)
+}
+
+// unsupported returns an UnsupportedError when err of decoding the source at
+// path is due to a recognized but unsupported format or variant, otherwise err.
+func unsupported(path string, err error) error {
+	var (
+		jpegErr jpeg.UnsupportedError
+		pngErr  png.UnsupportedError
+		tiffErr tiff.UnsupportedError
+	)
+
+	switch {
+	case errors.As(err, &jpegErr):
+		if u := jpegVariant(path); u != nil {
+			return u
+		}
+		return &UnsupportedError{Format: "jpeg", Variant: string(jpegErr), Converter: "magick"}
+	case errors.As(err, &pngErr):
+		return &UnsupportedError{Format: "png", Variant: string(pngErr), Converter: "magick"}
+	case errors.As(err, &tiffErr):
+		return &UnsupportedError{Format: "tiff", Variant: string(tiffErr), Converter: "magick"}
+	case errors.Is(err, image.ErrFormat):
+		if u := sniffFormat(path); u != nil {
+			return u
+		}
+	}
+
+	return err
+}
+
+// sniffFormat returns an UnsupportedError when the signature of the file at
+// path is that of an image format without a decoder, otherwise nil.
+func sniffFormat(path string) *UnsupportedError {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	b := make([]byte, 64)
+	n, _ := io.ReadFull(f, b)
+	b = b[:n]
+
+	switch {
+	case bytes.HasPrefix(b, []byte("\x00\x00\x00\x0cjP  \r\n\x87\n")), bytes.HasPrefix(b, []byte("\xff\x4f\xff\x51")):
+		return &UnsupportedError{Format: "JPEG 2000", Converter: "opj_decompress"}
+	case bytes.HasPrefix(b, []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")), bytes.HasPrefix(b, []byte("\xff\x0a")):
+		return &UnsupportedError{Format: "JPEG XL", Converter: "djxl"}
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		return &UnsupportedError{Format: "WebP", Converter: "dwebp"}
+	case len(b) >= 12 && string(b[4:8]) == "ftyp":
+		// major brand and compatible brands
+		size := min(int(binary.BigEndian.Uint32(b)), len(b))
+		var heif bool
+		for i := 8; i+4 <= size; i += 4 {
+			switch string(b[i : i+4]) {
+			case "avif", "avis":
+				return &UnsupportedError{Format: "AVIF", Converter: "avifdec"}
+			case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+				heif = true
+			}
+		}
+		if heif {
+			return &UnsupportedError{Format: "HEIF", Converter: "heif-convert"}
+		}
+	}
+
+	return nil
+}
+
+// jpegVariant returns an UnsupportedError describing the frame of the jpeg at
+// path when its coding or precision is unsupported, otherwise nil.
+func jpegVariant(path string) *UnsupportedError {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil
+	}
+
+	// segments up to the start of frame
+	for {
+		var m [4]byte
+		if _, err := io.ReadFull(r, m[:]); err != nil || m[0] != 0xFF {
+			return nil
+		}
+
+		marker := m[1]
+		n := int(binary.BigEndian.Uint16(m[2:])) - 2
+
+		switch {
+		case marker == 0xC0 || marker == 0xC1 || marker == 0xC2:
+			precision, err := r.ReadByte()
+			if err != nil || precision == 8 {
+				return nil
+			}
+			return &UnsupportedError{Format: "jpeg", Variant: fmt.Sprintf("%d-bit precision", precision), Converter: "magick -depth 8"}
+		case marker == 0xC3:
+			return &UnsupportedError{Format: "jpeg", Variant: "lossless", Converter: "magick"}
+		case marker >= 0xC5 && marker <= 0xC7:
+			return &UnsupportedError{Format: "jpeg", Variant: "hierarchical", Converter: "magick"}
+		case marker >= 0xC9 && marker <= 0xCF && marker != 0xCC:
+			return &UnsupportedError{Format: "jpeg", Variant: "arithmetic coding", Converter: "jpegtran"}
+		case marker == 0xDA || n < 0:
+			return nil
+		}
+
+		if _, err := r.Discard(n); err != nil {
+			return nil
+		}
+	}
+}
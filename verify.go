@@ -0,0 +1,44 @@
+package letterbox
+
+import (
+	"fmt"
+	"image"
+	"os"
+)
+
+// WithVerifyOutput changes whether outputs are decoded again once written,
+// failing the image when the output is undecodable or its format or
+// dimensions differ from those expected, catching encoder and disk issues
+// at write time.
+func WithVerifyOutput(v bool) Option {
+	return func(p *Processor) error {
+		p.verify = v
+		return nil
+	}
+}
+
+// verifyOutput decodes every pixel of the output at path, checking its format
+// and that its dimensions, and thereby aspect ratio, are those expected.
+func (p *Processor) verifyOutput(path string, want Size) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	img, format, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("decoding: %w", err)
+	}
+
+	if format != p.format {
+		return fmt.Errorf("format is %s, expected %s", format, p.format)
+	}
+
+	b := img.Bounds()
+	if b.Dx() != want.Width || b.Dy() != want.Height {
+		return fmt.Errorf("dimensions are %dx%d, expected %dx%d", b.Dx(), b.Dy(), want.Width, want.Height)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package letterbox
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Loader decodes the rect sr of the source image at path scaled to size,
+// for backends which shrink while decoding rather than decoding every pixel.
+type Loader interface {
+	Load(path string, sr image.Rectangle, size image.Point) (image.Image, error)
+}
+
+// Vips is a loader which delegates decoding and resizing to the vips command
+// of libvips, which shrinks JPEGs while decoding and streams other formats,
+// for faster processing and lower memory usage of large sources.
+type Vips struct {
+	// Path to the vips binary.
+	Path string
+}
+
+// NewVips returns a vips loader, or an error if it is not installed.
+func NewVips() (*Vips, error) {
+	path, err := exec.LookPath("vips")
+	if err != nil {
+		return nil, fmt.Errorf("vips is required: %w", err)
+	}
+	return &Vips{Path: path}, nil
+}
+
+// Load implementation.
+func (v *Vips) Load(path string, sr image.Rectangle, size image.Point) (image.Image, error) {
+	c, err := (&source{path: path}).decodeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", "letterbox-vips")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// crop
+	in := path
+	if sr != image.Rect(0, 0, c.Width, c.Height) {
+		in = filepath.Join(dir, "crop.v")
+		err = v.run("crop", path, in, strconv.Itoa(sr.Min.X), strconv.Itoa(sr.Min.Y), strconv.Itoa(sr.Dx()), strconv.Itoa(sr.Dy()))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// resize
+	out := filepath.Join(dir, "out.png")
+	err = v.run("thumbnail", in, out+"[compression=0]", strconv.Itoa(size.X),
+		"--height", strconv.Itoa(size.Y),
+		"--size", "force",
+		"--no-rotate")
+	if err != nil {
+		return nil, err
+	}
+
+	// decode
+	f, err := os.Open(out)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	return img, nil
+}
+
+// run the vips operation with args.
+func (v *Vips) run(args ...string) error {
+	out, err := exec.Command(v.Path, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("vips %s: %s: %w", args[0], strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package letterbox
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// watermark is an image composited over each output.
+type watermark struct {
+	img      image.Image
+	position string
+	opacity  float64
+}
+
+// WithWatermark changes the watermark composited over each output after
+// letterboxing, at the position such as "bottom-right" or "center", with an
+// opacity from 0 to 1. Watermarks larger than the canvas are scaled down.
+// Watermarks with straight alpha, such as decoded pngs, are premultiplied
+// once up front.
+func WithWatermark(img image.Image, position string, opacity float64) Option {
+	return func(p *Processor) error {
+		if _, ok := anchors[position]; !ok && position != "center" {
+			return fmt.Errorf("unsupported watermark position %q", position)
+		}
+
+		if opacity < 0 || opacity > 1 {
+			return fmt.Errorf("invalid watermark opacity %v, must be 0 to 1", opacity)
+		}
+
+		p.watermark = &watermark{img: premultiplied(img), position: position, opacity: opacity}
+		return nil
+	}
+}
+
+// draw composites the watermark over dst, inset from its edges by 3% of
+// the smallest dimension.
+func (w *watermark) draw(dst draw.Image, scaler xdraw.Scaler) {
+	db := dst.Bounds()
+	m := int(math.Round(float64(min(db.Dx(), db.Dy())) * 0.03))
+	area := db.Inset(m)
+
+	// scale down to fit
+	wr := w.img.Bounds()
+	wb := fit(image.Rect(0, 0, wr.Dx(), wr.Dy()), float64(area.Dx()), float64(area.Dy()), false)
+
+	r := centered(wb, area, "floor")
+	if a, ok := anchors[w.position]; ok {
+		r = anchored(wb, area, a)
+	}
+
+	mask := &image.Uniform{color.Alpha{uint8(math.Round(w.opacity * 255))}}
+
+	if wb.Size() == wr.Size() {
+		draw.DrawMask(dst, r, w.img, wr.Min, mask, image.ZP, draw.Over)
+		return
+	}
+
+	// scaled at the depth of the canvas
+	var scaled draw.Image = image.NewRGBA(wb)
+	if _, ok := dst.(*image.RGBA); !ok {
+		scaled = image.NewRGBA64(wb)
+	}
+
+	scaler.Scale(scaled, wb, w.img, wr, draw.Src, nil)
+	draw.DrawMask(dst, r, scaled, image.ZP, mask, image.ZP, draw.Over)
+}
+
+// premultiplied returns img with premultiplied alpha, as an RGBA or RGBA64
+// image for 16-bit images, or img itself when already premultiplied or opaque.
+func premultiplied(img image.Image) image.Image {
+	switch img.(type) {
+	case *image.RGBA, *image.RGBA64:
+		return img
+	}
+
+	if opaque(img) {
+		return img
+	}
+
+	b := img.Bounds()
+	var dst draw.Image = image.NewRGBA(b)
+	switch img.ColorModel() {
+	case color.NRGBA64Model, color.RGBA64Model:
+		dst = image.NewRGBA64(b)
+	}
+
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	return dst
+}
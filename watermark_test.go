@@ -0,0 +1,167 @@
+package letterbox
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// alphas are the watermark alpha values tested, including the extremes.
+var alphas = []uint8{0, 1, 32, 64, 128, 192, 254, 255}
+
+// testCanvas returns an opaque canvas of the given size filled with c.
+func testCanvas(w, h int, c color.Color) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{c}, image.ZP, draw.Src)
+	return dst
+}
+
+// mismatch returns the first point where the images differ by more than one
+// per channel.
+func mismatch(got, want image.Image) (image.Point, bool) {
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.RGBAModel.Convert(got.At(x, y)).(color.RGBA)
+			w := color.RGBAModel.Convert(want.At(x, y)).(color.RGBA)
+			if !near(g, w) {
+				return image.Pt(x, y), true
+			}
+		}
+	}
+	return image.Point{}, false
+}
+
+// compare fails when the images differ by more than one per channel.
+func compare(t *testing.T, got, want image.Image) {
+	t.Helper()
+
+	if p, ok := mismatch(got, want); ok {
+		t.Fatalf("at %d,%d = %v, want %v", p.X, p.Y, got.At(p.X, p.Y), want.At(p.X, p.Y))
+	}
+}
+
+func TestPremultiplied(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, len(alphas), 1))
+	src16 := image.NewNRGBA64(src.Bounds())
+	for x, a := range alphas {
+		src.SetNRGBA(x, 0, color.NRGBA{255, 200, 100, a})
+		src16.SetNRGBA64(x, 0, color.NRGBA64{0xffff, 0xc8c8, 0x6464, uint16(a) * 0x101})
+	}
+
+	img := premultiplied(src)
+	if _, ok := img.(*image.RGBA); !ok {
+		t.Fatalf("premultiplied 8-bit image is a %T, want *image.RGBA", img)
+	}
+
+	img16 := premultiplied(src16)
+	if _, ok := img16.(*image.RGBA64); !ok {
+		t.Fatalf("premultiplied 16-bit image is a %T, want *image.RGBA64", img16)
+	}
+
+	for x := range alphas {
+		if got, want := img.At(x, 0), color.RGBAModel.Convert(src.At(x, 0)); got != want {
+			t.Errorf("alpha %d = %v, want %v", alphas[x], got, want)
+		}
+
+		if got, want := img16.At(x, 0), color.RGBA64Model.Convert(src16.At(x, 0)); got != want {
+			t.Errorf("16-bit alpha %d = %v, want %v", alphas[x], got, want)
+		}
+	}
+
+	// already premultiplied or opaque
+	rgba := image.NewRGBA(src.Bounds())
+	if premultiplied(rgba) != image.Image(rgba) {
+		t.Error("premultiplied RGBA image was copied")
+	}
+
+	gray := image.NewGray(src.Bounds())
+	if premultiplied(gray) != image.Image(gray) {
+		t.Error("premultiplied opaque image was copied")
+	}
+}
+
+func TestWatermarkOver(t *testing.T) {
+	bg := color.RGBA{20, 40, 60, 255}
+
+	// a column of each alpha, unscaled at the top left inset by 3%
+	src := image.NewNRGBA(image.Rect(0, 0, len(alphas), 4))
+	for y := 0; y < 4; y++ {
+		for x, a := range alphas {
+			src.SetNRGBA(x, y, color.NRGBA{255, 200, 100, a})
+		}
+	}
+	r := image.Rect(3, 3, 3+len(alphas), 7)
+
+	for _, opacity := range []float64{1, 0.5, 0.25} {
+		w := &watermark{img: premultiplied(src), position: "top-left", opacity: opacity}
+		got := testCanvas(100, 100, bg)
+		w.draw(got, xdraw.CatmullRom)
+
+		want := testCanvas(100, 100, bg)
+		if opacity == 1 {
+			draw.Draw(want, r, src, image.ZP, draw.Over)
+		} else {
+			mask := &image.Uniform{color.Alpha{uint8(opacity * 255)}}
+			draw.DrawMask(want, r, src, image.ZP, mask, image.ZP, draw.Over)
+		}
+
+		compare(t, got, want)
+	}
+}
+
+func TestWatermarkOverScaled(t *testing.T) {
+	bg := color.RGBA{20, 40, 60, 255}
+
+	// uniform watermarks scaled down from 200x20 to 94x9, centered
+	r := image.Rect(3, 45, 97, 54)
+
+	for _, a := range alphas {
+		c := color.NRGBA{255, 200, 100, a}
+		src := image.NewNRGBA(image.Rect(0, 0, 200, 20))
+		draw.Draw(src, src.Bounds(), &image.Uniform{c}, image.ZP, draw.Src)
+
+		w := &watermark{img: premultiplied(src), position: "center", opacity: 1}
+		got := testCanvas(100, 100, bg)
+		w.draw(got, xdraw.CatmullRom)
+
+		want := testCanvas(100, 100, bg)
+		draw.Draw(want, r, &image.Uniform{c}, image.ZP, draw.Over)
+
+		compare(t, got, want)
+	}
+}
+
+func TestWatermarkStraightAlpha(t *testing.T) {
+	bg := color.RGBA{0, 0, 0, 255}
+
+	// a half-transparent white watermark, unscaled at the top left
+	c := color.NRGBA{255, 255, 255, 128}
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(src, src.Bounds(), &image.Uniform{c}, image.ZP, draw.Src)
+	r := image.Rect(3, 3, 7, 7)
+
+	w := &watermark{img: premultiplied(src), position: "top-left", opacity: 1}
+	got := testCanvas(100, 100, bg)
+	w.draw(got, xdraw.CatmullRom)
+
+	want := testCanvas(100, 100, bg)
+	draw.Draw(want, r, src, image.ZP, draw.Over)
+	compare(t, got, want)
+
+	// blending the straight alpha as premultiplied must not pass
+	straight := &image.RGBA{Pix: src.Pix, Stride: src.Stride, Rect: src.Rect}
+	bad := testCanvas(100, 100, bg)
+	draw.Draw(bad, r, straight, image.ZP, draw.Over)
+	if _, ok := mismatch(bad, want); !ok {
+		t.Fatal("straight alpha blending matched premultiplied blending")
+	}
+
+	// nor an untouched canvas
+	if _, ok := mismatch(testCanvas(100, 100, bg), want); !ok {
+		t.Fatal("untouched canvas matched the watermark")
+	}
+}
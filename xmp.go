@@ -0,0 +1,171 @@
+package letterbox
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// XMP namespaces.
+const (
+	nsXMP = "http://ns.adobe.com/xap/1.0/"
+	nsCRS = "http://ns.adobe.com/camera-raw-settings/1.0/"
+)
+
+// sidecar is the subset of an XMP sidecar used for processing.
+type sidecar struct {
+	Rating  int
+	Label   string
+	HasCrop bool
+
+	// normalized crop edges, from 0-1
+	CropTop    float64
+	CropLeft   float64
+	CropBottom float64
+	CropRight  float64
+}
+
+// Rejected returns true if the image was rejected (a rating of -1 in Lightroom).
+func (s *sidecar) Rejected() bool {
+	return s.Rating < 0
+}
+
+// Crop returns the crop rect within r, or r when there is no crop.
+func (s *sidecar) Crop(r image.Rectangle) image.Rectangle {
+	if !s.HasCrop || s.CropRight <= s.CropLeft || s.CropBottom <= s.CropTop {
+		return r
+	}
+
+	w := float64(r.Dx())
+	h := float64(r.Dy())
+	c := image.Rect(
+		r.Min.X+int(s.CropLeft*w),
+		r.Min.Y+int(s.CropTop*h),
+		r.Min.X+int(s.CropRight*w),
+		r.Min.Y+int(s.CropBottom*h))
+
+	return c.Intersect(r)
+}
+
+// findSidecar returns the XMP sidecar path for an image, supporting
+// both "IMG.xmp" and "IMG.jpg.xmp" conventions, or an empty string.
+func findSidecar(path string) string {
+	if s := sidecarPath(path); exists(s) {
+		return s
+	}
+
+	if s := path + ".xmp"; exists(s) {
+		return s
+	}
+
+	return ""
+}
+
+// readSidecar returns the XMP sidecar for an image,
+// or nil when it does not have one.
+func readSidecar(path string) (*sidecar, error) {
+	name := findSidecar(path)
+	if name == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := parseSidecar(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+
+	return s, nil
+}
+
+// parseSidecar parses an XMP sidecar, properties may be
+// expressed as attributes or elements.
+func parseSidecar(r io.Reader) (*sidecar, error) {
+	var s sidecar
+	var prop xml.Name
+	d := xml.NewDecoder(r)
+
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return &s, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			prop = t.Name
+			for _, a := range t.Attr {
+				if err := s.set(a.Name, a.Value); err != nil {
+					return nil, err
+				}
+			}
+		case xml.CharData:
+			if v := strings.TrimSpace(string(t)); v != "" {
+				if err := s.set(prop, v); err != nil {
+					return nil, err
+				}
+			}
+		case xml.EndElement:
+			prop = xml.Name{}
+		}
+	}
+}
+
+// set a property.
+func (s *sidecar) set(name xml.Name, v string) (err error) {
+	switch name {
+	case xml.Name{Space: nsXMP, Local: "Rating"}:
+		s.Rating, err = strconv.Atoi(v)
+	case xml.Name{Space: nsXMP, Local: "Label"}:
+		s.Label = v
+	case xml.Name{Space: nsCRS, Local: "HasCrop"}:
+		s.HasCrop = strings.EqualFold(v, "true")
+	case xml.Name{Space: nsCRS, Local: "CropTop"}:
+		s.CropTop, err = strconv.ParseFloat(v, 64)
+	case xml.Name{Space: nsCRS, Local: "CropLeft"}:
+		s.CropLeft, err = strconv.ParseFloat(v, 64)
+	case xml.Name{Space: nsCRS, Local: "CropBottom"}:
+		s.CropBottom, err = strconv.ParseFloat(v, 64)
+	case xml.Name{Space: nsCRS, Local: "CropRight"}:
+		s.CropRight, err = strconv.ParseFloat(v, 64)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name.Local, err)
+	}
+
+	return nil
+}
+
+// writeSidecar writes an XMP sidecar next to the output image. The crop
+// has already been applied to the output so only the rating and label are kept.
+func writeSidecar(path string, s *sidecar) error {
+	var b bytes.Buffer
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	b.WriteString(` <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	b.WriteString(`  <rdf:Description rdf:about="" xmlns:xmp="` + nsXMP + `"`)
+	fmt.Fprintf(&b, "\n   xmp:Rating=\"%d\"", s.Rating)
+	if s.Label != "" {
+		b.WriteString("\n   xmp:Label=\"")
+		xml.EscapeText(&b, []byte(s.Label))
+		b.WriteString(`"`)
+	}
+	b.WriteString("/>\n")
+	b.WriteString(" </rdf:RDF>\n")
+	b.WriteString("</x:xmpmeta>\n")
+	return writeFileAtomic(sidecarPath(path), b.Bytes())
+}